@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/ubiquiti-community/unifi-rpc/pkg/config"
+	"github.com/ubiquiti-community/unifi-rpc/pkg/redact"
+	"github.com/ubiquiti-community/unifi-rpc/pkg/rpc"
+)
+
+// serverSettings are the flags of the server that are not part of
+// config.Config.
+type serverSettings struct {
+	Address        string        `yaml:"address"`
+	Port           int           `yaml:"port"`
+	BasePath       string        `yaml:"basePath"`
+	TLSCert        string        `yaml:"tlsCert"`
+	TLSKey         string        `yaml:"tlsKey"`
+	TLSSelfSigned  bool          `yaml:"tlsSelfSigned"`
+	APITokens      []string      `yaml:"apiTokens"`
+	RequestTimeout time.Duration `yaml:"requestTimeout"`
+	MaxBodyBytes   int64         `yaml:"maxBodyBytes"`
+	RateLimit      float64       `yaml:"rateLimit"`
+	RateBurst      int           `yaml:"rateBurst"`
+	Redfish        bool          `yaml:"redfish"`
+	DebugRoutes    bool          `yaml:"debugRoutes"`
+	LogFormat      string        `yaml:"logFormat"`
+	LogLevel       string        `yaml:"logLevel"`
+}
+
+// effectiveConfig is the body of GET /admin/config: what the server runs
+// with once the configuration file, the environment and the flags have
+// been merged.
+type effectiveConfig struct {
+	File    string         `yaml:"file"`
+	Profile string         `yaml:"profile,omitempty"`
+	Server  serverSettings `yaml:"server"`
+	Config  config.Config  `yaml:"config"`
+}
+
+// newEffectiveConfig returns the effectiveConfig of cfg and the server
+// flags, with the secrets of both redacted. The API tokens are listed by
+// their placeholder only, which still tells how many there are.
+func newEffectiveConfig(cfg config.Config, tokens [][]byte) effectiveConfig {
+	placeholders := make([]string, len(tokens))
+	for i := range placeholders {
+		placeholders[i] = redact.Placeholder
+	}
+	return effectiveConfig{
+		File:    filePath,
+		Profile: profile,
+		Server: serverSettings{
+			Address:        address,
+			Port:           port,
+			BasePath:       basePath,
+			TLSCert:        tlsCert,
+			TLSKey:         tlsKey,
+			TLSSelfSigned:  tlsSelfSigned,
+			APITokens:      placeholders,
+			RequestTimeout: requestTimeout,
+			MaxBodyBytes:   maxBodyBytes,
+			RateLimit:      rateLimit,
+			RateBurst:      rateBurst,
+			Redfish:        redfish,
+			DebugRoutes:    debugRoutes,
+			LogFormat:      logFormat,
+			LogLevel:       logLevel,
+		},
+		Config: cfg.Redacted(),
+	}
+}
+
+// adminConfigHandler answers with ec as YAML, in the keys of the
+// configuration file, so that it can be pasted into an issue. Even
+// redacted it names the controllers and machines, so it is only served
+// when the server requires API tokens.
+func adminConfigHandler(ec effectiveConfig, protected bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		if !protected {
+			writeJSONError(w, http.StatusForbidden, rpc.ReasonUnauthorized, "the configuration is only served when api-token is set")
+			return
+		}
+		b, err := yaml.Marshal(ec)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, rpc.ReasonInternal, "error encoding the configuration: "+err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/yaml")
+		_, _ = w.Write(b)
+	}
+}
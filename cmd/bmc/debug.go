@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"io"
+
+	"github.com/ubiquiti-community/unifi-rpc/pkg/rpc"
+)
+
+// runDebug implements "debug stats", which prints the stat/device response
+// of the controller for a switch exactly as received, to attach to reports
+// of ports that are shown wrongly.
+func runDebug(ctx context.Context, svc rpc.BMCService, args []string, out io.Writer) error {
+	if len(args) == 0 || args[0] != "stats" {
+		return errors.New("usage: debug stats --mac MAC [--host HOST]")
+	}
+
+	fs := flag.NewFlagSet("debug stats", flag.ContinueOnError)
+	fs.SetOutput(out)
+	mac := fs.String("mac", "", "MAC address of the switch")
+	host := fs.String("host", "", "host selecting the controller, as in the host field of an RPC request")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if *mac == "" {
+		return errors.New("--mac is required")
+	}
+
+	svc, err := svc.ForHost(*host)
+	if err != nil {
+		return err
+	}
+	raw, err := svc.DebugDeviceStats(ctx, *mac)
+	if err != nil {
+		return err
+	}
+	if _, err = out.Write(raw); err != nil {
+		return err
+	}
+	_, err = io.WriteString(out, "\n")
+	return err
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// unixPrefix selects a Unix domain socket in the -a flag.
+const unixPrefix = "unix:"
+
+// listen opens the listener selected by the -a and -p flags. An address of
+// the form unix:/path/to.sock listens on a Unix domain socket, ignoring the
+// port. A socket left behind by an unclean exit is replaced, and the socket
+// file is removed again when the server shuts down.
+func listen(address string, port int) (net.Listener, error) {
+	path, ok := strings.CutPrefix(address, unixPrefix)
+	if !ok {
+		return net.Listen("tcp", net.JoinHostPort(listenHost(address), strconv.Itoa(port)))
+	}
+
+	if path == "" {
+		return nil, errors.New("unix socket path must not be empty")
+	}
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode().Type() != fs.ModeSocket {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if err = os.Remove(path); err != nil {
+			return nil, fmt.Errorf("error removing stale socket: %w", err)
+		}
+	}
+	return net.Listen("unix", path)
+}
+
+// listenHost strips the brackets from an IPv6 listen address, so both ::1
+// and [::1] are accepted by -a.
+func listenHost(address string) string {
+	if strings.HasPrefix(address, "[") && strings.HasSuffix(address, "]") {
+		return address[1 : len(address)-1]
+	}
+	return address
+}
+
+// listenURL describes where ln accepts connections for the startup log.
+func listenURL(scheme string, ln net.Listener) string {
+	if ln.Addr().Network() == "unix" {
+		return unixPrefix + ln.Addr().String()
+	}
+	return scheme + "://" + ln.Addr().String()
+}
+
+// certificateHost returns the host the self-signed certificate is issued
+// for, which is localhost for a Unix socket.
+func certificateHost(address string) string {
+	if strings.HasPrefix(address, unixPrefix) {
+		return "localhost"
+	}
+	return listenHost(address)
+}
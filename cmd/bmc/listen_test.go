@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func Test_listen_unix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bmc.sock")
+
+	ln, err := listen(unixPrefix+path, 0)
+	if err != nil {
+		t.Fatalf("listen() error = %v", err)
+	}
+	if got := listenURL("http", ln); got != unixPrefix+path {
+		t.Errorf("listenURL() = %q, want %q", got, unixPrefix+path)
+	}
+	ln.Close()
+	if _, err = os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("socket file still exists after close: %v", err)
+	}
+
+	// A socket left behind by an unclean exit is replaced.
+	ln, err = listen(unixPrefix+path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	ln.Close()
+	ln, err = listen(unixPrefix+path, 0)
+	if err != nil {
+		t.Fatalf("listen() over a stale socket error = %v", err)
+	}
+	ln.Close()
+}
+
+func Test_listen_unixNotASocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := listen(unixPrefix+path, 0); err == nil {
+		t.Error("listen() replaced a regular file")
+	}
+}
+
+func Test_listen_ipv6(t *testing.T) {
+	for _, address := range []string{"::1", "[::1]"} {
+		ln, err := listen(address, 0)
+		if err != nil {
+			t.Skipf("IPv6 loopback unavailable: %v", err)
+		}
+		tcp := ln.Addr().(*net.TCPAddr)
+		if want := "http://" + net.JoinHostPort("::1", strconv.Itoa(tcp.Port)); listenURL("http", ln) != want {
+			t.Errorf("listenURL() = %q, want %q", listenURL("http", ln), want)
+		}
+		ln.Close()
+
+		if got := certificateHost(address); got != "::1" {
+			t.Errorf("certificateHost(%q) = %q, want ::1", address, got)
+		}
+	}
+}
@@ -1,10 +1,21 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/mux"
 
@@ -13,35 +24,568 @@ import (
 )
 
 var (
-	port     int
-	filePath string
-	address  string
-	cfg      config.Config
+	port            int
+	filePath        string
+	address         string
+	basePath        string
+	shutdownTimeout time.Duration
+	tlsCert         string
+	tlsKey          string
+	tlsSelfSigned   bool
+	apiTokens       string
+	requestTimeout  time.Duration
+	poeCacheTTL     time.Duration
+	maxRetries      int
+	dryRun          bool
+	readOnly        bool
+	maxBodyBytes    int64
+	strictRequests  bool
+	preflight       bool
+	preflightWait   time.Duration
+	logFormat       string
+	logLevel        string
+	showVersion     bool
+	watchInterval   time.Duration
+	redfish         bool
+	debugRoutes     bool
+	startLocked     bool
+	resetDwell      time.Duration
+	rateLimit       float64
+	keepAlive       time.Duration
+	callTimeout     time.Duration
+	maxCalls        int
+	maxCallWait     time.Duration
+	pingChecks      bool
+	statsParseMode  string
+	defaultPort     string
+	poeOnMode       string
+	preservePoEMode bool
+	verifySets      bool
+	enableWoL       bool
+	verifySettle    time.Duration
+	webhookURL      string
+	webhookTimeout  time.Duration
+	webhookRetries  int
+	stateFile       string
+	powerOnStagger  time.Duration
+	powerOnReserve  float64
+	staleFallback   bool
+	cycleStagger    time.Duration
+	site            string
+	caBundle        string
+	certFingerprint string
+	profile         string
+	rateBurst       int
+	serverOpts      serverOptions
+	cfg             config.Config
 )
 
+func fatal(logger *slog.Logger, msg string, err error) {
+	logger.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+func envOrDefault(key, def string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	return def
+}
+
+// expandPaths applies config.ExpandPath to the file names given by flags
+// rather than the configuration, so that ~ and environment variables work
+// the same in both.
+func expandPaths(paths ...*string) error {
+	for _, p := range paths {
+		v, err := config.ExpandPath(*p)
+		if err != nil {
+			return err
+		}
+		*p = v
+	}
+	return nil
+}
+
+// applyFlagOverrides copies explicitly set flags over values from the
+// configuration file.
+func applyFlagOverrides(cfg *config.Config) {
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "poe-cache-ttl":
+			cfg.PoECacheTTL = poeCacheTTL
+		case "watch-interval":
+			cfg.WatchInterval = watchInterval
+		case "reset-dwell":
+			cfg.ResetDwell = resetDwell
+		case "enable-wol":
+			cfg.EnableWoL = enableWoL
+		case "verify-sets":
+			cfg.VerifySets = verifySets
+		case "verify-settle":
+			cfg.VerifySettle = verifySettle
+		case "keepalive-interval":
+			cfg.KeepAliveInterval = keepAlive
+		case "cycle-stagger":
+			cfg.CycleStagger = cycleStagger
+		case "site":
+			cfg.Site = site
+		case "ca-bundle":
+			cfg.CABundle = caBundle
+		case "cert-fingerprint":
+			cfg.CertFingerprint = certFingerprint
+		case "max-retries":
+			cfg.MaxRetries = maxRetries
+		case "call-timeout":
+			cfg.CallTimeout = callTimeout
+		case "max-concurrent-calls":
+			cfg.MaxConcurrentCalls = maxCalls
+		case "max-call-wait":
+			cfg.MaxCallWait = maxCallWait
+		case "ping-checks-switch":
+			cfg.PingChecksSwitch = pingChecks
+		case "default-port":
+			cfg.DefaultPort = defaultPort
+		case "poe-on-mode":
+			if cfg.PoEModes == nil {
+				cfg.PoEModes = map[string]string{}
+			}
+			cfg.PoEModes[rpc.PowerStateOn] = poeOnMode
+		case "preserve-poe-mode":
+			cfg.PreservePoEMode = preservePoEMode
+		case "stats-parse-mode":
+			cfg.StatsParseMode = statsParseMode
+		case "webhook-url":
+			cfg.WebhookURL = webhookURL
+		case "webhook-timeout":
+			cfg.WebhookTimeout = webhookTimeout
+		case "webhook-retries":
+			cfg.WebhookRetries = webhookRetries
+		case "power-on-stagger":
+			cfg.PowerOnStagger = powerOnStagger
+		case "power-on-reserve-watts":
+			cfg.PowerOnReserveWatts = powerOnReserve
+		case "state-file":
+			cfg.StateFile = stateFile
+		case "stale-fallback":
+			cfg.StaleFallback = staleFallback
+		case "dry-run":
+			cfg.DryRun = dryRun
+		case "read-only":
+			cfg.ReadOnly = readOnly
+		case "strict":
+			cfg.StrictRequests = strictRequests
+		}
+	})
+}
+
+// newLogger returns the logger shared by the server and the service.
+func newLogger(w io.Writer, format, level string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("log-level: %w", err)
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	switch format {
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	case "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	}
+	return nil, fmt.Errorf("log-format %q is not json or text", format)
+}
+
+// applyEnvOverrides takes the controller credentials and certificate pin
+// from the environment when set there, so they can be injected as secrets
+// instead of being written to the configuration file.
+func applyEnvOverrides(cfg *config.Config) {
+	if v, ok := os.LookupEnv("UNIFI_RPC_USERNAME"); ok {
+		cfg.Username = v
+	}
+	if v, ok := os.LookupEnv("UNIFI_RPC_PASSWORD"); ok {
+		cfg.Password = v
+	}
+	if v, ok := os.LookupEnv("UNIFI_RPC_CERT_FINGERPRINT"); ok {
+		cfg.CertFingerprint = v
+	}
+}
+
+// runPreflight checks that the controllers are reachable before serving, so
+// a bad endpoint or password fails the deployment instead of the first
+// request.
+func runPreflight(svc rpc.BMCService, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return svc.Preflight(ctx)
+}
+
+// validateEndpoint checks that a controller endpoint is an absolute URL.
+// IPv6 literals must be bracketed, as in https://[fd00::1]:8443.
+func validateEndpoint(endpoint string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return fmt.Errorf("apiEndpoint %q is not a valid URL: %w", endpoint, err)
+	}
+	if u.Hostname() == "" {
+		return fmt.Errorf("apiEndpoint %q has no host", endpoint)
+	}
+	if strings.Count(u.Host, ":") > 1 && !strings.HasPrefix(u.Host, "[") {
+		return fmt.Errorf("apiEndpoint %q must bracket its IPv6 address", endpoint)
+	}
+	return nil
+}
+
+// validateConfig checks cfg and the flags that are not part of it. Every
+// problem found is reported, joined with errors.Join, so that a single run
+// lists all of them.
+func validateConfig(cfg config.Config) error {
+	var errs []error
+	// Port 0 has the kernel pick a free port, see /version.
+	if port < 0 || port > 65535 {
+		errs = append(errs, fmt.Errorf("port %d is out of range", port))
+	}
+	if path, ok := strings.CutPrefix(address, unixPrefix); ok {
+		if fi, err := os.Stat(filepath.Dir(path)); err != nil || !fi.IsDir() {
+			errs = append(errs, fmt.Errorf("directory of unix socket %s does not exist", path))
+		}
+	}
+	if basePath != "" && (!strings.HasPrefix(basePath, "/") || strings.ContainsAny(basePath, "{}?#")) {
+		errs = append(errs, fmt.Errorf("base-path %q must start with / and hold no route variables, query or fragment", basePath))
+	}
+	if cfg.MaxRetries < 0 {
+		errs = append(errs, errors.New("max-retries must not be negative"))
+	}
+	if cfg.WatchInterval < 0 {
+		errs = append(errs, errors.New("watch-interval must not be negative"))
+	}
+	if cfg.ResetDwell < 0 {
+		errs = append(errs, errors.New("reset-dwell must not be negative"))
+	}
+	if cfg.VerifySettle < 0 {
+		errs = append(errs, errors.New("verify-settle must not be negative"))
+	}
+	if cfg.CallTimeout < 0 {
+		errs = append(errs, errors.New("call-timeout must not be negative"))
+	}
+	if cfg.MaxConcurrentCalls < 0 || cfg.MaxCallWait < 0 {
+		errs = append(errs, errors.New("max-concurrent-calls and max-call-wait must not be negative"))
+	}
+	if cfg.KeepAliveInterval < 0 {
+		errs = append(errs, errors.New("keepalive-interval must not be negative"))
+	}
+	if cfg.CycleStagger < 0 {
+		errs = append(errs, errors.New("cycle-stagger must not be negative"))
+	}
+	if cfg.WebhookTimeout < 0 || cfg.WebhookRetries < 0 {
+		errs = append(errs, errors.New("webhook-timeout and webhook-retries must not be negative"))
+	}
+	if cfg.PowerOnStagger < 0 || cfg.PowerOnReserveWatts < 0 {
+		errs = append(errs, errors.New("power-on-stagger and power-on-reserve-watts must not be negative"))
+	}
+	for _, p := range cfg.CriticalPorts {
+		if p < 1 {
+			errs = append(errs, fmt.Errorf("criticalPorts: %d is not a port number", p))
+		}
+	}
+	if cfg.APIEndpoint == "" && len(cfg.Controllers) == 0 {
+		errs = append(errs, errors.New("apiEndpoint must be set"))
+	}
+	if cfg.APIEndpoint != "" {
+		if err := validateEndpoint(cfg.APIEndpoint); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	hosts := map[string]bool{}
+	for i, c := range cfg.Controllers {
+		if c.Host == "" || c.APIEndpoint == "" {
+			errs = append(errs, fmt.Errorf("controllers[%d]: host and apiEndpoint must be set", i))
+			continue
+		}
+		if err := validateEndpoint(c.APIEndpoint); err != nil {
+			errs = append(errs, fmt.Errorf("controllers[%d]: %w", i, err))
+		}
+		h := strings.ToLower(c.Host)
+		if hosts[h] {
+			errs = append(errs, fmt.Errorf("controllers[%d]: host %q is configured more than once", i, c.Host))
+		}
+		hosts[h] = true
+	}
+	if cfg.CABundle != "" && cfg.Insecure != nil && *cfg.Insecure {
+		errs = append(errs, errors.New("insecure cannot be combined with caBundle"))
+	}
+	if cfg.CertFingerprint != "" && (cfg.CABundle != "" || (cfg.Insecure != nil && *cfg.Insecure)) {
+		errs = append(errs, errors.New("certFingerprint cannot be combined with caBundle or insecure"))
+	}
+	if rateLimit < 0 || rateBurst < 0 {
+		errs = append(errs, errors.New("rate-limit and rate-burst must not be negative"))
+	}
+	if (tlsCert == "") != (tlsKey == "") {
+		errs = append(errs, errors.New("tls-cert and tls-key must be provided together"))
+	}
+	if tlsSelfSigned && tlsCert != "" {
+		errs = append(errs, errors.New("tls-self-signed cannot be combined with tls-cert and tls-key"))
+	}
+	if serverOpts.h2c && (tlsSelfSigned || tlsCert != "") {
+		errs = append(errs, errors.New("h2c only applies to plaintext, HTTP/2 is always enabled with TLS"))
+	}
+	if serverOpts.readHeaderTimeout < 0 || serverOpts.readTimeout < 0 || serverOpts.writeTimeout < 0 || serverOpts.idleTimeout < 0 || serverOpts.maxHeaderBytes < 0 {
+		errs = append(errs, errors.New("server timeouts and max-header-bytes must not be negative"))
+	}
+	if serverOpts.writeTimeout > 0 && requestTimeout > 0 && serverOpts.writeTimeout <= requestTimeout {
+		errs = append(errs, fmt.Errorf("write-timeout %v must be longer than request-timeout %v", serverOpts.writeTimeout, requestTimeout))
+	}
+	return errors.Join(errs...)
+}
+
+// newRouter returns the router of every route of svc, served under
+// basePath when it is set. Requests outside of basePath are answered with
+// 404 like unknown routes. The prefix is part of every route rather than a
+// subrouter, which answers method mismatches with 404. The admin routes are
+// only served when protected, that is when the server requires API tokens.
+func newRouter(svc rpc.BMCService, basePath string, protected bool) *mux.Router {
+	r := mux.NewRouter()
+
+	r.HandleFunc(basePath+"/device/{mac}/port/{port}/rpc", svc.RPCHandler).Methods("POST")
+	r.HandleFunc(basePath+"/device/{mac}/rpc", svc.RPCHandler).Methods("POST")
+	r.HandleFunc(basePath+"/device/{mac}/outlet/{outlet}/rpc", svc.OutletRPCHandler).Methods("POST")
+	r.HandleFunc(basePath+"/device/{mac}/power/total", svc.PowerTotalHandler).Methods("GET")
+	r.HandleFunc(basePath+"/device/{mac}/ports", svc.PortsHandler).Methods("GET")
+	r.HandleFunc(basePath+"/device/{mac}/ports/{port}/power", svc.PortPowerHandler).Methods("GET", "PUT")
+	r.HandleFunc(basePath+"/device/{mac}/ports/{port}/history", svc.PowerHistoryHandler).Methods("GET")
+	r.HandleFunc(basePath+"/ws", svc.WatchHandler).Methods("GET")
+	r.HandleFunc(basePath+"/version", versionHandler).Methods("GET")
+	r.HandleFunc(basePath+"/schema", rpc.SchemaHandler).Methods("GET")
+	r.HandleFunc(basePath+"/admin/lock", requireTokens(protected, svc.LockHandler)).Methods("GET", "POST")
+	r.HandleFunc(basePath+"/admin/status", requireTokens(protected, svc.AdminStatusHandler)).Methods("GET")
+	if redfish {
+		registerRedfish(r, svc, basePath)
+	}
+	if debugRoutes {
+		// The raw device record includes controller internals that the
+		// other routes never expose.
+		r.HandleFunc(basePath+"/debug/device/{mac}/stats", svc.DebugStatsHandler).Methods("GET")
+	}
+	r.NotFoundHandler = notFoundHandler()
+	r.MethodNotAllowedHandler = methodNotAllowedHandler(r)
+	return r
+}
+
 func main() {
-	flag.IntVar(&port, "p", 5000, "port to listen on")
+	flag.IntVar(&port, "p", 5000, "port to listen on, 0 picks a free one")
 	flag.StringVar(&address, "a", "0.0.0.0", "address to listen on")
-	flag.StringVar(&filePath, "c", "config.yaml", "configuration yaml file")
+	flag.StringVar(&basePath, "base-path", envOrDefault("UNIFI_RPC_BASE_PATH", ""), "path prefix of every route, as in /bmc/unifi, for reverse proxies that do not strip it")
+	// The configuration file is taken from -c, then UNIFI_RPC_CONFIG, then
+	// the first of config.yaml, config.yml and config.json in the working
+	// directory. GetConfig fails on a missing file in every case rather
+	// than starting with defaults.
+	flag.StringVar(&filePath, "c", envOrDefault("UNIFI_RPC_CONFIG", ""), "configuration file, YAML or JSON by its extension (default: config.yaml, config.yml or config.json)")
+	flag.StringVar(&profile, "profile", envOrDefault("UNIFI_RPC_PROFILE", ""), "named profile of the configuration file to merge over its top level keys")
+	flag.DurationVar(&shutdownTimeout, "shutdown-timeout", 30*time.Second, "maximum time to wait for active requests to drain on shutdown")
+	flag.StringVar(&tlsCert, "tls-cert", envOrDefault("UNIFI_RPC_TLS_CERT", ""), "TLS certificate file, enables HTTPS together with tls-key")
+	flag.StringVar(&tlsKey, "tls-key", envOrDefault("UNIFI_RPC_TLS_KEY", ""), "TLS private key file, enables HTTPS together with tls-cert")
+	flag.BoolVar(&tlsSelfSigned, "tls-self-signed", false, "serve HTTPS with an auto-generated self-signed certificate")
+	flag.StringVar(&apiTokens, "api-token", envOrDefault("UNIFI_RPC_API_TOKEN", ""), "comma separated bearer tokens accepted by the RPC endpoint")
+	flag.DurationVar(&requestTimeout, "request-timeout", 60*time.Second, "maximum time to spend on a single RPC request, 0 disables the limit")
+	flag.DurationVar(&serverOpts.readHeaderTimeout, "read-header-timeout", 10*time.Second, "maximum time for a client to send the request headers")
+	flag.DurationVar(&serverOpts.readTimeout, "read-timeout", 30*time.Second, "maximum time for a client to send the whole request, 0 disables the limit")
+	flag.DurationVar(&serverOpts.writeTimeout, "write-timeout", 90*time.Second, "maximum time from reading a request to finishing its answer, must exceed request-timeout, 0 disables the limit")
+	flag.DurationVar(&serverOpts.idleTimeout, "idle-timeout", 120*time.Second, "how long an idle keep-alive connection is kept open")
+	flag.IntVar(&serverOpts.maxHeaderBytes, "max-header-bytes", 64<<10, "maximum size of the request headers")
+	flag.BoolVar(&serverOpts.h2c, "h2c", false, "serve HTTP/2 without TLS to clients that ask for it")
+	flag.DurationVar(&poeCacheTTL, "poe-cache-ttl", config.Default().PoECacheTTL, "how long power state reads are cached, 0 disables the cache")
+	flag.IntVar(&maxRetries, "max-retries", config.Default().MaxRetries, "retries for controller calls that fail with a transient network error, or a 429 or 5xx answer")
+	flag.DurationVar(&callTimeout, "call-timeout", config.Default().CallTimeout, "maximum time to spend on a single controller request, 0 disables the limit")
+	flag.IntVar(&maxCalls, "max-concurrent-calls", config.Default().MaxConcurrentCalls, "controller calls in flight at once across all controllers, 0 disables the limit")
+	flag.DurationVar(&maxCallWait, "max-call-wait", config.Default().MaxCallWait, "how long a controller call queues for max-concurrent-calls before failing with 503, 0 waits for the request deadline")
+	flag.BoolVar(&dryRun, "dry-run", false, "log device updates instead of sending them to the controller")
+	flag.BoolVar(&readOnly, "read-only", config.Default().ReadOnly, "refuse every change with 403, for monitoring-only instances")
+	flag.Int64Var(&maxBodyBytes, "max-body-bytes", 8<<10, "maximum size of a request body, 0 disables the limit")
+	flag.BoolVar(&strictRequests, "strict", false, "reject requests with unknown fields")
+	flag.BoolVar(&preflight, "preflight", false, "log in to every controller before serving and exit if that fails")
+	flag.DurationVar(&preflightWait, "preflight-timeout", 10*time.Second, "maximum time to spend on the preflight check")
+	flag.StringVar(&logFormat, "log-format", envOrDefault("UNIFI_RPC_LOG_FORMAT", "json"), "log format, json or text")
+	flag.StringVar(&logLevel, "log-level", "info", "minimum log level, one of debug, info, warn or error")
+	flag.DurationVar(&watchInterval, "watch-interval", config.Default().WatchInterval, "how often devices watched over /ws are polled")
+	flag.DurationVar(&resetDwell, "reset-dwell", config.Default().ResetDwell, "how long a port stays off when its power state is set to reset")
+	flag.BoolVar(&enableWoL, "enable-wol", config.Default().EnableWoL, "send a Wake-on-LAN packet to the machines of wolMACs when their port is set on")
+	flag.BoolVar(&verifySets, "verify-sets", config.Default().VerifySets, "read the port back after every power change and fail when the switch did not apply it")
+	flag.DurationVar(&verifySettle, "verify-settle", config.Default().VerifySettle, "how long verify-sets waits for the switch to apply a change")
+	flag.Float64Var(&rateLimit, "rate-limit", 10, "requests per second allowed from a single client IP, 0 disables rate limiting")
+	flag.IntVar(&rateBurst, "rate-burst", 20, "requests a single client IP may send in a burst above rate-limit")
+	flag.DurationVar(&keepAlive, "keepalive-interval", config.Default().KeepAliveInterval, "how often the connection to each controller is checked, 0 disables the check")
+	flag.StringVar(&caBundle, "ca-bundle", config.Default().CABundle, "PEM file with the CAs that sign the controller certificates, enables certificate verification")
+	flag.StringVar(&certFingerprint, "cert-fingerprint", config.Default().CertFingerprint, "SHA256:... fingerprint of the only controller certificate accepted, also read from UNIFI_RPC_CERT_FINGERPRINT")
+	flag.StringVar(&site, "site", config.Default().Site, "UniFi site of the devices, controllers may set their own")
+	flag.DurationVar(&cycleStagger, "cycle-stagger", config.Default().CycleStagger, "delay between the ports power cycled by powerCycleAll")
+	flag.DurationVar(&powerOnStagger, "power-on-stagger", config.Default().PowerOnStagger, "delay between the ports turned on by setPowerStateBatch, 0 turns them on together")
+	flag.Float64Var(&powerOnReserve, "power-on-reserve-watts", config.Default().PowerOnReserveWatts, "PoE budget left on the switch before setPowerStateBatch turns on the next port, 0 disables the check")
+	flag.StringVar(&poeOnMode, "poe-on-mode", "auto", "PoE mode that turns ports on, one of auto, pasv24 or passthrough, overriding poeModes.on")
+	flag.BoolVar(&preservePoEMode, "preserve-poe-mode", config.Default().PreservePoEMode, "only turn on ports that are off, leaving PoE modes set by hand in the controller alone")
+	flag.StringVar(&defaultPort, "default-port", config.Default().DefaultPort, "port, or port alias, of RPC requests to /device/{mac}/rpc")
+	flag.StringVar(&statsParseMode, "stats-parse-mode", config.Default().StatsParseMode, "what to do about malformed port table rows: strict fails the read, lenient reports them as warnings")
+	flag.StringVar(&webhookURL, "webhook-url", config.Default().WebhookURL, "URL that a JSON event is posted to for every power change")
+	flag.DurationVar(&webhookTimeout, "webhook-timeout", config.Default().WebhookTimeout, "timeout of a single webhook delivery attempt")
+	flag.IntVar(&webhookRetries, "webhook-retries", config.Default().WebhookRetries, "how often a failed webhook delivery is retried")
+	flag.BoolVar(&pingChecks, "ping-checks-switch", config.Default().PingChecksSwitch, "make the ping method check that the device answers the controller")
+	flag.StringVar(&stateFile, "state-file", config.Default().StateFile, "file the history of power changes is persisted to, empty keeps it in memory")
+	flag.BoolVar(&staleFallback, "stale-fallback", config.Default().StaleFallback, "answer power state reads with the last state set, flagged as stale, while the controller is unreachable")
+	flag.BoolVar(&redfish, "redfish", false, "serve a Redfish power control shim under /redfish/v1/Systems")
+	flag.BoolVar(&startLocked, "start-locked", false, "refuse power changes until unlocked through POST /admin/lock")
+	flag.BoolVar(&debugRoutes, "debug-routes", false, "serve the raw controller data of a device at /debug/device/{mac}/stats")
+	flag.BoolVar(&showVersion, "version", false, "print the build information and exit")
 	flag.Parse()
 
-	cfg, err := config.GetConfig(filePath)
+	if showVersion {
+		printVersion(os.Stdout)
+		return
+	}
+
+	// Subcommands print their result on stdout, so logs go to stderr.
+	logOut := os.Stdout
+	if flag.NArg() > 0 {
+		logOut = os.Stderr
+	}
+	logger, err := newLogger(logOut, logFormat, logLevel)
 	if err != nil {
-		log.Fatalf("error reading YAML file: %v", err)
+		fatal(slog.New(slog.NewJSONHandler(logOut, nil)), "invalid logging flags", err)
 	}
 
-	svc := rpc.NewBMCService(cfg)
+	if filePath == "" {
+		filePath = config.FindConfig(".")
+	}
+	if err = expandPaths(&filePath, &tlsCert, &tlsKey); err != nil {
+		fatal(logger, "invalid file flags", err)
+	}
+	cfg, err := config.GetProfileConfig(filePath, profile)
+	if err != nil {
+		fatal(logger, "error reading configuration file", err)
+	}
 
-	r := mux.NewRouter()
+	applyEnvOverrides(&cfg)
+	applyFlagOverrides(&cfg)
+	if err = cfg.ExpandPaths(); err != nil {
+		fatal(logger, "invalid configuration", err)
+	}
+
+	if err = validateConfig(cfg); err != nil {
+		fatal(logger, "invalid configuration", err)
+	}
+	basePath = strings.TrimSuffix(basePath, "/")
+
+	shutdownTracing, err := setupTracing(context.Background())
+	if err != nil {
+		fatal(logger, "error setting up tracing", err)
+	}
 
-	r.HandleFunc("/device/{mac}/port/{port}/rpc", svc.RPCHandler).Methods("POST")
+	svc, err := rpc.NewBMCService(cfg, logger)
+	if err != nil {
+		fatal(logger, "error creating BMC service", err)
+	}
+	if cfg.DryRun {
+		logger.Warn("dry run enabled, device updates are logged but not sent to the controller")
+	}
+	if cfg.ReadOnly {
+		logger.Info("read-only, every change is refused")
+	}
 
-	http.Handle("/", r)
+	if flag.NArg() > 0 {
+		if err = runCommand(svc, flag.Args(), os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
 
-	fmt.Printf("Server is running on http://%s:%d", address, port)
-	err = http.ListenAndServe(fmt.Sprintf("%s:%d", address, port), nil)
+	if startLocked {
+		svc.SetLocked(true)
+	}
 
+	if preflight {
+		if err = runPreflight(svc, preflightWait); err != nil {
+			fatal(logger, "preflight check failed", err)
+		}
+		logger.Info("preflight check passed")
+	}
+
+	tokens := parseTokens(apiTokens)
+	r := newRouter(svc, basePath, len(tokens) > 0)
+	// Unlike the other routes it needs every source of the configuration.
+	r.HandleFunc(basePath+"/admin/config", adminConfigHandler(newEffectiveConfig(cfg, tokens), len(tokens) > 0)).Methods("GET")
+
+	r.Use(tracingMiddleware())
+	r.Use(loggingMiddleware(logger))
+	r.Use(rateLimitMiddleware(rateLimit, rateBurst))
+	r.Use(authMiddleware(tokens, basePath))
+	r.Use(timeoutMiddleware(requestTimeout))
+	r.Use(bodyMiddleware(maxBodyBytes))
+
+	srv := newServer(r, serverOpts)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if tlsSelfSigned {
+		var cert tls.Certificate
+		cert, err = selfSignedCertificate(certificateHost(address))
+		if err != nil {
+			fatal(logger, "error generating self-signed certificate", err)
+		}
+		srv.TLSConfig = &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		}
+	}
+
+	ln, err := listen(address, port)
 	if err != nil {
-		log.Fatalf("error starting server: %v", err)
+		fatal(logger, "error listening", err)
+	}
+
+	scheme := "http"
+	if tlsSelfSigned || tlsCert != "" {
+		scheme = "https"
+	}
+	u := listenURL(scheme, ln)
+	serverURL.Store(u)
+
+	serveErr := make(chan error, 1)
+	go func() {
+		switch {
+		case tlsSelfSigned:
+			logger.Info("server is running", "url", u, "tls", "self-signed")
+			serveErr <- srv.ServeTLS(ln, "", "")
+		case tlsCert != "":
+			logger.Info("server is running", "url", u)
+			serveErr <- srv.ServeTLS(ln, tlsCert, tlsKey)
+		default:
+			logger.Info("server is running", "url", u)
+			serveErr <- srv.Serve(ln)
+		}
+	}()
+
+	select {
+	case err = <-serveErr:
+		if !errors.Is(err, http.ErrServerClosed) {
+			fatal(logger, "error starting server", err)
+		}
+		return
+	case <-ctx.Done():
+		stop()
 	}
+
+	logger.Info("shutting down, draining active requests", "timeout", shutdownTimeout)
+	start := time.Now()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err = srv.Shutdown(shutdownCtx); err != nil {
+		logger.Error("error draining active requests", "inFlight", svc.InFlight(), "error", err)
+	}
+	svc.Close()
+	if err = shutdownTracing(shutdownCtx); err != nil {
+		logger.Error("error flushing traces", "error", err)
+	}
+
+	logger.Info("server stopped", "drain", time.Since(start))
 }
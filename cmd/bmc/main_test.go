@@ -1,6 +1,20 @@
 package main
 
-import "testing"
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ubiquiti-community/unifi-rpc/pkg/config"
+	"github.com/ubiquiti-community/unifi-rpc/pkg/rpc"
+)
 
 func Test_greet(t *testing.T) {
 	want := "Hi!"
@@ -8,3 +22,256 @@ func Test_greet(t *testing.T) {
 		t.Errorf("greet() = %v, want %v", got, want)
 	}
 }
+
+func Test_validateConfig(t *testing.T) {
+	cfg := config.Config{APIEndpoint: "https://10.0.0.1"}
+
+	tests := []struct {
+		name       string
+		cert       string
+		key        string
+		selfSigned bool
+		wantErr    bool
+	}{
+		{name: "plaintext"},
+		{name: "cert and key", cert: "tls.crt", key: "tls.key"},
+		{name: "cert without key", cert: "tls.crt", wantErr: true},
+		{name: "key without cert", key: "tls.key", wantErr: true},
+		{name: "self-signed", selfSigned: true},
+		{name: "self-signed with cert", cert: "tls.crt", key: "tls.key", selfSigned: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			port, tlsCert, tlsKey, tlsSelfSigned = 5000, tt.cert, tt.key, tt.selfSigned
+			if err := validateConfig(cfg); (err != nil) != tt.wantErr {
+				t.Errorf("validateConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	for p, wantErr := range map[int]bool{0: false, -1: true, 65536: true} {
+		port, tlsCert, tlsKey, tlsSelfSigned = p, "", "", false
+		if err := validateConfig(cfg); (err != nil) != wantErr {
+			t.Errorf("validateConfig() with port %d error = %v, wantErr %v", p, err, wantErr)
+		}
+	}
+	port = 5000
+
+	for p, wantErr := range map[string]bool{"/bmc/unifi/": false, "bmc": true, "/bmc/{mac}": true} {
+		basePath = p
+		if err := validateConfig(cfg); (err != nil) != wantErr {
+			t.Errorf("validateConfig() with base-path %q error = %v, wantErr %v", p, err, wantErr)
+		}
+	}
+	basePath = ""
+}
+
+func Test_validateConfig_Controllers(t *testing.T) {
+	port, tlsCert, tlsKey, tlsSelfSigned = 5000, "", "", false
+	insecure, secure := true, false
+
+	tests := []struct {
+		name    string
+		cfg     config.Config
+		wantErr bool
+	}{
+		{name: "controllers only", cfg: config.Config{Controllers: []config.Controller{
+			{Host: "rack1", APIEndpoint: "https://10.0.0.1"},
+		}}},
+		{name: "missing endpoint", cfg: config.Config{Controllers: []config.Controller{
+			{Host: "rack1"},
+		}}, wantErr: true},
+		{name: "duplicate host", cfg: config.Config{Controllers: []config.Controller{
+			{Host: "rack1", APIEndpoint: "https://10.0.0.1"},
+			{Host: "RACK1", APIEndpoint: "https://10.0.0.2"},
+		}}, wantErr: true},
+		{name: "IPv6 endpoint", cfg: config.Config{Controllers: []config.Controller{
+			{Host: "::1", APIEndpoint: "https://[::1]:8443"},
+		}}},
+		{name: "unbracketed IPv6 endpoint", cfg: config.Config{Controllers: []config.Controller{
+			{Host: "::1", APIEndpoint: "https://::1:8443"},
+		}}, wantErr: true},
+		{name: "endpoint without scheme", cfg: config.Config{APIEndpoint: "10.0.0.1"}, wantErr: true},
+		{name: "CA bundle", cfg: config.Config{APIEndpoint: "https://10.0.0.1", CABundle: "ca.pem", Insecure: &secure}},
+		{name: "CA bundle with insecure", cfg: config.Config{APIEndpoint: "https://10.0.0.1", CABundle: "ca.pem", Insecure: &insecure}, wantErr: true},
+		{name: "cert fingerprint", cfg: config.Config{APIEndpoint: "https://10.0.0.1", CertFingerprint: "SHA256:abc"}},
+		{name: "cert fingerprint with CA bundle", cfg: config.Config{APIEndpoint: "https://10.0.0.1", CertFingerprint: "SHA256:abc", CABundle: "ca.pem"}, wantErr: true},
+		{name: "cert fingerprint with insecure", cfg: config.Config{APIEndpoint: "https://10.0.0.1", CertFingerprint: "SHA256:abc", Insecure: &insecure}, wantErr: true},
+		{name: "nothing configured", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateConfig(tt.cfg); (err != nil) != tt.wantErr {
+				t.Errorf("validateConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_validateConfig_ReportsAll(t *testing.T) {
+	port, tlsCert, tlsKey, tlsSelfSigned = 70000, "tls.crt", "", false
+	defer func() { port, tlsCert = 5000, "" }()
+	cfg := config.Config{
+		CriticalPorts: []int{0},
+		ResetDwell:    -time.Second,
+		Controllers: []config.Controller{
+			{Host: "rack1"},
+			{Host: "rack2", APIEndpoint: "10.0.0.2"},
+		},
+	}
+
+	err := validateConfig(cfg)
+	if err == nil {
+		t.Fatal("validateConfig() accepted the configuration")
+	}
+	for _, want := range []string{
+		"port 70000 is out of range",
+		"reset-dwell must not be negative",
+		"criticalPorts: 0 is not a port number",
+		"controllers[0]: host and apiEndpoint must be set",
+		"controllers[1]: ",
+		"tls-cert and tls-key must be provided together",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("validateConfig() error = %q, want it to report %q", err, want)
+		}
+	}
+	if n := len(err.(interface{ Unwrap() []error }).Unwrap()); n != 6 {
+		t.Errorf("validateConfig() reported %d problems, want 6", n)
+	}
+}
+
+func Test_applyEnvOverrides(t *testing.T) {
+	t.Setenv("UNIFI_RPC_PASSWORD", "from-env")
+	t.Setenv("UNIFI_RPC_CERT_FINGERPRINT", "SHA256:from-env")
+
+	cfg := config.Config{Username: "admin", Password: "from-file"}
+	applyEnvOverrides(&cfg)
+	if cfg.Password != "from-env" {
+		t.Errorf("Password = %q, want the environment to take precedence", cfg.Password)
+	}
+	if cfg.CertFingerprint != "SHA256:from-env" {
+		t.Errorf("CertFingerprint = %q, want it taken from the environment", cfg.CertFingerprint)
+	}
+	if cfg.Username != "admin" {
+		t.Errorf("Username = %q, want it kept from the file", cfg.Username)
+	}
+}
+
+func Test_applyEnvOverrides_Profile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	content := "username: admin\nprofiles:\n  prod:\n    apiEndpoint: https://unifi.prod.example.com\n    password: from-profile\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("UNIFI_RPC_PASSWORD", "from-env")
+
+	cfg, err := config.GetProfileConfig(path, "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	applyEnvOverrides(&cfg)
+	if cfg.APIEndpoint != "https://unifi.prod.example.com" {
+		t.Errorf("APIEndpoint = %q, want the one of the prod profile", cfg.APIEndpoint)
+	}
+	if cfg.Password != "from-env" {
+		t.Errorf("Password = %q, want the environment to take precedence over the profile", cfg.Password)
+	}
+}
+
+func Test_newLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := newLogger(&buf, "text", "warn")
+	if err != nil {
+		t.Fatalf("newLogger() error = %v", err)
+	}
+	logger.Info("dropped")
+	logger.Warn("kept")
+	if got := buf.String(); strings.Contains(got, "dropped") || !strings.Contains(got, "level=WARN msg=kept") {
+		t.Errorf("text logger at warn wrote %q", got)
+	}
+
+	if _, err = newLogger(&buf, "logfmt", "info"); err == nil {
+		t.Error("newLogger() accepted an unknown format")
+	}
+	if _, err = newLogger(&buf, "json", "verbose"); err == nil {
+		t.Error("newLogger() accepted an unknown level")
+	}
+}
+
+func Test_selfSignedCertificate(t *testing.T) {
+	cert, err := selfSignedCertificate("127.0.0.1")
+	if err != nil {
+		t.Fatalf("selfSignedCertificate() error = %v", err)
+	}
+	if len(cert.Certificate) != 1 {
+		t.Errorf("selfSignedCertificate() returned %d certificates, want 1", len(cert.Certificate))
+	}
+}
+
+func Test_runPreflight(t *testing.T) {
+	err := runPreflight(&fakeBMC{preflightErr: errors.New("connection refused")}, time.Second)
+	if err == nil {
+		t.Error("runPreflight() did not return the preflight error")
+	}
+}
+
+func Test_newRouter_BasePath(t *testing.T) {
+	fc := rpc.NewFakeController()
+	fc.AddSwitch("aa:bb:cc:dd:ee:ff", 4)
+	r := newRouter(rpc.NewFakeBMCService(fc, nil), "/bmc/unifi", true)
+	r.Use(authMiddleware(parseTokens("secret"), "/bmc/unifi"))
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		token  bool
+		want   int
+	}{
+		{name: "rpc", method: http.MethodPost, path: "/bmc/unifi/device/aa:bb:cc:dd:ee:ff/port/1/rpc", body: `{"id":1,"method":"getPowerState"}`, token: true, want: http.StatusOK},
+		{name: "version skips auth", method: http.MethodGet, path: "/bmc/unifi/version", want: http.StatusOK},
+		{name: "admin status", method: http.MethodGet, path: "/bmc/unifi/admin/status", token: true, want: http.StatusOK},
+		{name: "admin lock", method: http.MethodGet, path: "/bmc/unifi/admin/lock", token: true, want: http.StatusOK},
+		{name: "unprefixed rpc", method: http.MethodPost, path: "/device/aa:bb:cc:dd:ee:ff/port/1/rpc", body: `{"id":1,"method":"getPowerState"}`, token: true, want: http.StatusNotFound},
+		{name: "unprefixed version", method: http.MethodGet, path: "/version", want: http.StatusNotFound},
+		{name: "wrong method", method: http.MethodGet, path: "/bmc/unifi/device/aa:bb:cc:dd:ee:ff/port/1/rpc", token: true, want: http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.token {
+				req.Header.Set("Authorization", "Bearer secret")
+			}
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+		})
+	}
+}
+
+func Test_newRouter_AdminRoutesNeedTokens(t *testing.T) {
+	fc := rpc.NewFakeController()
+	fc.AddSwitch("aa:bb:cc:dd:ee:ff", 4)
+	svc := rpc.NewFakeBMCService(fc, nil)
+	r := newRouter(svc, "", false)
+	r.Use(authMiddleware(nil, ""))
+
+	for _, req := range []struct{ method, path string }{
+		{http.MethodGet, "/admin/lock"},
+		{http.MethodPost, "/admin/lock"},
+		{http.MethodGet, "/admin/status"},
+	} {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(req.method, req.path, strings.NewReader(`{"locked":true}`)))
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("%s %s without api-token: status = %d, want %d", req.method, req.path, rec.Code, http.StatusForbidden)
+		}
+	}
+	if err := svc.SetPortPower(context.Background(), "aa:bb:cc:dd:ee:ff", "1", rpc.PowerStateOff); err != nil {
+		t.Errorf("SetPortPower() error = %v, want the refused request not to take the lock", err)
+	}
+}
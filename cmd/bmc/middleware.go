@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/ubiquiti-community/unifi-rpc/pkg/redact"
+	"github.com/ubiquiti-community/unifi-rpc/pkg/rpc"
+)
+
+// unauthenticatedPaths are reachable without a bearer token.
+var unauthenticatedPaths = map[string]bool{
+	"/version": true,
+}
+
+func writeJSONError(w http.ResponseWriter, status int, reason, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(rpc.ResponsePayload{
+		Error: &rpc.ResponseError{Code: status, Message: message, Reason: reason},
+	})
+}
+
+// notFoundHandler answers requests for unknown paths with a JSON error
+// instead of the plain text default.
+func notFoundHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSONError(w, http.StatusNotFound, rpc.ReasonNoRoute, fmt.Sprintf("no route for %s", r.URL.Path))
+	})
+}
+
+// routeMethods are the methods probed when listing the methods a path
+// accepts.
+var routeMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+
+// methodNotAllowedHandler answers a request whose path matches a route of
+// router but whose method does not with 405, an Allow header listing the
+// methods the path accepts and a JSON error.
+func methodNotAllowedHandler(router *mux.Router) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var allow []string
+		for _, m := range routeMethods {
+			probe := r.Clone(r.Context())
+			probe.Method = m
+			var match mux.RouteMatch
+			if router.Match(probe, &match) && match.MatchErr == nil {
+				allow = append(allow, m)
+			}
+		}
+		w.Header().Set("Allow", strings.Join(allow, ", "))
+		writeJSONError(w, http.StatusMethodNotAllowed, rpc.ReasonMethodNotAllowed, fmt.Sprintf("method %s is not allowed for %s", r.Method, r.URL.Path))
+	})
+}
+
+// parseTokens splits a comma separated token list, dropping blanks so that
+// a trailing comma during rotation does not admit an empty token.
+func parseTokens(s string) [][]byte {
+	var tokens [][]byte
+	for _, t := range strings.Split(s, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			tokens = append(tokens, []byte(t))
+		}
+	}
+	return tokens
+}
+
+// authMiddleware requires an "Authorization: Bearer <token>" header matching
+// one of tokens. An empty token list disables authentication. basePath is
+// the prefix of the routes, see newRouter.
+func authMiddleware(tokens [][]byte, basePath string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if len(tokens) == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if unauthenticatedPaths[strings.TrimPrefix(r.URL.Path, basePath)] {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || given == "" {
+				writeJSONError(w, http.StatusUnauthorized, rpc.ReasonUnauthorized, "missing bearer token")
+				return
+			}
+
+			match := 0
+			for _, t := range tokens {
+				match |= subtle.ConstantTimeCompare([]byte(given), t)
+			}
+			if match != 1 {
+				writeJSONError(w, http.StatusUnauthorized, rpc.ReasonUnauthorized, "invalid bearer token")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// requireTokens serves h only when the server requires API tokens and
+// answers 403 otherwise, for the admin routes that must not be open to
+// anyone who can reach the port.
+func requireTokens(protected bool, h http.HandlerFunc) http.HandlerFunc {
+	if protected {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSONError(w, http.StatusForbidden, rpc.ReasonUnauthorized, fmt.Sprintf("%s is only served when api-token is set", r.URL.Path))
+	}
+}
+
+// timeoutMiddleware bounds each request with a deadline so a controller that
+// stops responding surfaces as a 504 instead of hanging the caller.
+func timeoutMiddleware(d time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if d <= 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			defer cancel()
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// bodyMiddleware caps request bodies at maxBytes and rejects POST and PUT
+// requests whose Content-Type is not application/json with 415. A maxBytes of zero
+// or less disables the size limit.
+func bodyMiddleware(maxBytes int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodPost || r.Method == http.MethodPut {
+				mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+				if err != nil || mt != "application/json" {
+					writeJSONError(w, http.StatusUnsupportedMediaType, rpc.ReasonUnsupportedMediaType, "Content-Type must be application/json")
+					return
+				}
+			}
+			if maxBytes > 0 {
+				r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// statusRecorder captures the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Hijack hands the connection to a WebSocket upgrade.
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(r.ResponseWriter).Hijack()
+	if err == nil {
+		r.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+// requestIDHeader carries the correlation ID of a request in both
+// directions.
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLen bounds a client supplied request ID.
+const maxRequestIDLen = 128
+
+// newRequestID returns a random 16 byte hex encoded request ID.
+func newRequestID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// loggingMiddleware logs every request. Headers are only logged at debug
+// level and always pass through redact.Headers first. Each request gets a
+// correlation ID, taken from the X-Request-ID header when the client sent
+// one, that is logged, stored in the request context for the handlers and
+// echoed in the response.
+func loggingMiddleware(logger *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+
+			id := r.Header.Get(requestIDHeader)
+			if id == "" || len(id) > maxRequestIDLen {
+				id = newRequestID()
+			}
+			w.Header().Set(requestIDHeader, id)
+			r = r.WithContext(rpc.WithRequestID(r.Context(), id))
+
+			logger.Debug("incoming request",
+				"requestId", id,
+				"method", r.Method,
+				"path", r.URL.Path,
+				"remote_addr", r.RemoteAddr,
+				"headers", redact.Headers(r.Header),
+			)
+
+			rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(rec, r)
+
+			logger.Info("request completed",
+				"requestId", id,
+				"method", r.Method,
+				"path", r.URL.Path,
+				"remote_addr", r.RemoteAddr,
+				"status", rec.status,
+				"duration", time.Since(start),
+			)
+		})
+	}
+}
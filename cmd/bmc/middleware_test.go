@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/ubiquiti-community/unifi-rpc/pkg/rpc"
+)
+
+func Test_authMiddleware(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	h := authMiddleware(parseTokens("old-token, new-token,"), "")(ok)
+
+	tests := []struct {
+		name   string
+		path   string
+		header string
+		want   int
+	}{
+		{name: "missing header", path: "/rpc", want: http.StatusUnauthorized},
+		{name: "wrong scheme", path: "/rpc", header: "Basic old-token", want: http.StatusUnauthorized},
+		{name: "wrong token", path: "/rpc", header: "Bearer nope", want: http.StatusUnauthorized},
+		{name: "empty token", path: "/rpc", header: "Bearer ", want: http.StatusUnauthorized},
+		{name: "old token", path: "/rpc", header: "Bearer old-token", want: http.StatusOK},
+		{name: "new token", path: "/rpc", header: "Bearer new-token", want: http.StatusOK},
+		{name: "unknown path needs auth", path: "/healthz", want: http.StatusUnauthorized},
+		{name: "version skips auth", path: "/version", want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.path, http.NoBody)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if rec.Code == http.StatusUnauthorized && rec.Header().Get("Content-Type") != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", rec.Header().Get("Content-Type"))
+			}
+			if rec.Code == http.StatusUnauthorized && !strings.Contains(rec.Body.String(), `"reason":"`+rpc.ReasonUnauthorized+`"`) {
+				t.Errorf("body = %s, want reason %q", rec.Body, rpc.ReasonUnauthorized)
+			}
+		})
+	}
+}
+
+func Test_authMiddleware_disabled(t *testing.T) {
+	h := authMiddleware(parseTokens(""), "")(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/rpc", http.NoBody))
+	if rec.Code != http.StatusOK {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func Test_timeoutMiddleware(t *testing.T) {
+	var deadline time.Time
+	var hasDeadline bool
+	h := timeoutMiddleware(time.Second)(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		deadline, hasDeadline = r.Context().Deadline()
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/rpc", http.NoBody))
+	if !hasDeadline {
+		t.Fatal("request context has no deadline")
+	}
+	if time.Until(deadline) > time.Second {
+		t.Errorf("deadline %v is further out than the configured timeout", deadline)
+	}
+}
+
+func Test_loggingMiddleware_RedactsAuthorization(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	h := loggingMiddleware(logger)(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	req := httptest.NewRequest(http.MethodPost, "/rpc", http.NoBody)
+	req.Header.Set("Authorization", "Bearer s3cret-token")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	if strings.Contains(buf.String(), "s3cret-token") {
+		t.Errorf("log output contains the bearer token: %s", buf.String())
+	}
+	if !strings.Contains(buf.String(), `"status":418`) {
+		t.Errorf("log output does not contain the response status: %s", buf.String())
+	}
+}
+
+func Test_loggingMiddleware_Hijack(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+
+	done := make(chan struct{})
+	h := loggingMiddleware(logger)(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		hj, ok := w.(http.Hijacker)
+		if !ok {
+			t.Error("response writer does not implement http.Hijacker")
+			return
+		}
+		conn, _, err := hj.Hijack()
+		if err != nil {
+			t.Errorf("Hijack() error = %v", err)
+			return
+		}
+		conn.Close()
+	}))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(done)
+		h.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	if resp, err := http.Get(srv.URL); err == nil {
+		resp.Body.Close()
+	}
+	<-done
+	if !strings.Contains(buf.String(), `"status":101`) {
+		t.Errorf("hijacked request not logged as 101: %s", buf.String())
+	}
+}
+
+func Test_loggingMiddleware_RequestID(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	var seen string
+	h := loggingMiddleware(logger)(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		seen = rpc.RequestID(r.Context())
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/rpc", http.NoBody)
+	req.Header.Set("X-Request-ID", "abc-123")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if seen != "abc-123" {
+		t.Errorf("handler saw request ID %q, want abc-123", seen)
+	}
+	if got := rec.Header().Get("X-Request-ID"); got != "abc-123" {
+		t.Errorf("X-Request-ID = %q, want abc-123", got)
+	}
+	if n := strings.Count(buf.String(), `"requestId":"abc-123"`); n != 2 {
+		t.Errorf("request ID logged %d times, want 2: %s", n, buf.String())
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/rpc", http.NoBody))
+	if got := rec.Header().Get("X-Request-ID"); got == "" || got != seen {
+		t.Errorf("generated X-Request-ID = %q, handler saw %q", got, seen)
+	}
+}
+
+func Test_notFoundAndMethodNotAllowed(t *testing.T) {
+	r := mux.NewRouter()
+	r.HandleFunc("/device/{mac}/port/{port}/rpc", func(w http.ResponseWriter, _ *http.Request) {}).Methods("POST")
+	r.NotFoundHandler = notFoundHandler()
+	r.MethodNotAllowedHandler = methodNotAllowedHandler(r)
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		wantCode   int
+		wantAllow  string
+		wantReason string
+	}{
+		{name: "wrong method", method: http.MethodGet, path: "/device/aa/port/1/rpc", wantCode: http.StatusMethodNotAllowed, wantAllow: "POST", wantReason: rpc.ReasonMethodNotAllowed},
+		{name: "unknown path", method: http.MethodGet, path: "/nope", wantCode: http.StatusNotFound, wantReason: rpc.ReasonNoRoute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, http.NoBody))
+			if rec.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantCode)
+			}
+			if got := rec.Header().Get("Allow"); got != tt.wantAllow {
+				t.Errorf("Allow = %q, want %q", got, tt.wantAllow)
+			}
+			if got := rec.Header().Get("Content-Type"); got != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", got)
+			}
+			var rp rpc.ResponsePayload
+			if err := json.Unmarshal(rec.Body.Bytes(), &rp); err != nil || rp.Error == nil {
+				t.Fatalf("body = %s, want a JSON error", rec.Body)
+			}
+			if rp.Error.Reason != tt.wantReason {
+				t.Errorf("reason = %q, want %q", rp.Error.Reason, tt.wantReason)
+			}
+		})
+	}
+}
+
+func Test_bodyMiddleware(t *testing.T) {
+	h := bodyMiddleware(16)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := io.ReadAll(r.Body); err != nil {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name        string
+		method      string
+		contentType string
+		body        string
+		want        int
+	}{
+		{name: "json", contentType: "application/json", body: `{}`, want: http.StatusOK},
+		{name: "json with charset", contentType: "application/json; charset=utf-8", body: `{}`, want: http.StatusOK},
+		{name: "wrong content type", contentType: "text/plain", body: `{}`, want: http.StatusUnsupportedMediaType},
+		{name: "missing content type", body: `{}`, want: http.StatusUnsupportedMediaType},
+		{name: "oversized body", contentType: "application/json", body: strings.Repeat("x", 17), want: http.StatusRequestEntityTooLarge},
+		{name: "put without content type", method: http.MethodPut, body: `{}`, want: http.StatusUnsupportedMediaType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := tt.method
+			if method == "" {
+				method = http.MethodPost
+			}
+			req := httptest.NewRequest(method, "/device/aa/port/1/rpc", strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/ubiquiti-community/unifi-rpc/pkg/rpc"
+)
+
+// powerResult is the --json output of the power subcommand.
+type powerResult struct {
+	MAC   string `json:"mac"`
+	Port  string `json:"port"`
+	State string `json:"state"`
+}
+
+// runCommand runs the subcommand named by args[0] instead of the server.
+func runCommand(svc rpc.BMCService, args []string, out io.Writer) error {
+	run := map[string]func(context.Context, rpc.BMCService, []string, io.Writer) error{
+		"power": runPower,
+		"debug": runDebug,
+	}[args[0]]
+	if run == nil {
+		return fmt.Errorf("unknown command %q, the commands are power and debug", args[0])
+	}
+
+	ctx := context.Background()
+	if requestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, requestTimeout)
+		defer cancel()
+	}
+	return run(ctx, svc, args[1:], out)
+}
+
+// runPower implements "power get" and "power set", which query or change the
+// power state of a switch port through the controller without starting the
+// server.
+func runPower(ctx context.Context, svc rpc.BMCService, args []string, out io.Writer) error {
+	if len(args) == 0 || (args[0] != "get" && args[0] != "set") {
+		return errors.New("usage: power get|set --mac MAC --port N [--state on|off|reset|cycle] [--host HOST] [--json]")
+	}
+
+	fs := flag.NewFlagSet("power "+args[0], flag.ContinueOnError)
+	fs.SetOutput(out)
+	mac := fs.String("mac", "", "MAC address of the switch")
+	portIdx := fs.String("port", "", "port index")
+	host := fs.String("host", "", "host selecting the controller, as in the host field of an RPC request")
+	state := fs.String("state", "", "power state to set: on, off, reset or cycle")
+	asJSON := fs.Bool("json", false, "print JSON instead of plain text")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if *mac == "" || *portIdx == "" {
+		return errors.New("--mac and --port are required")
+	}
+
+	svc, err := svc.ForHost(*host)
+	if err != nil {
+		return err
+	}
+
+	res := powerResult{MAC: *mac, Port: *portIdx}
+	if args[0] == "get" {
+		res.State, err = svc.GetPower(ctx, *mac, *portIdx)
+	} else {
+		if *state == "" {
+			return errors.New("--state is required")
+		}
+		res.State = *state
+		err = svc.SetPortPower(ctx, *mac, *portIdx, *state)
+	}
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return json.NewEncoder(out).Encode(res)
+	}
+	_, err = fmt.Fprintln(out, res.State)
+	return err
+}
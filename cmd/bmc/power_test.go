@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/ubiquiti-community/unifi-rpc/pkg/rpc"
+)
+
+type fakeBMC struct {
+	rpc.BMCService
+	state        string
+	preflightErr error
+	// sets records every state passed to SetPortPower.
+	sets []string
+}
+
+func (f *fakeBMC) Preflight(context.Context) error { return f.preflightErr }
+
+func (f *fakeBMC) ForHost(string) (rpc.BMCService, error) { return f, nil }
+
+func (f *fakeBMC) GetPower(context.Context, string, string) (string, error) {
+	return f.state, nil
+}
+
+func (f *fakeBMC) SetPortPower(_ context.Context, _, _, state string) error {
+	f.state = state
+	f.sets = append(f.sets, state)
+	return nil
+}
+
+func (f *fakeBMC) DebugDeviceStats(context.Context, string) ([]byte, error) {
+	return []byte(`{"data":[]}`), nil
+}
+
+func Test_runPower(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		want    string
+		wantErr bool
+	}{
+		{name: "get", args: []string{"get", "--mac", "aa:bb", "--port", "5"}, want: "on\n"},
+		{name: "get json", args: []string{"get", "--mac", "aa:bb", "--port", "5", "--json"}, want: `{"mac":"aa:bb","port":"5","state":"on"}` + "\n"},
+		{name: "set", args: []string{"set", "--mac", "aa:bb", "--port", "5", "--state", "off"}, want: "off\n"},
+		{name: "set without state", args: []string{"set", "--mac", "aa:bb", "--port", "5"}, wantErr: true},
+		{name: "missing port", args: []string{"get", "--mac", "aa:bb"}, wantErr: true},
+		{name: "unknown action", args: []string{"cycle"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := runPower(context.Background(), &fakeBMC{state: "on"}, tt.args, &out)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("runPower() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := out.String(); !tt.wantErr && got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_runCommand(t *testing.T) {
+	var out bytes.Buffer
+	if err := runCommand(&fakeBMC{}, []string{"debug", "stats", "--mac", "aa:bb"}, &out); err != nil {
+		t.Fatalf("runCommand() error = %v", err)
+	}
+	if got, want := out.String(), `{"data":[]}`+"\n"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+
+	if err := runCommand(&fakeBMC{}, []string{"debug", "stats"}, &out); err == nil {
+		t.Error("debug stats without --mac succeeded")
+	}
+	if err := runCommand(&fakeBMC{}, []string{"reboot"}, &out); err == nil {
+		t.Error("unknown command succeeded")
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/ubiquiti-community/unifi-rpc/pkg/rpc"
+)
+
+// rateLimitIdle is how long a client may stay quiet before its bucket is
+// forgotten. A forgotten client starts over with a full burst.
+const rateLimitIdle = 5 * time.Minute
+
+// rateLimiter hands out a token bucket per client IP, so one client looping
+// over the API cannot flood the controller and the switches behind it on
+// behalf of everyone.
+type rateLimiter struct {
+	limit rate.Limit
+	burst int
+	now   func() time.Time
+
+	mu        sync.Mutex
+	clients   map[string]*rateClient
+	lastSweep time.Time
+}
+
+type rateClient struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+func newRateLimiter(rps float64, burst int) *rateLimiter {
+	return &rateLimiter{
+		limit:   rate.Limit(rps),
+		burst:   burst,
+		now:     time.Now,
+		clients: map[string]*rateClient{},
+	}
+}
+
+// reserve takes a token from the bucket of ip. When none is left it returns
+// false and how long the client has to wait for the next one.
+func (l *rateLimiter) reserve(ip string) (bool, time.Duration) {
+	now := l.now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) > rateLimitIdle {
+		for k, c := range l.clients {
+			if now.Sub(c.lastSeen) > rateLimitIdle {
+				delete(l.clients, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	c, ok := l.clients[ip]
+	if !ok {
+		c = &rateClient{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.clients[ip] = c
+	}
+	c.lastSeen = now
+
+	res := c.limiter.ReserveN(now, 1)
+	if delay := res.DelayFrom(now); delay > 0 {
+		// Rejected requests must not use up tokens, or a client retrying
+		// right away would never get through.
+		res.CancelAt(now)
+		return false, delay
+	}
+	return true, 0
+}
+
+// clientIP is the address rate limits are keyed by. X-Forwarded-For is
+// deliberately ignored since any client could set it to dodge its limit.
+func clientIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// rateLimitMiddleware limits every client IP to rps requests per second
+// with bursts of up to burst requests, answering requests over the limit
+// with 429 and a Retry-After header. An rps of zero or less disables the
+// limit. The limiter is shared by every handler the middleware wraps, as
+// mux wraps the handler anew for each request it routes.
+func rateLimitMiddleware(rps float64, burst int) func(http.Handler) http.Handler {
+	if rps <= 0 {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	l := newRateLimiter(rps, max(burst, 1))
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if ok, delay := l.reserve(clientIP(r)); !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+				writeJSONError(w, http.StatusTooManyRequests, rpc.ReasonRateLimited, "rate limit exceeded")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
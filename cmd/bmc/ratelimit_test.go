@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ubiquiti-community/unifi-rpc/pkg/rpc"
+)
+
+func Test_rateLimitMiddleware(t *testing.T) {
+	// Wired as in main, where mux wraps the route handler for every request.
+	r := newRouter(rpc.NewFakeBMCService(rpc.NewFakeController(), nil), "", false)
+	r.Use(rateLimitMiddleware(1, 2))
+
+	do := func(remote string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/version", http.NoBody)
+		req.RemoteAddr = remote
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for i := 0; i < 2; i++ {
+		if rec := do("192.0.2.1:1234"); rec.Code != http.StatusOK {
+			t.Fatalf("request %d within burst: status = %d, want %d", i+1, rec.Code, http.StatusOK)
+		}
+	}
+	rec := do("192.0.2.1:5678")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("request over burst: status = %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("Retry-After = %q, want %q", got, "1")
+	}
+	if rec := do("192.0.2.2:1234"); rec.Code != http.StatusOK {
+		t.Errorf("other client: status = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func Test_rateLimitMiddleware_disabled(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	h := rateLimitMiddleware(0, 0)(ok)
+	for i := 0; i < 100; i++ {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/rpc", http.NoBody))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("request %d: status = %d, want %d", i+1, rec.Code, http.StatusOK)
+		}
+	}
+}
+
+func Test_rateLimiter_refillAndForget(t *testing.T) {
+	now := time.Now()
+	l := newRateLimiter(2, 1)
+	l.now = func() time.Time { return now }
+
+	if ok, _ := l.reserve("192.0.2.1"); !ok {
+		t.Fatal("first request was rejected")
+	}
+	ok, delay := l.reserve("192.0.2.1")
+	if ok || delay != 500*time.Millisecond {
+		t.Fatalf("reserve() = %v, %v, want false, 500ms", ok, delay)
+	}
+	// The rejected request must not have consumed the next token.
+	now = now.Add(500 * time.Millisecond)
+	if ok, _ = l.reserve("192.0.2.1"); !ok {
+		t.Error("request after refill was rejected")
+	}
+
+	now = now.Add(2 * rateLimitIdle)
+	l.reserve("192.0.2.2")
+	if _, found := l.clients["192.0.2.1"]; found {
+		t.Error("idle client was not forgotten")
+	}
+}
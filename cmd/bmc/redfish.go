@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/ubiquiti-community/unifi-rpc/pkg/rpc"
+)
+
+// redfishSystemsPath is the Redfish collection switch ports are exposed in.
+// A system ID is the switch MAC and the port joined by a dash, as in
+// aa:bb:cc:dd:ee:ff-3.
+const redfishSystemsPath = "/redfish/v1/Systems"
+
+// redfishResetTypes are the ResetType values accepted by
+// ComputerSystem.Reset, in the order they are advertised.
+var redfishResetTypes = []string{"On", "ForceOff", "GracefulShutdown", "PowerCycle"}
+
+type redfishResetAction struct {
+	Target          string   `json:"target"`
+	AllowableValues []string `json:"ResetType@Redfish.AllowableValues"`
+}
+
+type redfishSystem struct {
+	ODataID    string `json:"@odata.id"`
+	ODataType  string `json:"@odata.type"`
+	ID         string `json:"Id"`
+	Name       string `json:"Name"`
+	PowerState string `json:"PowerState"`
+	Actions    struct {
+		Reset redfishResetAction `json:"#ComputerSystem.Reset"`
+	} `json:"Actions"`
+}
+
+type redfishResetRequest struct {
+	ResetType string `json:"ResetType"`
+}
+
+// registerRedfish adds the Redfish shim routes to r. It only covers what
+// Redfish tooling needs to power a system on and off: reading PowerState and
+// the ComputerSystem.Reset action. The routes, and the links in their
+// answers, are prefixed with basePath.
+func registerRedfish(r *mux.Router, svc rpc.BMCService, basePath string) {
+	r.HandleFunc(basePath+redfishSystemsPath+"/{id}", redfishSystemHandler(svc, basePath)).Methods("GET")
+	r.HandleFunc(basePath+redfishSystemsPath+"/{id}/Actions/ComputerSystem.Reset", redfishResetHandler(svc)).Methods("POST")
+}
+
+// parseSystemID splits a Redfish system ID into switch MAC and port.
+func parseSystemID(id string) (mac, port string, ok bool) {
+	i := strings.LastIndex(id, "-")
+	if i <= 0 || i == len(id)-1 {
+		return "", "", false
+	}
+	return id[:i], id[i+1:], true
+}
+
+// redfishPowerState maps a power state to the Redfish PowerState enum.
+func redfishPowerState(state string) string {
+	switch rpc.PowerGetResult(state) {
+	case rpc.PoweredOn:
+		return "On"
+	case rpc.PoweredOff:
+		return "Off"
+	case rpc.PoweringOn:
+		return "PoweringOn"
+	case rpc.PoweringOff:
+		return "PoweringOff"
+	}
+	return ""
+}
+
+// redfishStatus is the HTTP status reported for a failed controller call.
+func redfishStatus(err error) int {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, rpc.ErrControllerUnreachable):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, rpc.ErrLocked):
+		return http.StatusLocked
+	case errors.Is(err, rpc.ErrReadOnly):
+		return http.StatusForbidden
+	}
+	return http.StatusBadGateway
+}
+
+// writeRedfishError answers with a Redfish error object.
+func writeRedfishError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"error": map[string]string{
+			"code":    "Base.1.0.GeneralError",
+			"message": message,
+		},
+	})
+}
+
+// redfishTarget resolves the system addressed by the {id} route variable
+// and the controller selected by the host query parameter.
+func redfishTarget(w http.ResponseWriter, r *http.Request, svc rpc.BMCService) (rpc.BMCService, string, string, bool) {
+	mac, port, ok := parseSystemID(mux.Vars(r)["id"])
+	if !ok {
+		writeRedfishError(w, http.StatusNotFound, "system ID must be the switch MAC and port joined by a dash")
+		return nil, "", "", false
+	}
+	svc, err := svc.ForHost(r.URL.Query().Get("host"))
+	if err != nil {
+		writeRedfishError(w, http.StatusBadRequest, err.Error())
+		return nil, "", "", false
+	}
+	return svc, mac, port, true
+}
+
+func redfishSystemHandler(svc rpc.BMCService, basePath string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctrl, mac, port, ok := redfishTarget(w, r, svc)
+		if !ok {
+			return
+		}
+		state, err := ctrl.GetPower(r.Context(), mac, port)
+		if err != nil {
+			writeRedfishError(w, redfishStatus(err), err.Error())
+			return
+		}
+
+		id := mux.Vars(r)["id"]
+		sys := redfishSystem{
+			ODataID:    basePath + redfishSystemsPath + "/" + id,
+			ODataType:  "#ComputerSystem.v1_0_0.ComputerSystem",
+			ID:         id,
+			Name:       "Port " + port + " of " + mac,
+			PowerState: redfishPowerState(state),
+		}
+		sys.Actions.Reset = redfishResetAction{
+			Target:          sys.ODataID + "/Actions/ComputerSystem.Reset",
+			AllowableValues: redfishResetTypes,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(sys)
+	}
+}
+
+func redfishResetHandler(svc rpc.BMCService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req redfishResetRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeRedfishError(w, http.StatusBadRequest, "error decoding reset request: "+err.Error())
+			return
+		}
+		ctrl, mac, port, ok := redfishTarget(w, r, svc)
+		if !ok {
+			return
+		}
+
+		var err error
+		switch req.ResetType {
+		case "On":
+			err = ctrl.SetPortPower(r.Context(), mac, port, rpc.PowerStateOn)
+		case "ForceOff", "GracefulShutdown":
+			// A PoE port cannot ask the device to shut down, so a graceful
+			// shutdown is a plain power off.
+			err = ctrl.SetPortPower(r.Context(), mac, port, rpc.PowerStateOff)
+		case "PowerCycle":
+			// A cold restart rather than the controller's quick bounce, which
+			// some devices ride out.
+			err = ctrl.SetPortPower(r.Context(), mac, port, rpc.PowerStateReset)
+		default:
+			writeRedfishError(w, http.StatusBadRequest, "ResetType must be one of "+strings.Join(redfishResetTypes, ", "))
+			return
+		}
+		if err != nil {
+			writeRedfishError(w, redfishStatus(err), err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func Test_redfish(t *testing.T) {
+	const system = redfishSystemsPath + "/aa:bb:cc:dd:ee:ff-3"
+
+	serve := func(svc *fakeBMC, method, path, body string) *httptest.ResponseRecorder {
+		r := mux.NewRouter()
+		registerRedfish(r, svc, "")
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rec
+	}
+
+	rec := serve(&fakeBMC{state: "on"}, http.MethodGet, system, "")
+	var sys redfishSystem
+	if err := json.NewDecoder(rec.Body).Decode(&sys); err != nil {
+		t.Fatalf("decoding system: %v", err)
+	}
+	if sys.PowerState != "On" || sys.Actions.Reset.Target != system+"/Actions/ComputerSystem.Reset" {
+		t.Errorf("system = %+v", sys)
+	}
+
+	tests := []struct {
+		resetType string
+		want      int
+		sets      []string
+	}{
+		{resetType: "On", want: http.StatusNoContent, sets: []string{"on"}},
+		{resetType: "ForceOff", want: http.StatusNoContent, sets: []string{"off"}},
+		{resetType: "GracefulShutdown", want: http.StatusNoContent, sets: []string{"off"}},
+		{resetType: "PowerCycle", want: http.StatusNoContent, sets: []string{"reset"}},
+		{resetType: "Nmi", want: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.resetType, func(t *testing.T) {
+			svc := &fakeBMC{state: "on"}
+			rec := serve(svc, http.MethodPost, system+"/Actions/ComputerSystem.Reset", `{"ResetType":"`+tt.resetType+`"}`)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+			if !slices.Equal(svc.sets, tt.sets) {
+				t.Errorf("SetPortPower states = %v, want %v", svc.sets, tt.sets)
+			}
+		})
+	}
+
+	if rec := serve(&fakeBMC{}, http.MethodGet, redfishSystemsPath+"/aa:bb:cc:dd:ee:ff", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("system ID without port: status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// serverOptions are the connection limits of the HTTP server, set by the
+// -read-timeout, -read-header-timeout, -write-timeout, -idle-timeout,
+// -max-header-bytes and -h2c flags.
+type serverOptions struct {
+	readHeaderTimeout time.Duration
+	readTimeout       time.Duration
+	// writeTimeout has to leave room for requestTimeout, or requests
+	// running into the latter never get their answer.
+	writeTimeout   time.Duration
+	idleTimeout    time.Duration
+	maxHeaderBytes int
+	// h2c serves HTTP/2 without TLS to clients that ask for it. Over TLS
+	// HTTP/2 is always negotiated.
+	h2c bool
+}
+
+// newServer returns the HTTP server for h. The header timeout keeps clients
+// that trickle in their requests from holding connections, while the idle
+// timeout lets a burst of requests reuse the connections of its clients.
+func newServer(h http.Handler, o serverOptions) *http.Server {
+	if o.h2c {
+		h = h2c.NewHandler(h, &http2.Server{IdleTimeout: o.idleTimeout})
+	}
+	return &http.Server{
+		Handler:           h,
+		ReadHeaderTimeout: o.readHeaderTimeout,
+		ReadTimeout:       o.readTimeout,
+		WriteTimeout:      o.writeTimeout,
+		IdleTimeout:       o.idleTimeout,
+		MaxHeaderBytes:    o.maxHeaderBytes,
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+
+	"github.com/ubiquiti-community/unifi-rpc/pkg/config"
+)
+
+func Test_newServer(t *testing.T) {
+	o := serverOptions{
+		readHeaderTimeout: time.Second,
+		readTimeout:       2 * time.Second,
+		writeTimeout:      3 * time.Second,
+		idleTimeout:       4 * time.Second,
+		maxHeaderBytes:    1 << 10,
+	}
+	srv := newServer(http.NotFoundHandler(), o)
+	if srv.ReadHeaderTimeout != o.readHeaderTimeout || srv.ReadTimeout != o.readTimeout ||
+		srv.WriteTimeout != o.writeTimeout || srv.IdleTimeout != o.idleTimeout || srv.MaxHeaderBytes != o.maxHeaderBytes {
+		t.Errorf("server = %+v, want the limits of %+v", srv, o)
+	}
+}
+
+func Test_newServer_H2C(t *testing.T) {
+	srv := newServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.Proto)
+	}), serverOptions{h2c: true})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = srv.Serve(ln) }()
+	defer srv.Close()
+
+	// A client with prior knowledge speaks HTTP/2 on the plaintext port.
+	client := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		},
+	}}
+	resp, err := client.Get("http://" + ln.Addr().String())
+	if err != nil {
+		t.Fatalf("HTTP/2 request error = %v", err)
+	}
+	defer resp.Body.Close()
+	if body, _ := io.ReadAll(resp.Body); string(body) != "HTTP/2.0" {
+		t.Errorf("served over %s, want HTTP/2.0", body)
+	}
+}
+
+func Test_validateConfig_ServerOptions(t *testing.T) {
+	cfg := config.Config{APIEndpoint: "https://10.0.0.1"}
+	t.Cleanup(func() { serverOpts, requestTimeout, tlsSelfSigned = serverOptions{}, 0, false })
+
+	tests := []struct {
+		name           string
+		opts           serverOptions
+		requestTimeout time.Duration
+		selfSigned     bool
+		wantErr        bool
+	}{
+		{name: "defaults", opts: serverOptions{writeTimeout: 90 * time.Second}, requestTimeout: time.Minute},
+		{name: "write timeout shorter than request timeout", opts: serverOptions{writeTimeout: 30 * time.Second}, requestTimeout: time.Minute, wantErr: true},
+		{name: "no write timeout", requestTimeout: time.Minute},
+		{name: "negative idle timeout", opts: serverOptions{idleTimeout: -time.Second}, wantErr: true},
+		{name: "h2c", opts: serverOptions{h2c: true}},
+		{name: "h2c with TLS", opts: serverOptions{h2c: true}, selfSigned: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			port, tlsCert, tlsKey = 5000, "", ""
+			serverOpts, requestTimeout, tlsSelfSigned = tt.opts, tt.requestTimeout, tt.selfSigned
+			if err := validateConfig(cfg); (err != nil) != tt.wantErr {
+				t.Errorf("validateConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"sync/atomic"
+)
+
+// Build information, set at link time with
+// -ldflags "-X main.version=... -X main.commit=... -X main.date=...".
+var (
+	version = "dev"
+	commit  = "none"
+	date    = "unknown"
+)
+
+// buildInfo describes the running binary.
+type buildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	Date      string `json:"date"`
+	GoVersion string `json:"goVersion"`
+	// URL is where the server accepts connections, which tells clients the
+	// port picked when listening on port 0.
+	URL string `json:"url,omitempty"`
+}
+
+// serverURL holds the URL of the listener once the server is running.
+var serverURL atomic.Value
+
+func currentBuild() buildInfo {
+	return buildInfo{
+		Version:   version,
+		Commit:    commit,
+		Date:      date,
+		GoVersion: runtime.Version(),
+	}
+}
+
+// printVersion writes the build information for --version.
+func printVersion(out io.Writer) {
+	b := currentBuild()
+	fmt.Fprintf(out, "unifi-rpc %s (commit %s, built %s, %s)\n", b.Version, b.Commit, b.Date, b.GoVersion)
+}
+
+// versionHandler answers GET /version with the build information and the
+// URL the server listens on.
+func versionHandler(w http.ResponseWriter, _ *http.Request) {
+	b := currentBuild()
+	b.URL, _ = serverURL.Load().(string)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(b)
+}
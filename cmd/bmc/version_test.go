@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func Test_versionHandler(t *testing.T) {
+	version, commit, date = "1.2.3", "abc123", "2024-01-02T03:04:05Z"
+	t.Cleanup(func() { version, commit, date = "dev", "none", "unknown" })
+
+	rec := httptest.NewRecorder()
+	versionHandler(rec, httptest.NewRequest(http.MethodGet, "/version", http.NoBody))
+
+	var got buildInfo
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	want := buildInfo{Version: "1.2.3", Commit: "abc123", Date: "2024-01-02T03:04:05Z", GoVersion: runtime.Version()}
+	if got != want {
+		t.Errorf("versionHandler() = %+v, want %+v", got, want)
+	}
+}
+
+func Test_versionHandler_URL(t *testing.T) {
+	ln, err := listen("127.0.0.1", 0)
+	if err != nil {
+		t.Fatalf("listen() error = %v", err)
+	}
+	defer ln.Close()
+	serverURL.Store(listenURL("http", ln))
+	t.Cleanup(func() { serverURL.Store("") })
+
+	rec := httptest.NewRecorder()
+	versionHandler(rec, httptest.NewRequest(http.MethodGet, "/version", http.NoBody))
+	var got buildInfo
+	if err = json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	if got.URL != "http://"+ln.Addr().String() || strings.HasSuffix(got.URL, ":0") {
+		t.Errorf("URL = %q, want the port picked for %s", got.URL, ln.Addr())
+	}
+}
@@ -1,22 +1,320 @@
 package config
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
+	"net/url"
 	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
+
+	"github.com/ubiquiti-community/unifi-rpc/pkg/redact"
 )
 
+// Controller is an additional UniFi controller, selected by matching Host
+// against the host field of an RPC request.
+type Controller struct {
+	Host        string `yaml:"host"`
+	Username    string `yaml:"username"`
+	Password    string `yaml:"password"`
+	APIEndpoint string `yaml:"apiEndpoint"`
+	// Site is the UniFi site of the devices on this controller, the top
+	// level Site when empty.
+	Site string `yaml:"site"`
+}
+
 type Config struct {
 	Username    string `yaml:"username"`
 	Password    string `yaml:"password"`
 	APIEndpoint string `yaml:"apiEndpoint"`
+	// Site is the UniFi site the devices belong to. Multi-site
+	// controllers name their sites by the short ID shown in the URL of the
+	// controller UI.
+	Site string `yaml:"site"`
+	// Insecure skips verifying the controller certificates, as UniFi
+	// controllers usually serve a self-signed one. It defaults to true
+	// unless CABundle is set and cannot be combined with it.
+	Insecure *bool `yaml:"insecure"`
+	// CABundle is a PEM file with the CAs that sign the controller
+	// certificates. When set, the certificates are verified against these
+	// CAs only.
+	CABundle string `yaml:"caBundle"`
+	// CertFingerprint pins the controller certificate by its SHA256
+	// fingerprint, "SHA256:" followed by the base64 or hex digest. Only
+	// that certificate is accepted, which is safer than Insecure for a
+	// self-signed one. It cannot be combined with CABundle or Insecure.
+	CertFingerprint string `yaml:"certFingerprint"`
+	// BootDeviceFile is where requested boot devices are persisted. When
+	// empty they are only kept in memory.
+	BootDeviceFile string `yaml:"bootDeviceFile"`
+	// StateFile is where the history of power changes made through the
+	// service is persisted. When empty it is only kept in memory.
+	StateFile string `yaml:"stateFile"`
+	// StaleFallback answers getPowerState with the last state set through
+	// the service, flagged as stale, while the controller is unreachable.
+	StaleFallback bool `yaml:"staleFallback"`
+	// PoECacheTTL is how long a device read from the controller is reused
+	// for power state queries. Zero disables the cache.
+	PoECacheTTL time.Duration `yaml:"poeCacheTTL"`
+	// MaxRetries is how often a controller call is retried after a
+	// transient network failure such as a connection reset, or an answer
+	// of 429 or 5xx.
+	MaxRetries int `yaml:"maxRetries"`
+	// CallTimeout bounds each request to a controller, from dialing to
+	// reading the answer, whatever the deadline of the caller. Zero
+	// disables the limit.
+	CallTimeout time.Duration `yaml:"callTimeout"`
+	// MaxConcurrentCalls bounds the controller calls in flight across all
+	// controllers, so a large fleet operation queues instead of opening a
+	// connection per port. Zero disables the limit. A call that waits
+	// longer than MaxCallWait for its turn fails with 503.
+	MaxConcurrentCalls int           `yaml:"maxConcurrentCalls"`
+	MaxCallWait        time.Duration `yaml:"maxCallWait"`
+	// Controllers lists further controllers next to the top level one,
+	// which stays the default for requests naming no configured host.
+	Controllers []Controller `yaml:"controllers"`
+	// DryRun reads devices from the controller as usual but only logs the
+	// updates power changes would send.
+	DryRun bool `yaml:"dryRun"`
+	// ReadOnly refuses every power change, boot device and maintenance
+	// lock change for the lifetime of the process, while reads keep
+	// working. Unlike the maintenance lock it cannot be lifted at runtime.
+	ReadOnly bool `yaml:"readOnly"`
+	// StrictRequests rejects RPC requests carrying unknown fields, which
+	// usually are misspelled method params.
+	StrictRequests bool `yaml:"strictRequests"`
+	// PoEModes overrides the PoE mode set for a power state, keyed by
+	// "on" or "off". By default on is "auto" and off is "off".
+	PoEModes map[string]string `yaml:"poeModes"`
+	// PoEOnModes overrides the PoE mode that turns on single switch ports,
+	// for ports with passive PoE devices behind them. They are numbered
+	// as on the switch, not through PortMap.
+	PoEOnModes map[int]string `yaml:"poeOnModes"`
+	// PreservePoEMode makes turning a port on leave it alone unless it is
+	// off, instead of setting the on mode over PoE modes set by hand.
+	// Ports in any PoE mode but off then read as on.
+	PreservePoEMode bool `yaml:"preservePoEMode"`
+	// PortMap maps the logical port numbers used in requests to the
+	// physical switch ports. Ports missing from the map are used as is,
+	// unless StrictPortMap rejects them.
+	PortMap       map[int]int `yaml:"portMap"`
+	StrictPortMap bool        `yaml:"strictPortMap"`
+	// PortAliases names logical ports, so that requests and logs can use
+	// "node-03" instead of a port number.
+	PortAliases map[string]int `yaml:"portAliases"`
+	// DefaultPort is the port, or port alias, of RPC requests to
+	// /device/{mac}/rpc, for setups that drive a single machine. Without
+	// it such requests are rejected.
+	DefaultPort string `yaml:"defaultPort"`
+	// WatchInterval is how often devices with WebSocket subscribers are
+	// polled for power state changes.
+	WatchInterval time.Duration `yaml:"watchInterval"`
+	// ResetDwell is how long a port stays off when its power state is set
+	// to "reset".
+	ResetDwell time.Duration `yaml:"resetDwell"`
+	// VerifySets makes every power change read the port back from the
+	// switch after VerifySettle and fail when it is not in the state set,
+	// catching firmware that accepts a change and ignores it.
+	VerifySets   bool          `yaml:"verifySets"`
+	VerifySettle time.Duration `yaml:"verifySettle"`
+	// KeepAliveInterval is how often the connection to each controller is
+	// checked, so a dropped link is noticed before the next request runs
+	// into its timeout. Zero disables the check.
+	KeepAliveInterval time.Duration `yaml:"keepAliveInterval"`
+	// CriticalPorts are switch ports, such as uplinks, that powerCycleAll
+	// never cycles. They are numbered as on the switch, not through
+	// PortMap.
+	CriticalPorts []int `yaml:"criticalPorts"`
+	// EnableWoL makes setting a port on also send a Wake-on-LAN magic
+	// packet to the machine behind it, for machines halted while their
+	// port stays powered. WoLMACs are the MAC addresses of the machines by
+	// port, numbered like in requests, and ports without one are not
+	// woken. WoLAddress is where the packets are sent, the broadcast
+	// address 255.255.255.255:9 by default.
+	EnableWoL  bool           `yaml:"enableWoL"`
+	WoLMACs    map[int]string `yaml:"wolMACs"`
+	WoLAddress string         `yaml:"wolAddress"`
+	// CycleStagger is the delay between the ports cycled by powerCycleAll.
+	CycleStagger time.Duration `yaml:"cycleStagger"`
+	// PowerOnStagger is the delay between the ports turned on by a
+	// setPowerStateBatch request, which then enables them one at a time
+	// instead of with a single device update. Zero disables the stagger.
+	PowerOnStagger time.Duration `yaml:"powerOnStagger"`
+	// PowerOnReserveWatts is the PoE budget that has to be left on the
+	// switch before setPowerStateBatch turns on the next port. Ports wait,
+	// polling every PowerOnStagger, until the draw of the previous ones
+	// settles. Zero disables the check.
+	PowerOnReserveWatts float64 `yaml:"powerOnReserveWatts"`
+	// PingChecksSwitch makes the ping method read the device from the
+	// controller instead of answering a static pong.
+	PingChecksSwitch bool `yaml:"pingChecksSwitch"`
+	// StatsParseMode is what reading the port table of a device does
+	// about rows that do not decode: "strict" fails the read, "lenient"
+	// leaves the rows out and reports them as warnings.
+	StatsParseMode string `yaml:"statsParseMode"`
+	// WebhookURL receives a JSON event for every power change made through
+	// the service or noticed while watching a device. Empty disables it.
+	WebhookURL string `yaml:"webhookURL"`
+	// WebhookTimeout bounds a single delivery attempt and WebhookRetries
+	// is how often a failed one is retried.
+	WebhookTimeout time.Duration `yaml:"webhookTimeout"`
+	WebhookRetries int           `yaml:"webhookRetries"`
+}
+
+// Default returns the configuration used for any key missing from the file.
+func Default() Config {
+	return Config{
+		Site:              "default",
+		PoECacheTTL:       2 * time.Second,
+		MaxRetries:        2,
+		CallTimeout:       30 * time.Second,
+		MaxCallWait:       10 * time.Second,
+		WatchInterval:     2 * time.Second,
+		ResetDwell:        5 * time.Second,
+		VerifySettle:      3 * time.Second,
+		KeepAliveInterval: 15 * time.Second,
+		CycleStagger:      2 * time.Second,
+		StatsParseMode:    "strict",
+		WebhookTimeout:    5 * time.Second,
+		WebhookRetries:    3,
+	}
+}
+
+// DefaultFiles are the configuration files looked for in the working
+// directory when none is given, in order.
+var DefaultFiles = []string{"config.yaml", "config.yml", "config.json"}
+
+// FindConfig returns the first of DefaultFiles that exists in dir, or the
+// first one when none does, so that reading it reports the missing file.
+func FindConfig(dir string) string {
+	for _, name := range DefaultFiles {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return filepath.Join(dir, DefaultFiles[0])
+}
+
+// ExpandPath expands a leading ~ of p to the home directory of the user and
+// $VAR or ${VAR} to the value of the environment variable, empty when it
+// is unset. Other uses of ~, such as ~user, are left as is.
+func ExpandPath(p string) (string, error) {
+	if p != "~" && !strings.HasPrefix(p, "~/") {
+		return os.ExpandEnv(p), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("error expanding %s: %w", p, err)
+	}
+	return home + os.ExpandEnv(p[1:]), nil
+}
+
+// ExpandPaths applies ExpandPath to the file names of c. It is applied once
+// every source of the configuration has been merged.
+func (c *Config) ExpandPaths() error {
+	for _, p := range []*string{&c.CABundle, &c.BootDeviceFile, &c.StateFile} {
+		v, err := ExpandPath(*p)
+		if err != nil {
+			return err
+		}
+		*p = v
+	}
+	return nil
+}
+
+// Redacted returns a copy of c for showing to others, with the controller
+// passwords and the credentials of the endpoint URLs replaced by
+// redact.Placeholder. Unset passwords stay empty, so it still shows which
+// are set. The webhook URL keeps only its scheme and host, since hooks
+// commonly carry their token in the path.
+func (c Config) Redacted() Config {
+	c.Password = redactSecret(c.Password)
+	c.APIEndpoint = redact.URL(c.APIEndpoint)
+	c.Controllers = slices.Clone(c.Controllers)
+	for i := range c.Controllers {
+		c.Controllers[i].Password = redactSecret(c.Controllers[i].Password)
+		c.Controllers[i].APIEndpoint = redact.URL(c.Controllers[i].APIEndpoint)
+	}
+	if c.WebhookURL != "" {
+		u, err := url.Parse(c.WebhookURL)
+		if err != nil || u.Host == "" {
+			c.WebhookURL = redact.Placeholder
+		} else if u.User != nil || strings.Trim(u.Path, "/") != "" || u.RawQuery != "" || u.Fragment != "" {
+			c.WebhookURL = u.Scheme + "://" + u.Host + "/" + redact.Placeholder
+		}
+	}
+	return c
+}
+
+func redactSecret(s string) string {
+	if s == "" {
+		return ""
+	}
+	return redact.Placeholder
+}
+
+// checkFormat checks that the file at path, named by its extension, is in a
+// format the configuration can be read from. YAML is a superset of JSON, so
+// both are decoded with the same keys, but a JSON file is held to JSON
+// syntax so that it fails with a JSON error rather than a confusing YAML
+// one. Files with other extensions are read as YAML.
+func checkFormat(path string, b []byte) error {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		var v any
+		if err := json.Unmarshal(b, &v); err != nil {
+			return fmt.Errorf("error parsing %s as JSON: %w", path, err)
+		}
+	case ".toml":
+		return fmt.Errorf("%s: TOML configuration files are not supported, use YAML or JSON", path)
+	}
+	return nil
+}
+
+// numericKeys retags the integer keys of the JSON objects under n as
+// integers. JSON quotes every key, so without this the maps keyed by port,
+// such as portMap, could not be written in JSON.
+func numericKeys(n *yaml.Node) {
+	if n.Kind == yaml.MappingNode {
+		for i := 0; i < len(n.Content); i += 2 {
+			k := n.Content[i]
+			if _, err := strconv.Atoi(k.Value); err == nil && k.Tag == "!!str" {
+				k.Tag, k.Style = "!!int", 0
+			}
+		}
+	}
+	for _, c := range n.Content {
+		numericKeys(c)
+	}
 }
 
 func GetConfig(path string) (Config, error) {
-	var config Config
+	return GetProfileConfig(path, "")
+}
+
+// GetProfileConfig reads the configuration file like GetConfig and merges
+// the named block of its profiles section over the top level keys, so that
+// a single file can describe several environments:
+//
+//	username: admin
+//	profiles:
+//	  prod:
+//	    apiEndpoint: https://unifi.prod.example.com
+//	  dev:
+//	    apiEndpoint: https://unifi.dev.example.com
+//
+// Keys missing from the profile keep their top level value. No profile is
+// applied when profile is empty.
+func GetProfileConfig(path, profile string) (Config, error) {
+	config := Default()
 
 	log.Printf("Reading config file %s", path)
 
@@ -28,10 +326,40 @@ func GetConfig(path string) (Config, error) {
 	if err != nil {
 		return config, err
 	}
-	err = yaml.Unmarshal(b, &config)
-	if err != nil {
+	if err = checkFormat(path, b); err != nil {
+		return config, err
+	}
+	var root yaml.Node
+	if err = yaml.Unmarshal(b, &root); err != nil {
+		return config, fmt.Errorf("error parsing %s: %w", path, err)
+	}
+	if root.Kind == 0 {
+		// The file is empty.
+		return config, nil
+	}
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		numericKeys(&root)
+	}
+	if err = root.Decode(&config); err != nil {
+		return config, fmt.Errorf("error parsing %s: %w", path, err)
+	}
+	if profile == "" {
+		return config, nil
+	}
+
+	var profiles struct {
+		Profiles map[string]yaml.Node `yaml:"profiles"`
+	}
+	if err = root.Decode(&profiles); err != nil {
 		return config, err
 	}
+	node, ok := profiles.Profiles[profile]
+	if !ok {
+		return config, fmt.Errorf("profile %q not found in %s", profile, path)
+	}
+	if err = node.Decode(&config); err != nil {
+		return config, fmt.Errorf("profile %s: %w", profile, err)
+	}
 
 	return config, nil
 }
@@ -0,0 +1,172 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ubiquiti-community/unifi-rpc/pkg/redact"
+)
+
+const profilesYAML = `
+username: admin
+password: top-level
+apiEndpoint: https://unifi.example.com
+poeCacheTTL: 5s
+profiles:
+  prod:
+    apiEndpoint: https://unifi.prod.example.com
+    password: prod
+  dev:
+    apiEndpoint: https://unifi.dev.example.com
+`
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestGetProfileConfig(t *testing.T) {
+	path := writeConfig(t, profilesYAML)
+
+	cfg, err := GetProfileConfig(path, "prod")
+	if err != nil {
+		t.Fatalf("GetProfileConfig() error = %v", err)
+	}
+	if cfg.APIEndpoint != "https://unifi.prod.example.com" || cfg.Password != "prod" {
+		t.Errorf("config = %+v, want the prod endpoint and password", cfg)
+	}
+	// Keys the profile leaves out come from the top level, then the
+	// defaults.
+	if cfg.Username != "admin" || cfg.PoECacheTTL != 5*time.Second || cfg.Site != "default" {
+		t.Errorf("config = %+v, want the top level username and cache TTL and the default site", cfg)
+	}
+
+	cfg, err = GetProfileConfig(path, "")
+	if err != nil {
+		t.Fatalf("GetProfileConfig() without a profile error = %v", err)
+	}
+	if cfg.APIEndpoint != "https://unifi.example.com" {
+		t.Errorf("APIEndpoint = %q, want the top level one without a profile", cfg.APIEndpoint)
+	}
+
+	if _, err := GetProfileConfig(path, "staging"); err == nil {
+		t.Error("GetProfileConfig() accepted a profile missing from the file")
+	}
+}
+
+func TestGetConfig_Formats(t *testing.T) {
+	fromYAML, err := GetConfig("testdata/config.yaml")
+	if err != nil {
+		t.Fatalf("GetConfig() of the YAML file error = %v", err)
+	}
+	fromJSON, err := GetConfig("testdata/config.json")
+	if err != nil {
+		t.Fatalf("GetConfig() of the JSON file error = %v", err)
+	}
+	if !reflect.DeepEqual(fromYAML, fromJSON) {
+		t.Errorf("JSON config = %+v, want it equal to the YAML one %+v", fromJSON, fromYAML)
+	}
+	if fromJSON.PoECacheTTL != 5*time.Second || fromJSON.PortMap[2] != 4 || len(fromJSON.Controllers) != 1 {
+		t.Errorf("JSON config = %+v, want the values of the file", fromJSON)
+	}
+}
+
+func TestGetConfig_Malformed(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"config.json": `{"username": "admin",}`,
+		"config.yaml": "username: [admin",
+		"config.toml": `username = "admin"`,
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		_, err := GetConfig(path)
+		if err == nil || !strings.Contains(err.Error(), path) {
+			t.Errorf("GetConfig(%s) error = %v, want one naming the file", name, err)
+		}
+	}
+}
+
+func TestFindConfig(t *testing.T) {
+	dir := t.TempDir()
+	if got, want := FindConfig(dir), filepath.Join(dir, "config.yaml"); got != want {
+		t.Errorf("FindConfig() without a file = %q, want %q", got, want)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte("{}"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := FindConfig(dir), filepath.Join(dir, "config.json"); got != want {
+		t.Errorf("FindConfig() = %q, want %q", got, want)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "config.yml"), []byte("{}"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := FindConfig(dir), filepath.Join(dir, "config.yml"); got != want {
+		t.Errorf("FindConfig() = %q, want YAML before JSON %q", got, want)
+	}
+}
+
+func TestExpandPath(t *testing.T) {
+	t.Setenv("HOME", "/home/ops")
+	t.Setenv("UNIFI_RPC_DIR", "/var/lib/unifi-rpc")
+	tests := []struct{ in, want string }{
+		{in: "~", want: "/home/ops"},
+		{in: "~/.config/unifi-rpc/ca.pem", want: "/home/ops/.config/unifi-rpc/ca.pem"},
+		{in: "${HOME}/ca.pem", want: "/home/ops/ca.pem"},
+		{in: "$UNIFI_RPC_DIR/state.json", want: "/var/lib/unifi-rpc/state.json"},
+		{in: "~ops/ca.pem", want: "~ops/ca.pem"},
+		{in: "/etc/unifi-rpc/ca.pem", want: "/etc/unifi-rpc/ca.pem"},
+		{in: "", want: ""},
+	}
+	for _, tt := range tests {
+		if got, err := ExpandPath(tt.in); err != nil || got != tt.want {
+			t.Errorf("ExpandPath(%q) = %q, %v, want %q", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestConfig_ExpandPaths(t *testing.T) {
+	t.Setenv("HOME", "/home/ops")
+	t.Setenv("UNIFI_RPC_DIR", "/var/lib/unifi-rpc")
+	cfg, err := GetConfig(writeConfig(t, "caBundle: ~/ca.pem\nstateFile: ${UNIFI_RPC_DIR}/state.json\nbootDeviceFile: $UNIFI_RPC_DIR/boot.json\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cfg.ExpandPaths(); err != nil {
+		t.Fatalf("ExpandPaths() error = %v", err)
+	}
+	if cfg.CABundle != "/home/ops/ca.pem" || cfg.StateFile != "/var/lib/unifi-rpc/state.json" || cfg.BootDeviceFile != "/var/lib/unifi-rpc/boot.json" {
+		t.Errorf("caBundle = %q, stateFile = %q, bootDeviceFile = %q", cfg.CABundle, cfg.StateFile, cfg.BootDeviceFile)
+	}
+}
+
+func TestConfig_Redacted(t *testing.T) {
+	cfg := Config{
+		Password:    "secret",
+		Controllers: []Controller{{Host: "rack2", Password: "secret"}, {Host: "rack3"}},
+		WebhookURL:  "https://hooks.example.com/",
+	}
+	r := cfg.Redacted()
+	if r.Password != redact.Placeholder || r.Controllers[0].Password != redact.Placeholder {
+		t.Errorf("Redacted() = %+v, want the passwords redacted", r)
+	}
+	if r.Controllers[1].Password != "" {
+		t.Errorf("unset password = %q, want it left empty", r.Controllers[1].Password)
+	}
+	if r.WebhookURL != cfg.WebhookURL {
+		t.Errorf("webhookURL = %q, want a URL without a path kept", r.WebhookURL)
+	}
+	if cfg.Password != "secret" || cfg.Controllers[0].Password != "secret" {
+		t.Errorf("Redacted() changed the original to %+v", cfg)
+	}
+}
@@ -0,0 +1,78 @@
+// Package redact strips credentials from values before they are logged or
+// returned to clients.
+package redact
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Placeholder replaces every redacted value.
+const Placeholder = "[REDACTED]"
+
+var sensitiveHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"Set-Cookie",
+	"X-Csrf-Token",
+}
+
+// Headers returns a copy of h with credential carrying headers redacted.
+func Headers(h http.Header) http.Header {
+	c := h.Clone()
+	for _, k := range sensitiveHeaders {
+		if _, ok := c[k]; ok {
+			c[k] = []string{Placeholder}
+		}
+	}
+	return c
+}
+
+// URL returns raw with any password in its user info redacted. Values that
+// do not parse as a URL are returned unchanged.
+func URL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.User == nil {
+		return raw
+	}
+	if _, ok := u.User.Password(); !ok {
+		return raw
+	}
+	u.User = url.UserPassword(u.User.Username(), Placeholder)
+	return u.String()
+}
+
+// String replaces every occurrence of secrets in s. Empty secrets are
+// ignored.
+func String(s string, secrets ...string) string {
+	for _, secret := range secrets {
+		if secret != "" {
+			s = strings.ReplaceAll(s, secret, Placeholder)
+		}
+	}
+	return s
+}
+
+type redactedError struct {
+	msg string
+	err error
+}
+
+func (e *redactedError) Error() string { return e.msg }
+
+func (e *redactedError) Unwrap() error { return e.err }
+
+// Error returns err with secrets removed from its message while keeping it
+// usable with errors.Is and errors.As.
+func Error(err error, secrets ...string) error {
+	if err == nil {
+		return nil
+	}
+	msg := String(err.Error(), secrets...)
+	if msg == err.Error() {
+		return err
+	}
+	return &redactedError{msg: msg, err: err}
+}
@@ -0,0 +1,133 @@
+package rpc
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// rpcStats counts the requests of RPCHandler and remembers what the last
+// single port request of each port saw, for AdminStatusHandler. It is
+// shared by all views of the service returned by forHost, and its methods
+// are no-ops on a nil rpcStats.
+type rpcStats struct {
+	started  time.Time
+	inFlight atomic.Int64
+	served   atomic.Uint64
+
+	mu    sync.Mutex
+	ports map[Machine]*PortActivity
+}
+
+func newRPCStats() *rpcStats {
+	return &rpcStats{started: time.Now(), ports: map[Machine]*PortActivity{}}
+}
+
+// begin counts a request as in flight until the returned func is called,
+// which counts it as served.
+func (s *rpcStats) begin() func() {
+	if s == nil {
+		return func() {}
+	}
+	s.inFlight.Add(1)
+	return func() {
+		s.inFlight.Add(-1)
+		s.served.Add(1)
+	}
+}
+
+// port returns the activity of m, creating it. s.mu must be held.
+func (s *rpcStats) port(m Machine) *PortActivity {
+	a, ok := s.ports[m]
+	if !ok {
+		a = &PortActivity{MAC: m.MacAddress, Port: m.PortIdx}
+		s.ports[m] = a
+	}
+	return a
+}
+
+// observe records the power state of m read or set by a request, or the
+// error it failed with. The last state is kept when a request fails.
+func (s *rpcStats) observe(m Machine, state string, err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a := s.port(m)
+	if err != nil {
+		a.Error, a.Failed = err.Error(), time.Now().UTC()
+		return
+	}
+	a.State, a.Updated = state, time.Now().UTC()
+}
+
+// AdminStatus is the body of the admin status endpoint.
+type AdminStatus struct {
+	// InFlight is the number of RPC requests being handled, which drops
+	// to zero while a shutdown drains them.
+	InFlight int64 `json:"inFlight"`
+	// Served is the number of RPC requests answered since the start.
+	Served        uint64    `json:"served"`
+	Started       time.Time `json:"started"`
+	UptimeSeconds float64   `json:"uptimeSeconds"`
+	// Ports lists the ports that single port requests were made for,
+	// sorted by MAC address and port.
+	Ports []PortActivity `json:"ports"`
+}
+
+// PortActivity is what the requests for a port last saw. Ports are named
+// as in the requests, aliases resolved.
+type PortActivity struct {
+	MAC  string `json:"mac"`
+	Port string `json:"port"`
+	// State is the last power state read or set, and Updated when.
+	State   string    `json:"state,omitempty"`
+	Updated time.Time `json:"updated,omitempty"`
+	// Error is the last error of a request for the port, and Failed when.
+	Error  string    `json:"error,omitempty"`
+	Failed time.Time `json:"failed,omitempty"`
+}
+
+// snapshot returns the AdminStatus of s at now.
+func (s *rpcStats) snapshot(now time.Time) AdminStatus {
+	st := AdminStatus{
+		InFlight:      s.inFlight.Load(),
+		Served:        s.served.Load(),
+		Started:       s.started.UTC(),
+		UptimeSeconds: now.Sub(s.started).Seconds(),
+		Ports:         []PortActivity{},
+	}
+	s.mu.Lock()
+	for _, a := range s.ports {
+		st.Ports = append(st.Ports, *a)
+	}
+	s.mu.Unlock()
+	sort.Slice(st.Ports, func(i, j int) bool {
+		if st.Ports[i].MAC != st.Ports[j].MAC {
+			return st.Ports[i].MAC < st.Ports[j].MAC
+		}
+		return st.Ports[i].Port < st.Ports[j].Port
+	})
+	return st
+}
+
+func (b *bmcService) InFlight() int64 {
+	if b.stats == nil {
+		return 0
+	}
+	return b.stats.inFlight.Load()
+}
+
+// AdminStatusHandler answers with the AdminStatus of the service.
+func (b *bmcService) AdminStatusHandler(w http.ResponseWriter, _ *http.Request) {
+	st := AdminStatus{Ports: []PortActivity{}}
+	if b.stats != nil {
+		st = b.stats.snapshot(time.Now())
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(st)
+}
@@ -0,0 +1,90 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRPCStats_Counters(t *testing.T) {
+	s := newRPCStats()
+	first, second := s.begin(), s.begin()
+	if st := s.snapshot(time.Now()); st.InFlight != 2 || st.Served != 0 {
+		t.Fatalf("inFlight = %d, served = %d, want 2 and 0", st.InFlight, st.Served)
+	}
+	first()
+	second()
+	if st := s.snapshot(time.Now()); st.InFlight != 0 || st.Served != 2 {
+		t.Errorf("inFlight = %d, served = %d, want 0 and 2", st.InFlight, st.Served)
+	}
+
+	// A nil rpcStats, as held by services built without one, counts
+	// nothing.
+	var none *rpcStats
+	none.begin()()
+	none.observe(Machine{MacAddress: "aa:bb:cc:dd:ee:ff", PortIdx: "1"}, PowerStateOn, nil)
+}
+
+func TestRPCHandler_RecordsPortActivity(t *testing.T) {
+	fc := &fakeClient{device: newTestDevice("auto")}
+	svc := newTestService(fc, nil)
+	svc.stats = newRPCStats()
+
+	if rec := serveRPC(context.Background(), t, svc, `{"id":1,"method":"getPowerState"}`); rec.Code != http.StatusOK {
+		t.Fatalf("getPowerState: status = %d: %s", rec.Code, rec.Body)
+	}
+	fc.updateErr = errors.New("update refused")
+	if rec := serveRPC(context.Background(), t, svc, `{"id":2,"method":"setPowerState","params":{"state":"off"}}`); rec.Code == http.StatusOK {
+		t.Fatalf("setPowerState: status = %d, want an error", rec.Code)
+	}
+
+	rec := httptest.NewRecorder()
+	svc.AdminStatusHandler(rec, httptest.NewRequest(http.MethodGet, "/admin/status", http.NoBody))
+	var st AdminStatus
+	if err := json.Unmarshal(rec.Body.Bytes(), &st); err != nil {
+		t.Fatalf("error decoding %s: %v", rec.Body, err)
+	}
+	if st.InFlight != 0 || st.Served != 2 || st.UptimeSeconds <= 0 {
+		t.Errorf("status = %+v, want 2 served, none in flight and an uptime", st)
+	}
+	if len(st.Ports) != 1 {
+		t.Fatalf("ports = %+v, want port 1 only", st.Ports)
+	}
+	// The failed change leaves the state read before in place.
+	p := st.Ports[0]
+	if p.MAC != "aa:bb:cc:dd:ee:ff" || p.Port != "1" || p.State != PowerStateOn || p.Updated.IsZero() {
+		t.Errorf("port = %+v, want port 1 on", p)
+	}
+	if p.Error == "" || p.Failed.IsZero() {
+		t.Errorf("port = %+v, want the error of setPowerState", p)
+	}
+}
+
+func TestRPCHandler_CountsInFlight(t *testing.T) {
+	svc := newTestService(&fakeClient{device: newTestDevice("auto"), block: true}, nil)
+	svc.stats = newRPCStats()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		serveRPC(ctx, t, svc, `{"id":1,"method":"getPowerState"}`)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for svc.InFlight() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("the blocked request is not counted as in flight")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+	if n := svc.InFlight(); n != 0 {
+		t.Errorf("InFlight() = %d after the request returned, want 0", n)
+	}
+}
@@ -0,0 +1,118 @@
+package rpc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// bootDeviceStore remembers the last requested boot device per machine.
+// UniFi devices cannot enforce a boot order, but netboot workflows still
+// want to read back what was asked for. When path is empty the store only
+// lives in memory.
+type bootDeviceStore struct {
+	path string
+
+	mu      sync.Mutex
+	devices map[string]BootDeviceParams
+}
+
+func bootDeviceKey(m Machine) string {
+	return strings.ToLower(m.MacAddress) + "/" + m.PortIdx
+}
+
+func newBootDeviceStore(path string) (*bootDeviceStore, error) {
+	s := &bootDeviceStore{
+		path:    path,
+		devices: map[string]BootDeviceParams{},
+	}
+	if path == "" {
+		return s, nil
+	}
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading boot device file %s: %w", path, err)
+	}
+	if len(b) == 0 {
+		return s, nil
+	}
+	if err := json.Unmarshal(b, &s.devices); err != nil {
+		return nil, fmt.Errorf("error parsing boot device file %s: %w", path, err)
+	}
+	return s, nil
+}
+
+func (s *bootDeviceStore) Get(m Machine) (BootDeviceParams, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.devices[bootDeviceKey(m)]
+	return p, ok
+}
+
+func (s *bootDeviceStore) Set(m Machine, p BootDeviceParams) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.devices[bootDeviceKey(m)] = p
+	return s.save()
+}
+
+func (s *bootDeviceStore) Clear(m Machine) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.devices, bootDeviceKey(m))
+	return s.save()
+}
+
+// Store sets the boot device of m, or clears it when p names no device.
+func (s *bootDeviceStore) Store(m Machine, p BootDeviceParams) error {
+	if p.Device == "" {
+		return s.Clear(m)
+	}
+	return s.Set(m, p)
+}
+
+// save writes the store to its file. The caller must hold s.mu.
+func (s *bootDeviceStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	b, err := json.MarshalIndent(s.devices, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = writeFileAtomic(s.path, b); err != nil {
+		return fmt.Errorf("error writing boot device file %s: %w", s.path, err)
+	}
+	return nil
+}
+
+// writeFileAtomic writes b to path through a temporary file so a crash
+// mid-write never leaves a truncated file behind.
+func writeFileAtomic(path string, b []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
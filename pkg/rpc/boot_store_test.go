@@ -0,0 +1,61 @@
+package rpc
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestBootDeviceStore_SetGet(t *testing.T) {
+	s, err := newBootDeviceStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := Machine{MacAddress: "AA:BB:CC:DD:EE:FF", PortIdx: "3"}
+	want := BootDeviceParams{Device: "pxe", Persistent: true, EFIBoot: true}
+	if err = s.Set(m, want); err != nil {
+		t.Fatal(err)
+	}
+
+	got, ok := s.Get(Machine{MacAddress: "aa:bb:cc:dd:ee:ff", PortIdx: "3"})
+	if !ok || got != want {
+		t.Errorf("Get() = %+v, %v, want %+v, true", got, ok, want)
+	}
+
+	if _, ok = s.Get(Machine{MacAddress: "aa:bb:cc:dd:ee:ff", PortIdx: "4"}); ok {
+		t.Error("Get() found a boot device for a port that was never set")
+	}
+}
+
+func TestBootDeviceStore_PersistsAcrossRestarts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "boot.json")
+	m := Machine{MacAddress: "aa:bb:cc:dd:ee:ff", PortIdx: "1"}
+	want := BootDeviceParams{Device: "disk"}
+
+	s, err := newBootDeviceStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Set(m, want); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = newBootDeviceStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := s.Get(m); !ok || got != want {
+		t.Errorf("Get() after reload = %+v, %v, want %+v, true", got, ok, want)
+	}
+
+	if err = s.Clear(m); err != nil {
+		t.Fatal(err)
+	}
+	s, err = newBootDeviceStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.Get(m); ok {
+		t.Error("Get() after Clear and reload still found a boot device")
+	}
+}
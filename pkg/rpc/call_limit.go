@@ -0,0 +1,62 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrBusy is returned when a controller call waited longer than allowed for
+// one of the call slots shared by all controllers.
+var ErrBusy = errors.New("too many controller calls in flight")
+
+// callLimiter bounds how many controller calls run at once across every
+// client it is shared by. Calls beyond the limit queue for a slot.
+type callLimiter struct {
+	slots chan struct{}
+	// maxWait bounds the wait for a slot, zero leaves it to the context of
+	// the call.
+	maxWait time.Duration
+}
+
+// newCallLimiter returns a limiter allowing n calls at once, or nil, which
+// does not limit, for n of zero.
+func newCallLimiter(n int, maxWait time.Duration) *callLimiter {
+	if n <= 0 {
+		return nil
+	}
+	return &callLimiter{slots: make(chan struct{}, n), maxWait: maxWait}
+}
+
+// acquire waits for a slot and returns the func releasing it. It fails with
+// ErrBusy after maxWait and with the context error when ctx ends first.
+func (l *callLimiter) acquire(ctx context.Context) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return l.release, nil
+	default:
+	}
+
+	var timeout <-chan time.Time
+	if l.maxWait > 0 {
+		t := time.NewTimer(l.maxWait)
+		defer t.Stop()
+		timeout = t.C
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return l.release, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-timeout:
+		return nil, fmt.Errorf("%w: no call slot freed up within %v", ErrBusy, l.maxWait)
+	}
+}
+
+func (l *callLimiter) release() {
+	<-l.slots
+}
@@ -0,0 +1,85 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCallLimiter_CapsConcurrency(t *testing.T) {
+	const limit = 3
+	l := newCallLimiter(limit, 0)
+	// The limiter is shared by the clients of every controller.
+	clients := []*lazyClient{{limiter: l}, {limiter: l}}
+
+	var inFlight, peak atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 12; i++ {
+		wg.Add(1)
+		go func(c *lazyClient) {
+			defer wg.Done()
+			err := c.call(context.Background(), func() error {
+				n := inFlight.Add(1)
+				for {
+					p := peak.Load()
+					if n <= p || peak.CompareAndSwap(p, n) {
+						break
+					}
+				}
+				time.Sleep(10 * time.Millisecond)
+				inFlight.Add(-1)
+				return nil
+			})
+			if err != nil {
+				t.Errorf("call() error = %v", err)
+			}
+		}(clients[i%len(clients)])
+	}
+	wg.Wait()
+
+	if p := peak.Load(); p != limit {
+		t.Errorf("%d calls ran at once, want %d", p, limit)
+	}
+}
+
+func TestCallLimiter_MaxWait(t *testing.T) {
+	l := newCallLimiter(1, 20*time.Millisecond)
+	release, err := l.acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = l.acquire(context.Background())
+	if !errors.Is(err, ErrBusy) {
+		t.Fatalf("acquire() while full error = %v, want %v", err, ErrBusy)
+	}
+	if got := errorStatus(err); got != http.StatusServiceUnavailable {
+		t.Errorf("errorStatus() = %d, want %d", got, http.StatusServiceUnavailable)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	l.maxWait = time.Hour
+	if _, err = l.acquire(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("acquire() with a canceled context error = %v, want %v", err, context.Canceled)
+	}
+
+	release()
+	if release, err = l.acquire(context.Background()); err != nil {
+		t.Fatalf("acquire() after release error = %v", err)
+	}
+	release()
+}
+
+func TestCallLimiter_Unlimited(t *testing.T) {
+	l := newCallLimiter(0, 0)
+	for i := 0; i < 100; i++ {
+		if _, err := l.acquire(context.Background()); err != nil {
+			t.Fatalf("acquire() without a limit error = %v", err)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package rpc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/ubiquiti-community/unifi-rpc/pkg/config"
+)
+
+func TestParseCertFingerprint(t *testing.T) {
+	sum := sha256.Sum256([]byte("certificate"))
+	colons := strings.ToUpper(hex.EncodeToString(sum[:]))
+	for i := len(colons) - 2; i > 0; i -= 2 {
+		colons = colons[:i] + ":" + colons[i:]
+	}
+
+	for _, s := range []string{
+		certFingerprint([]byte("certificate")),
+		certFingerprint([]byte("certificate")) + "=",
+		"SHA256:" + hex.EncodeToString(sum[:]),
+		"SHA256:" + colons,
+	} {
+		got, err := parseCertFingerprint(s)
+		if err != nil {
+			t.Errorf("parseCertFingerprint(%q) error = %v", s, err)
+			continue
+		}
+		if string(got) != string(sum[:]) {
+			t.Errorf("parseCertFingerprint(%q) = %x, want %x", s, got, sum)
+		}
+	}
+
+	for _, s := range []string{
+		"",
+		hex.EncodeToString(sum[:]),
+		"SHA1:" + hex.EncodeToString(sum[:20]),
+		"SHA256:" + hex.EncodeToString(sum[:20]),
+		"SHA256:not a digest",
+	} {
+		if _, err := parseCertFingerprint(s); err == nil {
+			t.Errorf("parseCertFingerprint(%q) accepted an invalid fingerprint", s)
+		}
+	}
+}
+
+func TestNewBMCService_CertFingerprint(t *testing.T) {
+	const mac = "aa:bb:cc:dd:ee:ff"
+	fc := newSessionController(t, mac)
+
+	getStats := func(fingerprint string) error {
+		t.Helper()
+		cfg := config.Default()
+		cfg.APIEndpoint = fc.srv.URL
+		cfg.CertFingerprint = fingerprint
+		svc, err := NewBMCService(cfg, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = svc.(*bmcService).client.GetDeviceStats(context.Background(), "default", mac)
+		return err
+	}
+
+	if err := getStats(certFingerprint(fc.srv.Certificate().Raw)); err != nil {
+		t.Errorf("GetDeviceStats() with the matching fingerprint error = %v", err)
+	}
+	if err := getStats(certFingerprint([]byte("another certificate"))); err == nil || !strings.Contains(err.Error(), "pinned fingerprint") {
+		t.Errorf("GetDeviceStats() with another fingerprint error = %v, want a fingerprint mismatch", err)
+	}
+
+	cfg := config.Default()
+	cfg.CertFingerprint = "SHA256:abc"
+	if _, err := NewBMCService(cfg, nil); err == nil {
+		t.Error("NewBMCService() accepted a malformed fingerprint")
+	}
+}
@@ -0,0 +1,69 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// PowerCycleAllParams are the parameters of the powerCycleAll RPC method.
+type PowerCycleAllParams struct {
+	// Exclude lists further switch ports to leave alone on top of the
+	// configured critical ports.
+	Exclude []int `json:"exclude,omitempty"`
+}
+
+// PortCycleResult is the outcome for one powered port of a powerCycleAll
+// request. Ports are numbered as on the switch, like in getPoEStatus.
+type PortCycleResult struct {
+	Port int `json:"port"`
+	// Excluded is set for ports that were on but left alone because they
+	// are critical or excluded by the request.
+	Excluded bool   `json:"excluded,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// powerCycleAll power cycles every port of the device that is on, except
+// the critical ports and exclude. Cycles are b.cycleStagger apart so the
+// devices behind the ports do not all draw their inrush current at once.
+func (b *bmcService) powerCycleAll(ctx context.Context, macAddress string, exclude []int) ([]PortCycleResult, error) {
+	if err := b.maintenance.check(); err != nil {
+		return nil, err
+	}
+	ports, err := b.GetAllPoEStatus(ctx, macAddress)
+	if err != nil {
+		return nil, err
+	}
+	if b.devices != nil {
+		defer b.devices.invalidate(macAddress)
+	}
+
+	var results []PortCycleResult
+	cycled := 0
+	for _, p := range ports {
+		if b.portPowerState(p.Port, p.PoE, p.Mode) != PoweredOn {
+			continue
+		}
+		res := PortCycleResult{Port: p.Port}
+		if slices.Contains(b.criticalPorts, p.Port) || slices.Contains(exclude, p.Port) {
+			res.Excluded = true
+			results = append(results, res)
+			continue
+		}
+
+		if cycled > 0 {
+			select {
+			case <-ctx.Done():
+				return results, ctx.Err()
+			case <-time.After(b.cycleStagger):
+			}
+		}
+		cycled++
+		if err = b.client.PowerCyclePort(ctx, b.site, macAddress, p.Port); err != nil {
+			res.Error = fmt.Sprintf("error power cycling port %d: %v", p.Port, err)
+		}
+		results = append(results, res)
+	}
+	return results, nil
+}
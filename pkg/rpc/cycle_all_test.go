@@ -0,0 +1,82 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// timingClient records when each port was power cycled.
+type timingClient struct {
+	fakeClient
+	at []time.Time
+}
+
+func (c *timingClient) PowerCyclePort(ctx context.Context, site, mac string, port int) error {
+	c.at = append(c.at, time.Now())
+	return c.fakeClient.PowerCyclePort(ctx, site, mac, port)
+}
+
+func TestPowerCycleAll_Exclusions(t *testing.T) {
+	fc := &fakeClient{stats: loadDeviceStats(t, "stat_device_usw.json")}
+	svc := newTestService(fc, nil)
+	svc.criticalPorts = []int{4}
+
+	results, err := svc.powerCycleAll(context.Background(), "aa:bb:cc:dd:ee:ff", []int{2})
+	if err != nil {
+		t.Fatalf("powerCycleAll() error = %v", err)
+	}
+	// Port 3 is off and port 17 has no PoE, so neither is listed.
+	want := []PortCycleResult{
+		{Port: 1},
+		{Port: 2, Excluded: true},
+		{Port: 4, Excluded: true},
+	}
+	if !reflect.DeepEqual(results, want) {
+		t.Errorf("results = %+v, want %+v", results, want)
+	}
+	if want := []int{1}; !reflect.DeepEqual(fc.cycled, want) {
+		t.Errorf("cycled ports %v, want %v", fc.cycled, want)
+	}
+}
+
+func TestPowerCycleAll_Stagger(t *testing.T) {
+	const stagger = 40 * time.Millisecond
+	tc := &timingClient{fakeClient: fakeClient{stats: loadDeviceStats(t, "stat_device_usw.json")}}
+	svc := newTestService(tc, nil)
+	svc.cycleStagger = stagger
+
+	start := time.Now()
+	if _, err := svc.powerCycleAll(context.Background(), "aa:bb:cc:dd:ee:ff", nil); err != nil {
+		t.Fatalf("powerCycleAll() error = %v", err)
+	}
+	if want := []int{1, 2, 4}; !reflect.DeepEqual(tc.cycled, want) {
+		t.Fatalf("cycled ports %v, want %v", tc.cycled, want)
+	}
+	if d := tc.at[0].Sub(start); d >= stagger {
+		t.Errorf("first port was cycled after %v, want no delay", d)
+	}
+	for i := 1; i < len(tc.at); i++ {
+		if d := tc.at[i].Sub(tc.at[i-1]); d < stagger {
+			t.Errorf("port %d was cycled %v after the previous one, want at least %v", tc.cycled[i], d, stagger)
+		}
+	}
+}
+
+func TestPowerCycleAll_StaggerHonorsContext(t *testing.T) {
+	fc := &fakeClient{stats: loadDeviceStats(t, "stat_device_usw.json")}
+	svc := newTestService(fc, nil)
+	svc.cycleStagger = time.Hour
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	results, err := svc.powerCycleAll(ctx, "aa:bb:cc:dd:ee:ff", nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("powerCycleAll() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if len(results) != 1 || len(fc.cycled) != 1 {
+		t.Errorf("results = %+v, cycled = %v, want only the first port", results, fc.cycled)
+	}
+}
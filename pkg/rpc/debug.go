@@ -0,0 +1,40 @@
+package rpc
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// DebugDeviceStats returns the stat/device response of the controller for
+// the device without decoding it, so a decoding problem can be reported
+// with the data that caused it.
+func (b *bmcService) DebugDeviceStats(ctx context.Context, macAddress string) ([]byte, error) {
+	return b.client.GetRawDeviceStats(ctx, b.site, macAddress)
+}
+
+// DebugStatsHandler answers with the output of DebugDeviceStats for the
+// device in the path, on the controller selected by the host query
+// parameter.
+func (b *bmcService) DebugStatsHandler(w http.ResponseWriter, r *http.Request) {
+	mac := mux.Vars(r)["mac"]
+	logger := b.requestLogger(r).With("mac", mac)
+
+	svc, err := b.forHost(r.URL.Query().Get("host"))
+	if err != nil {
+		logger.Error("error selecting controller", "error", err)
+		writeError(w, ResponsePayload{}, http.StatusBadRequest, ReasonUnknownHost, err.Error())
+		return
+	}
+
+	raw, err := svc.DebugDeviceStats(r.Context(), mac)
+	if err != nil {
+		logger.Error("error getting device stats", "error", err)
+		writeCallError(w, ResponsePayload{}, err, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(raw)
+}
@@ -0,0 +1,65 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/paultyng/go-unifi/unifi"
+)
+
+func TestLazyClient_GetRawDeviceStats(t *testing.T) {
+	want, err := os.ReadFile("testdata/stat_device_usw_mixed.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/proxy/network/api/s/default/stat/device/aa:bb:cc:dd:ee:ff" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write(want)
+	}))
+	defer srv.Close()
+
+	c := &lazyClient{baseURL: srv.URL, apiPath: "/proxy/network/api", http: srv.Client(), inner: &unifi.Client{}}
+	got, err := c.GetRawDeviceStats(context.Background(), "default", "aa:bb:cc:dd:ee:ff")
+	if err != nil {
+		t.Fatalf("GetRawDeviceStats() error = %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("GetRawDeviceStats() = %s, want the response unchanged", got)
+	}
+}
+
+func TestDebugStatsHandler(t *testing.T) {
+	fc := &fakeClient{stats: loadDeviceStats(t, "stat_device_usw.json")}
+	svc := newTestService(fc, nil)
+
+	r := mux.NewRouter()
+	r.HandleFunc("/debug/device/{mac}/stats", svc.DebugStatsHandler)
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/device/aa:bb:cc:dd:ee:ff/stats", http.NoBody))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	stats, err := decodeDeviceStats(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats.PortTable) != len(fc.stats.PortTable) {
+		t.Errorf("%d ports, want %d", len(stats.PortTable), len(fc.stats.PortTable))
+	}
+
+	fc.err = context.DeadlineExceeded
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/device/aa:bb:cc:dd:ee:ff/stats", http.NoBody))
+	if rec.Code == http.StatusOK {
+		t.Errorf("status = %d after a failed call, want an error", rec.Code)
+	}
+}
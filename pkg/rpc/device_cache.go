@@ -0,0 +1,122 @@
+package rpc
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/paultyng/go-unifi/unifi"
+)
+
+// deviceCache keeps recently fetched devices and their stats for a short
+// time so that aggressive power state polling does not hit the controller on
+// every call. A zero ttl disables caching.
+type deviceCache struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]deviceCacheEntry
+	stats   map[string]statsCacheEntry
+}
+
+type deviceCacheEntry struct {
+	device  unifi.Device
+	fetched time.Time
+}
+
+type statsCacheEntry struct {
+	stats   deviceStats
+	fetched time.Time
+}
+
+func newDeviceCache(ttl time.Duration) *deviceCache {
+	return &deviceCache{
+		ttl:     ttl,
+		now:     time.Now,
+		entries: map[string]deviceCacheEntry{},
+		stats:   map[string]statsCacheEntry{},
+	}
+}
+
+// copyDevice returns a copy of d that does not share the port overrides
+// slice, so callers may modify it freely.
+func copyDevice(d *unifi.Device) *unifi.Device {
+	c := *d
+	c.PortOverrides = append([]unifi.DevicePortOverrides(nil), d.PortOverrides...)
+	c.OutletOverrides = append([]unifi.DeviceOutletOverrides(nil), d.OutletOverrides...)
+	return &c
+}
+
+func (c *deviceCache) get(mac string) (*unifi.Device, bool) {
+	if c.ttl <= 0 {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[strings.ToLower(mac)]
+	if !ok || c.now().Sub(e.fetched) >= c.ttl {
+		return nil, false
+	}
+	return copyDevice(&e.device), true
+}
+
+func (c *deviceCache) put(mac string, d *unifi.Device) {
+	if c.ttl <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[strings.ToLower(mac)] = deviceCacheEntry{
+		device:  *copyDevice(d),
+		fetched: c.now(),
+	}
+}
+
+// copyStats returns a copy of s that does not share the port table.
+func copyStats(s *deviceStats) *deviceStats {
+	c := *s
+	c.PortTable = append([]portStat(nil), s.PortTable...)
+	return &c
+}
+
+func (c *deviceCache) getStats(mac string) (*deviceStats, bool) {
+	if c.ttl <= 0 {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.stats[strings.ToLower(mac)]
+	if !ok || c.now().Sub(e.fetched) >= c.ttl {
+		return nil, false
+	}
+	return copyStats(&e.stats), true
+}
+
+func (c *deviceCache) putStats(mac string, s *deviceStats) {
+	if c.ttl <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.stats[strings.ToLower(mac)] = statsCacheEntry{
+		stats:   *copyStats(s),
+		fetched: c.now(),
+	}
+}
+
+func (c *deviceCache) invalidate(mac string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, strings.ToLower(mac))
+	delete(c.stats, strings.ToLower(mac))
+}
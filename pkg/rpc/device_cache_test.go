@@ -0,0 +1,109 @@
+package rpc
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/paultyng/go-unifi/unifi"
+)
+
+type countingClient struct {
+	fakeClient
+
+	mu        sync.Mutex
+	gets      int
+	statsGets int
+}
+
+func (c *countingClient) GetDeviceByMAC(ctx context.Context, site, mac string) (*unifi.Device, error) {
+	c.mu.Lock()
+	c.gets++
+	c.mu.Unlock()
+	return c.fakeClient.GetDeviceByMAC(ctx, site, mac)
+}
+
+func (c *countingClient) GetDeviceStats(ctx context.Context, site, mac string) (*deviceStats, error) {
+	c.mu.Lock()
+	c.statsGets++
+	c.mu.Unlock()
+	return c.fakeClient.GetDeviceStats(ctx, site, mac)
+}
+
+func TestDeviceCache_Expiry(t *testing.T) {
+	now := time.Now()
+	c := newDeviceCache(2 * time.Second)
+	c.now = func() time.Time { return now }
+
+	c.put("AA:BB:CC:DD:EE:FF", newTestDevice("auto"))
+	if _, ok := c.get("aa:bb:cc:dd:ee:ff"); !ok {
+		t.Fatal("fresh entry was not served from the cache")
+	}
+
+	now = now.Add(2 * time.Second)
+	if _, ok := c.get("aa:bb:cc:dd:ee:ff"); ok {
+		t.Error("expired entry was served from the cache")
+	}
+}
+
+func TestDeviceCache_ReturnsCopies(t *testing.T) {
+	c := newDeviceCache(time.Minute)
+	c.put("aa:bb:cc:dd:ee:ff", newTestDevice("auto"))
+
+	d, _ := c.get("aa:bb:cc:dd:ee:ff")
+	d.PortOverrides[0].PoeMode = "off"
+
+	d, _ = c.get("aa:bb:cc:dd:ee:ff")
+	if got := d.PortOverrides[0].PoeMode; got != "auto" {
+		t.Errorf("cached mode = %q after modifying a returned copy, want auto", got)
+	}
+}
+
+func TestGetPower_CacheInvalidatedBySet(t *testing.T) {
+	cc := &countingClient{fakeClient: fakeClient{device: newTestDevice("auto")}}
+	svc := newTestService(cc, newDeviceCache(time.Minute))
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if state, err := svc.GetPower(ctx, "aa:bb:cc:dd:ee:ff", "1"); err != nil || state != "on" {
+			t.Fatalf("GetPower() = %q, %v, want on", state, err)
+		}
+	}
+	if cc.statsGets != 1 {
+		t.Errorf("controller was queried %d times for cached reads, want 1", cc.statsGets)
+	}
+
+	if err := svc.SetPortPower(ctx, "aa:bb:cc:dd:ee:ff", "1", "off"); err != nil {
+		t.Fatal(err)
+	}
+	cc.device = cc.updated
+
+	if state, err := svc.GetPower(ctx, "aa:bb:cc:dd:ee:ff", "1"); err != nil || state != "off" {
+		t.Errorf("GetPower() after set = %q, %v, want off", state, err)
+	}
+}
+
+func TestGetPower_CacheConcurrent(t *testing.T) {
+	cc := &countingClient{fakeClient: fakeClient{device: newTestDevice("auto", "off")}}
+	svc := newTestService(cc, newDeviceCache(time.Minute))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			port := "1"
+			if i%2 == 1 {
+				port = "2"
+			}
+			if _, err := svc.GetPower(context.Background(), "aa:bb:cc:dd:ee:ff", port); err != nil {
+				t.Error(err)
+			}
+			if i%10 == 0 {
+				svc.devices.invalidate("aa:bb:cc:dd:ee:ff")
+			}
+		}(i)
+	}
+	wg.Wait()
+}
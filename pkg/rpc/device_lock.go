@@ -0,0 +1,41 @@
+package rpc
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+// deviceLocks serializes the read-modify-write cycle of changing a device.
+// The controller only accepts the port overrides of a device as a whole, so
+// two concurrent changes to different ports of one switch would otherwise
+// overwrite each other. Changes to different devices still run in parallel.
+type deviceLocks struct {
+	mu    sync.Mutex
+	locks map[string]chan struct{}
+}
+
+func newDeviceLocks() *deviceLocks {
+	return &deviceLocks{locks: map[string]chan struct{}{}}
+}
+
+// lock waits until no other change to the device mac is in progress, or
+// until ctx ends. The returned func releases the lock.
+func (l *deviceLocks) lock(ctx context.Context, mac string) (func(), error) {
+	key := strings.ToLower(mac)
+
+	l.mu.Lock()
+	ch, ok := l.locks[key]
+	if !ok {
+		ch = make(chan struct{}, 1)
+		l.locks[key] = ch
+	}
+	l.mu.Unlock()
+
+	select {
+	case ch <- struct{}{}:
+		return func() { <-ch }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,75 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/paultyng/go-unifi/unifi"
+)
+
+func TestDeviceLocks(t *testing.T) {
+	l := newDeviceLocks()
+	unlock, err := l.lock(context.Background(), "aa:bb:cc:dd:ee:ff")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := l.lock(context.Background(), "aa:bb:cc:00:00:01")
+	if err != nil {
+		t.Fatalf("locking another device error = %v", err)
+	}
+	other()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err = l.lock(ctx, "AA:BB:CC:DD:EE:FF"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("second lock of a device error = %v, want it to wait", err)
+	}
+
+	unlock()
+	if unlock, err = l.lock(context.Background(), "aa:bb:cc:dd:ee:ff"); err != nil {
+		t.Fatalf("lock after unlock error = %v", err)
+	}
+	unlock()
+}
+
+// slowController widens the window between reading and updating a device,
+// where unserialized changes would lose each other's port overrides.
+type slowController struct {
+	*FakeController
+}
+
+func (c slowController) GetDeviceByMAC(ctx context.Context, site, mac string) (*unifi.Device, error) {
+	d, err := c.FakeController.GetDeviceByMAC(ctx, site, mac)
+	time.Sleep(time.Millisecond)
+	return d, err
+}
+
+func TestSetPortPower_ConcurrentPorts(t *testing.T) {
+	const mac, ports = "aa:bb:cc:dd:ee:ff", 24
+	fc := NewFakeController()
+	fc.AddSwitch(mac, ports)
+	svc := newTestService(slowController{fc}, nil)
+
+	var wg sync.WaitGroup
+	for p := 1; p <= ports; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			if err := svc.SetPortPower(context.Background(), mac, strconv.Itoa(p), PowerStateOff); err != nil {
+				t.Errorf("SetPortPower(%d) error = %v", p, err)
+			}
+		}(p)
+	}
+	wg.Wait()
+
+	for p := 1; p <= ports; p++ {
+		if st, _ := fc.PortState(mac, p); st != PoweredOff {
+			t.Errorf("port %d = %q after concurrent changes, want %q", p, st, PoweredOff)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/paultyng/go-unifi/unifi"
+)
+
+func TestLazyClient_Devmgr(t *testing.T) {
+	var got devmgrCommand
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/proxy/network/api/s/default/cmd/devmgr" {
+			http.NotFound(w, r)
+			return
+		}
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		if got.PortIdx == 99 {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"meta":{"rc":"error","msg":"api.err.InvalidTarget"}}`))
+		}
+	}))
+	defer srv.Close()
+
+	c := &lazyClient{baseURL: srv.URL, apiPath: "/proxy/network/api", http: srv.Client(), inner: &unifi.Client{}}
+	cmd := devmgrCommand{Cmd: "power-cycle", MAC: "aa:bb:cc:dd:ee:ff", PortIdx: 3}
+	if err := c.devmgr(context.Background(), "default", cmd); err != nil {
+		t.Fatalf("devmgr() error = %v", err)
+	}
+	if got != cmd {
+		t.Errorf("controller got %+v, want %+v", got, cmd)
+	}
+
+	cmd.PortIdx = 99
+	err := c.devmgr(context.Background(), "default", cmd)
+	if err == nil || !strings.HasSuffix(err.Error(), "api.err.InvalidTarget") || !strings.Contains(err.Error(), "for POST ") {
+		t.Errorf("devmgr() error = %v, want the controller message", err)
+	}
+}
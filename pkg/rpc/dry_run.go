@@ -0,0 +1,42 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/paultyng/go-unifi/unifi"
+)
+
+// dryRunClient forwards reads to the controller but only logs device
+// updates, so the changes a request would make can be checked without
+// applying them.
+type dryRunClient struct {
+	unifiClient
+	logger *slog.Logger
+}
+
+func (c *dryRunClient) UpdateDevice(_ context.Context, site string, d *unifi.Device) (*unifi.Device, error) {
+	ports := make([]string, 0, len(d.PortOverrides))
+	for _, p := range d.PortOverrides {
+		ports = append(ports, fmt.Sprintf("%d=%s", p.PortIDX, p.PoeMode))
+	}
+	outlets := make([]string, 0, len(d.OutletOverrides))
+	for _, o := range d.OutletOverrides {
+		outlets = append(outlets, fmt.Sprintf("%d=%s", o.Index, relayState(o.RelayState)))
+	}
+	c.logger.Info("dry run, skipping device update", "site", site, "mac", d.MAC, "poeModes", ports, "outlets", outlets)
+	return d, nil
+}
+
+func (c *dryRunClient) PowerCyclePort(_ context.Context, site, mac string, port int) error {
+	c.logger.Info("dry run, skipping port power cycle", "site", site, "mac", mac, "port", port)
+	return nil
+}
+
+// Close closes the wrapped client, which keeps running in the background.
+func (c *dryRunClient) Close() {
+	if cl, ok := c.unifiClient.(closer); ok {
+		cl.Close()
+	}
+}
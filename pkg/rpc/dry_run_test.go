@@ -0,0 +1,20 @@
+package rpc
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+)
+
+func TestDryRunClient_SkipsUpdate(t *testing.T) {
+	fc := &fakeClient{device: newTestDevice("auto")}
+	svc := newTestService(&dryRunClient{unifiClient: fc, logger: slog.New(slog.NewTextHandler(io.Discard, nil))}, nil)
+
+	if err := svc.SetPortPower(context.Background(), "aa:bb:cc:dd:ee:ff", "1", PowerStateOff); err != nil {
+		t.Fatalf("SetPortPower() error = %v", err)
+	}
+	if fc.updated != nil {
+		t.Error("dry run sent the device update to the controller")
+	}
+}
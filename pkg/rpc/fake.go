@@ -0,0 +1,222 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/paultyng/go-unifi/unifi"
+)
+
+// FakeController is an in-memory UniFi controller for testing code that
+// drives a BMCService without a real controller, see NewFakeBMCService. It
+// is safe for concurrent use.
+type FakeController struct {
+	mu      sync.Mutex
+	devices map[string]*unifi.Device
+	err     error
+}
+
+// NewFakeController returns a controller without any devices.
+func NewFakeController() *FakeController {
+	return &FakeController{devices: map[string]*unifi.Device{}}
+}
+
+// NewFakeBMCService returns a BMCService backed by f, with caching disabled
+// so that changes made through f are seen immediately. A nil logger
+// discards all logs.
+// fakeWatchInterval is the poll interval of WatchHandler on a fake
+// service, short enough for tests.
+const fakeWatchInterval = 20 * time.Millisecond
+
+func NewFakeBMCService(f *FakeController, logger *slog.Logger) BMCService {
+	if logger == nil {
+		logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+	bootDevices, _ := newBootDeviceStore("")
+	history, _ := newPowerHistory("")
+	return &bmcService{
+		logger:      logger,
+		client:      f,
+		clients:     map[string]unifiClient{"": f},
+		site:        "default",
+		devices:     newDeviceCache(0),
+		switchInfo:  newSwitchInfoCache(),
+		bootDevices: bootDevices,
+		history:     history,
+		maintenance: &maintenanceLock{},
+		stats:       newRPCStats(),
+		poeModes:    defaultPoEModes,
+		locks:       newDeviceLocks(),
+		watcher:     newPowerWatcher(fakeWatchInterval, logger),
+	}
+}
+
+// AddSwitch adds a switch with the given number of ports, all powered on.
+func (f *FakeController) AddSwitch(mac string, ports int) {
+	d := &unifi.Device{ID: "fake-" + mac, MAC: mac, State: unifi.DeviceStateConnected}
+	for i := 1; i <= ports; i++ {
+		d.PortOverrides = append(d.PortOverrides, unifi.DevicePortOverrides{PortIDX: i, PoeMode: "auto"})
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.devices[strings.ToLower(mac)] = d
+}
+
+// SetPortState sets the power state of a port as if changed elsewhere.
+func (f *FakeController) SetPortState(mac string, port int, state PowerGetResult) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	d, err := f.device(mac)
+	if err != nil {
+		return err
+	}
+	_, err = setPoeMode(defaultPoEModes, d, port, state.String())
+	return err
+}
+
+// PortState returns the power state of a port.
+func (f *FakeController) PortState(mac string, port int) (PowerGetResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	d, err := f.device(mac)
+	if err != nil {
+		return "", err
+	}
+	for _, p := range d.PortOverrides {
+		if p.PortIDX == port {
+			return defaultPoEModes.state(p.PoeMode), nil
+		}
+	}
+	return "", noOverride(d, port)
+}
+
+// SetProvisioning marks a switch as provisioning, which makes its ports
+// report PoweringOn and PoweringOff.
+func (f *FakeController) SetProvisioning(mac string, provisioning bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	d, err := f.device(mac)
+	if err != nil {
+		return err
+	}
+	d.State = unifi.DeviceStateConnected
+	if provisioning {
+		d.State = unifi.DeviceStateProvisioning
+	}
+	return nil
+}
+
+// SetError makes every following controller call fail with err until it is
+// reset with a nil error.
+func (f *FakeController) SetError(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.err = err
+}
+
+// device returns the stored device for mac. f.mu must be held.
+func (f *FakeController) device(mac string) (*unifi.Device, error) {
+	d, ok := f.devices[strings.ToLower(mac)]
+	if !ok {
+		return nil, fmt.Errorf("fake controller has no device %s: %w", mac, &unifi.NotFoundError{})
+	}
+	return d, nil
+}
+
+func (f *FakeController) GetDeviceByMAC(_ context.Context, _, mac string) (*unifi.Device, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.err != nil {
+		return nil, f.err
+	}
+	d, err := f.device(mac)
+	if err != nil {
+		return nil, err
+	}
+	return copyDevice(d), nil
+}
+
+func (f *FakeController) UpdateDevice(_ context.Context, _ string, d *unifi.Device) (*unifi.Device, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.err != nil {
+		return nil, f.err
+	}
+	if _, err := f.device(d.MAC); err != nil {
+		return nil, err
+	}
+	f.devices[strings.ToLower(d.MAC)] = copyDevice(d)
+	return d, nil
+}
+
+func (f *FakeController) GetDeviceStats(_ context.Context, _, mac string) (*deviceStats, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.err != nil {
+		return nil, f.err
+	}
+	d, err := f.device(mac)
+	if err != nil {
+		return nil, err
+	}
+	stats := &deviceStats{MAC: d.MAC, Name: d.Name, Model: d.Model, State: d.State}
+	for _, p := range d.PortOverrides {
+		stats.PortTable = append(stats.PortTable, portStat{
+			PortIdx:   p.PortIDX,
+			PortPoE:   true,
+			PoEEnable: p.PoeMode != "off",
+			PoEMode:   p.PoeMode,
+		})
+	}
+	return stats, nil
+}
+
+// GetRawDeviceStats encodes the GetDeviceStats result the way the controller
+// sends it.
+func (f *FakeController) GetRawDeviceStats(ctx context.Context, site, mac string) ([]byte, error) {
+	stats, err := f.GetDeviceStats(ctx, site, mac)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(map[string]any{"data": []*deviceStats{stats}})
+}
+
+// PowerCyclePort leaves the port in its state, as a real cycle ends with
+// the port powered again.
+func (f *FakeController) PowerCyclePort(_ context.Context, _, mac string, port int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.err != nil {
+		return f.err
+	}
+	d, err := f.device(mac)
+	if err != nil {
+		return err
+	}
+	for _, p := range d.PortOverrides {
+		if p.PortIDX == port {
+			return nil
+		}
+	}
+	return noOverride(d, port)
+}
+
+func (f *FakeController) Preflight(context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.err
+}
@@ -0,0 +1,48 @@
+package rpc_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/ubiquiti-community/unifi-rpc/pkg/rpc"
+)
+
+func serveFake(t *testing.T, svc rpc.BMCService, body string) string {
+	t.Helper()
+	r := mux.NewRouter()
+	r.HandleFunc("/device/{mac}/port/{port}/rpc", svc.RPCHandler)
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/device/aa:bb:cc:dd:ee:ff/port/2/rpc", strings.NewReader(body)))
+	return rec.Body.String()
+}
+
+func TestFakeController(t *testing.T) {
+	fc := rpc.NewFakeController()
+	fc.AddSwitch("aa:bb:cc:dd:ee:ff", 8)
+	svc := rpc.NewFakeBMCService(fc, nil)
+
+	if err := svc.SetPortPower(context.Background(), "aa:bb:cc:dd:ee:ff", "2", rpc.PowerStateOff); err != nil {
+		t.Fatalf("SetPortPower() error = %v", err)
+	}
+	if st, _ := fc.PortState("aa:bb:cc:dd:ee:ff", 2); st != rpc.PoweredOff {
+		t.Errorf("PortState() = %q, want %q", st, rpc.PoweredOff)
+	}
+
+	if err := fc.SetProvisioning("aa:bb:cc:dd:ee:ff", true); err != nil {
+		t.Fatal(err)
+	}
+	if body := serveFake(t, svc, `{"id":1,"method":"getPowerState"}`); !strings.Contains(body, `"result":"powering off"`) {
+		t.Errorf("provisioning: body = %s, want powering off", body)
+	}
+
+	fc.SetError(errors.New("controller unavailable"))
+	if body := serveFake(t, svc, `{"id":1,"method":"getPowerState"}`); !strings.Contains(body, "controller unavailable") {
+		t.Errorf("injected error: body = %s, want the error", body)
+	}
+}
@@ -0,0 +1,100 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/paultyng/go-unifi/unifi"
+)
+
+// budgetPollInterval is how often powerOnStaggered checks the PoE budget
+// when no powerOnStagger is configured.
+const budgetPollInterval = time.Second
+
+// stagedPort is an entry of a setPortPowerBatch request that turns a switch
+// port on and is left to powerOnStaggered.
+type stagedPort struct {
+	i    int
+	port int
+}
+
+func (b *bmcService) staggersPowerOn() bool {
+	return b.powerOnStagger > 0 || b.powerOnReserve > 0
+}
+
+// powerOnStaggered turns on the staged ports of dev one device update at a
+// time, powerOnStagger apart, so that the inrush currents of the devices
+// behind them do not trip the PoE budget of the switch. With powerOnReserve
+// set, each port first waits until the switch has that much budget left.
+// Once ctx ends or an update fails, the remaining ports are left off with
+// the error in their result.
+func (b *bmcService) powerOnStaggered(ctx context.Context, dev *unifi.Device, ports []PortPowerSetParams, staged []stagedPort, results []PortPowerSetResult) {
+	enabled := 0
+	for n, s := range staged {
+		changed, err := b.setPortPoEMode(dev, s.port, ports[s.i].State)
+		if err != nil {
+			results[s.i].Error = b.portError(ctx, dev, s.port, err).Error()
+			continue
+		}
+		if !changed {
+			continue
+		}
+
+		if enabled > 0 {
+			err = sleepCtx(ctx, b.powerOnStagger)
+		}
+		if err == nil {
+			err = b.waitForBudget(ctx, dev.MAC)
+		}
+		if err == nil {
+			if err = b.updateDevice(ctx, dev); err != nil {
+				err = fmt.Errorf("error updating device: %w", err)
+			}
+		}
+		if err != nil {
+			for _, rest := range staged[n:] {
+				results[rest.i].Error = err.Error()
+			}
+			return
+		}
+		enabled++
+	}
+}
+
+// waitForBudget waits until the device has powerOnReserve watts of PoE
+// budget left. Devices that report no budget are not waited for.
+func (b *bmcService) waitForBudget(ctx context.Context, macAddress string) error {
+	if b.powerOnReserve <= 0 {
+		return nil
+	}
+	interval := b.powerOnStagger
+	if interval <= 0 {
+		interval = budgetPollInterval
+	}
+	for {
+		res, err := b.getPoEStatus(ctx, macAddress)
+		if err != nil {
+			return err
+		}
+		if res.BudgetWatts == 0 || res.RemainingWatts >= b.powerOnReserve {
+			return nil
+		}
+		b.logger.Info("waiting for PoE budget before powering on the next port", "mac", macAddress, "remainingWatts", res.RemainingWatts, "reserveWatts", b.powerOnReserve)
+		if err = sleepCtx(ctx, interval); err != nil {
+			return err
+		}
+	}
+}
+
+// sleepCtx waits for d or until ctx ends, returning the error of the latter.
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
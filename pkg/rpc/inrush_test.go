@@ -0,0 +1,130 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/paultyng/go-unifi/unifi"
+)
+
+// budgetClient reports a PoE budget of 60W with the total draws in draws,
+// one per stats read, repeating the last one.
+type budgetClient struct {
+	fakeClient
+	draws []float64
+	reads int
+}
+
+func (c *budgetClient) GetDeviceStats(context.Context, string, string) (*deviceStats, error) {
+	draw := c.draws[min(c.reads, len(c.draws)-1)]
+	c.reads++
+	return &deviceStats{
+		MAC:           "aa:bb:cc:dd:ee:ff",
+		TotalMaxPower: 60,
+		PortTable:     []portStat{{PortIdx: 1, PortPoE: true, PoEPower: flexFloat(draw)}},
+	}, nil
+}
+
+func TestSetPortPowerBatch_StaggersPowerOn(t *testing.T) {
+	// The inrush of port 1 leaves only 10W of budget on the second read,
+	// so port 2 waits for a third read before it is turned on.
+	bc := &budgetClient{fakeClient: fakeClient{device: newTestDevice("off", "off", "auto")}, draws: []float64{10, 50, 40}}
+	svc := newTestService(bc, nil)
+	svc.powerOnStagger = 20 * time.Millisecond
+	svc.powerOnReserve = 15
+
+	start := time.Now()
+	results, err := svc.setPortPowerBatch(context.Background(), "aa:bb:cc:dd:ee:ff", []PortPowerSetParams{
+		{Port: 1, State: "on"},
+		{Port: 2, State: "on"},
+		{Port: 3, State: "off"},
+	})
+	if err != nil {
+		t.Fatalf("setPortPowerBatch() error = %v", err)
+	}
+	for _, r := range results {
+		if r.Error != "" {
+			t.Errorf("port %d error = %q", r.Port, r.Error)
+		}
+	}
+	// Port 3 is turned off with the first update, then ports 1 and 2
+	// follow one update each.
+	if len(bc.updates) != 3 {
+		t.Fatalf("device updated %d times, want 3", len(bc.updates))
+	}
+	if got := bc.updates[0].PortOverrides; got[0].PoeMode != "off" || got[1].PoeMode != "off" || got[2].PoeMode != "off" {
+		t.Errorf("first update = %+v, want only port 3 off", got)
+	}
+	if got := bc.updates[1].PortOverrides; got[0].PoeMode != "auto" || got[1].PoeMode != "off" {
+		t.Errorf("second update = %+v, want port 1 on", got)
+	}
+	if got := bc.updates[2].PortOverrides; got[1].PoeMode != "auto" {
+		t.Errorf("third update = %+v, want port 2 on", got)
+	}
+	if bc.reads != 3 {
+		t.Errorf("budget read %d times, want 3", bc.reads)
+	}
+	if d := time.Since(start); d < 2*svc.powerOnStagger {
+		t.Errorf("batch took %v, want a stagger and a budget wait", d)
+	}
+}
+
+// refusingClient fails every device update, counting the attempts.
+type refusingClient struct {
+	fakeClient
+	attempts int
+}
+
+func (c *refusingClient) UpdateDevice(context.Context, string, *unifi.Device) (*unifi.Device, error) {
+	c.attempts++
+	return nil, errors.New("controller unavailable")
+}
+
+func TestSetPortPowerBatch_UpdateFailureSkipsStagger(t *testing.T) {
+	rc := &refusingClient{fakeClient: fakeClient{device: newTestDevice("auto", "off")}}
+	svc := newTestService(rc, nil)
+	svc.powerOnStagger = time.Millisecond
+
+	results, err := svc.setPortPowerBatch(context.Background(), "aa:bb:cc:dd:ee:ff", []PortPowerSetParams{
+		{Port: 1, State: "off"},
+		{Port: 2, State: "on"},
+	})
+	if err != nil {
+		t.Fatalf("setPortPowerBatch() error = %v", err)
+	}
+	for _, r := range results {
+		if r.Error == "" {
+			t.Errorf("port %d reported no error after the update failed", r.Port)
+		}
+	}
+	if rc.attempts != 1 {
+		t.Errorf("device update attempted %d times, want 1", rc.attempts)
+	}
+}
+
+func TestSetPortPowerBatch_BudgetWaitHonorsContext(t *testing.T) {
+	bc := &budgetClient{fakeClient: fakeClient{device: newTestDevice("off", "off")}, draws: []float64{55}}
+	svc := newTestService(bc, nil)
+	svc.powerOnStagger = 10 * time.Millisecond
+	svc.powerOnReserve = 15
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	results, err := svc.setPortPowerBatch(ctx, "aa:bb:cc:dd:ee:ff", []PortPowerSetParams{
+		{Port: 1, State: "on"},
+		{Port: 2, State: "on"},
+	})
+	if err != nil {
+		t.Fatalf("setPortPowerBatch() error = %v", err)
+	}
+	for _, r := range results {
+		if r.Error == "" {
+			t.Errorf("port %d was turned on without budget", r.Port)
+		}
+	}
+	if len(bc.updates) != 0 {
+		t.Errorf("device updated %d times, want no update", len(bc.updates))
+	}
+}
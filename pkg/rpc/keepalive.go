@@ -0,0 +1,88 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// keepAlive requests the controller status page every c.keepAliveInterval
+// until ctx ends. Controller calls reuse pooled connections, and one to a
+// controller whose link silently dropped only fails once a call on it runs
+// into its deadline. A keepalive that goes unanswered for an interval
+// closes the idle connections instead, so the next call dials afresh.
+func (c *lazyClient) keepAlive(ctx context.Context, hc *http.Client, apiPath string) {
+	t := time.NewTicker(c.keepAliveInterval)
+	defer t.Stop()
+
+	// The status page sits next to the API and needs no session.
+	u := strings.TrimSuffix(c.baseURL, "/") + strings.TrimSuffix(apiPath, "/api") + "/status"
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+
+		pingCtx, cancel := context.WithTimeout(ctx, c.keepAliveInterval)
+		err := ping(pingCtx, hc, u)
+		cancel()
+		if err == nil || ctx.Err() != nil {
+			continue
+		}
+		hc.CloseIdleConnections()
+		if c.logger != nil {
+			c.logger.Warn("controller keepalive failed, closed idle connections", "error", c.redact(err))
+		}
+	}
+}
+
+// startKeepAlive runs keepAlive in the background and returns the func
+// that stops it and waits for it to return.
+func (c *lazyClient) startKeepAlive(hc *http.Client, apiPath string) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.keepAlive(ctx, hc, apiPath)
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// Close stops the keepalive loop. The client keeps serving calls but does
+// not start the loop again.
+func (c *lazyClient) Close() {
+	c.semOnce.Do(func() { c.sem = make(chan struct{}, 1) })
+	c.sem <- struct{}{}
+	defer func() { <-c.sem }()
+
+	c.closed = true
+	if c.stopKeepAlive != nil {
+		c.stopKeepAlive()
+		c.stopKeepAlive = nil
+	}
+}
+
+func ping(ctx context.Context, hc *http.Client, u string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, http.NoBody)
+	if err != nil {
+		return err
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return fmt.Errorf("unable to perform request: GET %s %w", u, err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s for GET %s", resp.Status, u)
+	}
+	return nil
+}
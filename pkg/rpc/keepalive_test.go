@@ -0,0 +1,155 @@
+package rpc
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/paultyng/go-unifi/unifi"
+)
+
+// stallingProxy forwards TCP connections to a backend until stall is
+// called, after which the connections open at that point silently drop
+// everything, like a link that went away without a reset. New connections
+// keep working.
+type stallingProxy struct {
+	ln net.Listener
+
+	mu    sync.Mutex
+	conns []*atomic.Bool
+}
+
+func newStallingProxy(t *testing.T, backend string) *stallingProxy {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &stallingProxy{ln: ln}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			client, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			server, err := net.Dial("tcp", backend)
+			if err != nil {
+				client.Close()
+				continue
+			}
+			stalled := &atomic.Bool{}
+			p.mu.Lock()
+			p.conns = append(p.conns, stalled)
+			p.mu.Unlock()
+			go forward(server, client, stalled)
+			go forward(client, server, stalled)
+		}
+	}()
+	return p
+}
+
+func forward(dst, src net.Conn, stalled *atomic.Bool) {
+	defer dst.Close()
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := src.Read(buf)
+		if err != nil {
+			return
+		}
+		if stalled.Load() {
+			continue
+		}
+		if _, err = dst.Write(buf[:n]); err != nil {
+			return
+		}
+	}
+}
+
+func (p *stallingProxy) stall() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, c := range p.conns {
+		c.Store(true)
+	}
+}
+
+func TestLazyClient_KeepAliveDropsDeadConnections(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/proxy/network/status", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, `{"meta":{"rc":"ok"}}`)
+	})
+	mux.HandleFunc("/proxy/network/api/s/default/stat/device/aa:bb:cc:dd:ee:ff", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, "testdata/stat_device_usw.json")
+	})
+	srv := httptest.NewTLSServer(mux)
+	defer srv.Close()
+	p := newStallingProxy(t, srv.Listener.Addr().String())
+
+	const interval = 50 * time.Millisecond
+	c := &lazyClient{
+		baseURL:           "https://" + p.ln.Addr().String(),
+		apiPath:           "/proxy/network/api",
+		http:              srv.Client(),
+		inner:             &unifi.Client{},
+		keepAliveInterval: interval,
+	}
+	if _, err := c.GetDeviceStats(context.Background(), "default", "aa:bb:cc:dd:ee:ff"); err != nil {
+		t.Fatalf("GetDeviceStats() error = %v", err)
+	}
+
+	// The pooled connection is now dead. Without the keepalive the next
+	// call would be sent on it and wait for its deadline.
+	p.stall()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.keepAlive(ctx, c.http, c.apiPath)
+	time.Sleep(5 * interval)
+
+	callCtx, callCancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer callCancel()
+	if _, err := c.GetDeviceStats(callCtx, "default", "aa:bb:cc:dd:ee:ff"); err != nil {
+		t.Fatalf("GetDeviceStats() after the link dropped error = %v, want a fresh connection", err)
+	}
+}
+
+func TestLazyClient_CloseStopsKeepAlive(t *testing.T) {
+	var pings atomic.Int32
+	srv := newFakeController(t, func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, `{"meta":{"rc":"ok"}}`)
+	}, map[string]http.HandlerFunc{
+		"/proxy/network/status": func(w http.ResponseWriter, _ *http.Request) {
+			pings.Add(1)
+			_, _ = io.WriteString(w, `{"meta":{"rc":"ok","server_version":"8.0.0"}}`)
+		},
+	})
+
+	const interval = 10 * time.Millisecond
+	c := &lazyClient{baseURL: srv.URL, insecure: true, keepAliveInterval: interval}
+	if err := c.Preflight(context.Background()); err != nil {
+		t.Fatalf("Preflight() error = %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for pings.Load() < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("keepalive did not ping the controller")
+		}
+		time.Sleep(interval)
+	}
+
+	c.Close()
+	// A ping cut short by Close may still reach the handler.
+	time.Sleep(5 * interval)
+	n := pings.Load()
+	time.Sleep(5 * interval)
+	if got := pings.Load(); got != n {
+		t.Errorf("keepalive pinged %d times after Close, want 0", got-n)
+	}
+}
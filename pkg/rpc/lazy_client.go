@@ -3,31 +3,93 @@ package rpc
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
 	"net/http"
 	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/paultyng/go-unifi/unifi"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/ubiquiti-community/unifi-rpc/pkg/redact"
 )
 
 type lazyClient struct {
+	logger    *slog.Logger
 	baseURL   string
 	user      string
 	pass      string
 	insecure  bool
 	subsystem string
-
-	once  sync.Once
-	inner *unifi.Client
+	// rootCAs are the CAs trusted for the controller certificate when it
+	// is verified, the system roots when nil.
+	rootCAs *x509.CertPool
+	// certFingerprint is the SHA256 digest of the only controller
+	// certificate accepted when set, in place of verifying its chain.
+	certFingerprint []byte
+	// transport carries the controller requests in place of the one built
+	// from insecure and rootCAs when set.
+	transport http.RoundTripper
+	// maxRetries bounds how often a call is retried after a transient
+	// network failure, and a request after a 429 or 5xx answer.
+	maxRetries int
+	// callTimeout bounds each controller request independently of the
+	// caller context, zero disables the limit.
+	callTimeout time.Duration
+	// keepAliveInterval is how often the connection to the controller is
+	// checked once logged in, zero disables the check.
+	keepAliveInterval time.Duration
+	// limiter bounds the calls in flight together with the clients of the
+	// other controllers, nil when unlimited.
+	limiter *callLimiter
+
+	// session counts the logins after the first, so that callers that
+	// all ran into the same expired session log in again only once.
+	session atomic.Int64
+
+	// sem guards the fields below. It is a channel rather than a mutex so
+	// that callers waiting for another caller's login give up when their
+	// own context ends.
+	semOnce sync.Once
+	sem     chan struct{}
+	inner   *unifi.Client
+	http    *http.Client
+	apiPath string
+	// stopKeepAlive ends the keepalive loop started by init, nil while
+	// none runs. closed is set by Close, after which init starts none.
+	stopKeepAlive func()
+	closed        bool
+}
+
+// loadCABundle reads the PEM encoded CA certificates in path.
+func loadCABundle(path string) (*x509.CertPool, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("caBundle: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("caBundle: no certificates found in %s", path)
+	}
+	return pool, nil
 }
 
-func setHTTPClient(c *unifi.Client, insecure bool, subsystem string) {
-	httpClient := &http.Client{}
-	httpClient.Transport = &http.Transport{
+// newTransport returns the transport used for a controller unless another
+// one is configured. It honors the proxy environment variables.
+func newTransport(insecure bool, rootCAs *x509.CertPool, fingerprint []byte) *http.Transport {
+	t := &http.Transport{
 		Proxy: http.ProxyFromEnvironment,
 		DialContext: (&net.Dialer{
 			Timeout:   30 * time.Second,
@@ -41,42 +103,225 @@ func setHTTPClient(c *unifi.Client, insecure bool, subsystem string) {
 
 		TLSClientConfig: &tls.Config{
 			InsecureSkipVerify: insecure,
+			RootCAs:            rootCAs,
 		},
 	}
+	if fingerprint != nil {
+		pinCertificate(t.TLSClientConfig, fingerprint)
+	}
+	return t
+}
 
+func setHTTPClient(c *unifi.Client, transport http.RoundTripper, timeout time.Duration) (*http.Client, error) {
+	httpClient := &http.Client{Transport: transport, Timeout: timeout}
 	jar, _ := cookiejar.New(nil)
 	httpClient.Jar = jar
 
-	err := c.SetHTTPClient(httpClient)
+	if err := c.SetHTTPClient(httpClient); err != nil {
+		return nil, fmt.Errorf("failed to set http client: %w", err)
+	}
+	return httpClient, nil
+}
+
+// init logs in on first use, retrying transient failures with backoff. A
+// failed login, for example one cut short by a request deadline, is not
+// cached so the next call tries again. Callers waiting for a login in
+// progress return when their context ends.
+func (c *lazyClient) init(ctx context.Context) error {
+	c.semOnce.Do(func() { c.sem = make(chan struct{}, 1) })
+	select {
+	case c.sem <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-c.sem }()
+
+	if c.inner != nil {
+		return nil
+	}
+
+	inner := &unifi.Client{}
+	transport := c.transport
+	if transport == nil {
+		transport = newTransport(c.insecure, c.rootCAs, c.certFingerprint)
+	}
+	if c.maxRetries > 0 {
+		transport = &retryStatusTransport{next: transport, maxRetries: c.maxRetries, baseDelay: retryBaseDelay}
+	}
+	httpClient, err := setHTTPClient(inner, transport, c.callTimeout)
 	if err != nil {
-		panic(fmt.Sprintf("failed to set http client: %s", err))
+		return err
+	}
+
+	if err := inner.SetBaseURL(c.baseURL); err != nil {
+		return c.redact(err)
 	}
+
+	var apiPath string
+	err = withRetry(ctx, c.maxRetries, retryBaseDelay, func() (err error) {
+		apiPath, err = detectAPIPath(ctx, httpClient, c.baseURL)
+		return err
+	})
+	if err != nil {
+		return markUnreachable(c.redact(err))
+	}
+
+	loginCtx, span := startCall(ctx, "Login")
+	start := time.Now()
+	err = withRetry(loginCtx, c.maxRetries, retryBaseDelay, func() error {
+		return inner.Login(loginCtx, c.user, c.pass)
+	})
+	if err != nil {
+		err = markUnreachable(c.redact(err))
+		c.logCall(span, "Login", start, err)
+		return err
+	}
+	c.logCall(span, "Login", start, nil, "version", inner.Version())
+
+	c.inner = inner
+	c.http = httpClient
+	c.apiPath = apiPath
+	if c.keepAliveInterval > 0 && !c.closed {
+		c.stopKeepAlive = c.startKeepAlive(httpClient, apiPath)
+	}
+	return nil
 }
 
-var initErr error
+// errSessionExpired marks controller responses that reject the session
+// cookie, after which the client has to log in again.
+var errSessionExpired = errors.New("controller session expired")
 
-func (c *lazyClient) init(ctx context.Context) error {
-	c.once.Do(func() {
-		c.inner = &unifi.Client{}
-		setHTTPClient(c.inner, c.insecure, c.subsystem)
+// isSessionExpired reports whether err means the controller no longer
+// accepts the session. go-unifi only reports the controller message and the
+// status text of failed responses, so both are checked.
+func isSessionExpired(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, errSessionExpired) {
+		return true
+	}
+	var apiErr *unifi.APIError
+	if errors.As(err, &apiErr) && apiErr.Message == "api.err.LoginRequired" {
+		return true
+	}
+	return strings.Contains(err.Error(), "(401 Unauthorized)")
+}
+
+// withSession runs op and, if the controller answers that the session
+// expired, logs in again and runs op once more. It must be called after
+// init succeeded.
+func (c *lazyClient) withSession(ctx context.Context, op func() error) error {
+	session := c.session.Load()
+	err := op()
+	if !isSessionExpired(err) {
+		return err
+	}
+	if err := c.relogin(ctx, session); err != nil {
+		return err
+	}
+	return op()
+}
+
+// call runs op like withSession, retrying transient failures with backoff.
+func (c *lazyClient) call(ctx context.Context, op func() error) error {
+	release, err := c.limiter.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return c.withSession(ctx, func() error {
+		return withRetry(ctx, c.maxRetries, retryBaseDelay, op)
+	})
+}
+
+// relogin logs in again after session expired. Callers that saw the same
+// session expire wait for the first of them, which does the login.
+func (c *lazyClient) relogin(ctx context.Context, session int64) error {
+	select {
+	case c.sem <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-c.sem }()
+
+	if c.session.Load() != session {
+		return nil
+	}
+
+	ctx, span := startCall(ctx, "Login")
+	start := time.Now()
+	err := withRetry(ctx, c.maxRetries, retryBaseDelay, func() error {
+		return c.inner.Login(ctx, c.user, c.pass)
+	})
+	err = markUnreachable(c.redact(err))
+	c.logCall(span, "Login", start, err, "reason", "session expired")
+	if err != nil {
+		return err
+	}
+	c.session.Add(1)
+	return nil
+}
 
-		initErr = c.inner.SetBaseURL(c.baseURL)
-		if initErr != nil {
-			return
+// redact removes the controller password from err, including one embedded
+// in the user info of the base URL, since controller errors quote the
+// request URL and occasionally echo the request body.
+func (c *lazyClient) redact(err error) error {
+	secrets := []string{c.pass}
+	if u, perr := url.Parse(c.baseURL); perr == nil && u.User != nil {
+		if p, ok := u.User.Password(); ok {
+			secrets = append(secrets, p, url.PathEscape(p), url.QueryEscape(p))
 		}
+	}
+	return redact.Error(err, secrets...)
+}
+
+// tracer creates the client spans of controller API calls. It is a no-op
+// unless a tracer provider is installed.
+var tracer = otel.Tracer("github.com/ubiquiti-community/unifi-rpc/pkg/rpc")
+
+// startCall opens the client span of a controller API call, which logCall
+// ends.
+func startCall(ctx context.Context, op string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "unifi."+op, trace.WithSpanKind(trace.SpanKindClient))
+}
 
-		initErr = c.inner.Login(ctx, c.user, c.pass)
-		if initErr != nil {
-			return
+// logCall records a controller API call at debug level, or at warn level
+// when it failed, and ends its span, adding args as span attributes.
+func (c *lazyClient) logCall(span trace.Span, op string, start time.Time, err error, args ...any) {
+	for i := 0; i+1 < len(args); i += 2 {
+		if k, ok := args[i].(string); ok {
+			span.SetAttributes(attribute.String(k, fmt.Sprint(args[i+1])))
 		}
-		log.Printf("[TRACE] Unifi controller version: %q", c.inner.Version())
-	})
-	return initErr
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+
+	if c.logger == nil {
+		return
+	}
+	args = append(args, "op", op, "duration", time.Since(start))
+	if err != nil {
+		c.logger.Warn("controller call failed", append(args, "error", err)...)
+		return
+	}
+	c.logger.Debug("controller call", args...)
+}
+
+// Preflight logs in to the controller, which also detects the API path and
+// reads the controller version.
+func (c *lazyClient) Preflight(ctx context.Context) error {
+	return c.init(ctx)
 }
 
+// Version returns the controller version, or "" when the client could not
+// log in.
 func (c *lazyClient) Version() string {
 	if err := c.init(context.Background()); err != nil {
-		panic(fmt.Sprintf("client not initialized: %s", err))
+		return ""
 	}
 	return c.inner.Version()
 }
@@ -204,7 +449,16 @@ func (c *lazyClient) GetDeviceByMAC(ctx context.Context, site, mac string) (*uni
 	if err := c.init(ctx); err != nil {
 		return nil, err
 	}
-	return c.inner.GetDeviceByMAC(ctx, site, mac)
+	ctx, span := startCall(ctx, "GetDeviceByMAC")
+	start := time.Now()
+	var d *unifi.Device
+	err := c.call(ctx, func() (err error) {
+		d, err = c.inner.GetDeviceByMAC(ctx, site, mac)
+		return err
+	})
+	err = markUnreachable(c.redact(err))
+	c.logCall(span, "GetDeviceByMAC", start, err, "site", site, "mac", mac)
+	return d, err
 }
 
 func (c *lazyClient) CreateDevice(ctx context.Context, site string, d *unifi.Device) (*unifi.Device, error) {
@@ -218,7 +472,16 @@ func (c *lazyClient) UpdateDevice(ctx context.Context, site string, d *unifi.Dev
 	if err := c.init(ctx); err != nil {
 		return nil, err
 	}
-	return c.inner.UpdateDevice(ctx, site, d)
+	ctx, span := startCall(ctx, "UpdateDevice")
+	start := time.Now()
+	var updated *unifi.Device
+	err := c.call(ctx, func() (err error) {
+		updated, err = c.inner.UpdateDevice(ctx, site, d)
+		return err
+	})
+	err = markUnreachable(c.redact(err))
+	c.logCall(span, "UpdateDevice", start, err, "site", site, "mac", d.MAC)
+	return updated, err
 }
 
 func (c *lazyClient) DeleteDevice(ctx context.Context, site, id string) error {
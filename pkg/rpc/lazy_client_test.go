@@ -0,0 +1,474 @@
+package rpc
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/paultyng/go-unifi/unifi"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
+
+	"github.com/ubiquiti-community/unifi-rpc/pkg/config"
+)
+
+// newFakeController serves just enough of the UniFi OS login flow for the
+// lazyClient to reach the login call, answering it with handler. Further
+// paths are served by handlers.
+func newFakeController(t *testing.T, login http.HandlerFunc, handlers map[string]http.HandlerFunc) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	for path, h := range handlers {
+		mux.HandleFunc(path, h)
+	}
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/api/auth/login", login)
+	srv := httptest.NewTLSServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestLazyClient_LoginErrorIsRedacted(t *testing.T) {
+	const password = "correct-horse-battery-staple"
+
+	srv := newFakeController(t, func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Password string `json:"password"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `{"meta":{"rc":"error","msg":"invalid password %s"}}`, body.Password)
+	}, nil)
+
+	base := strings.Replace(srv.URL, "https://", "https://admin:"+password+"@", 1)
+	c := &lazyClient{baseURL: base, user: "admin", pass: password, insecure: true}
+
+	_, err := c.GetDeviceByMAC(context.Background(), "default", "aa:bb:cc:dd:ee:ff")
+	if err == nil {
+		t.Fatal("GetDeviceByMAC() succeeded against a rejecting controller")
+	}
+	for _, part := range []string{password, password[:10], password[10:]} {
+		if strings.Contains(err.Error(), part) {
+			t.Errorf("error %q contains %q from the password", err, part)
+		}
+	}
+}
+
+func TestLogCall_EndsSpan(t *testing.T) {
+	rec := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec)))
+	t.Cleanup(func() { otel.SetTracerProvider(noop.NewTracerProvider()) })
+
+	c := &lazyClient{}
+	_, span := startCall(context.Background(), "GetDeviceByMAC")
+	c.logCall(span, "GetDeviceByMAC", time.Now(), errors.New("boom"), "mac", "aa:bb:cc:dd:ee:ff")
+
+	ended := rec.Ended()
+	if len(ended) != 1 {
+		t.Fatalf("%d spans ended, want 1", len(ended))
+	}
+	s := ended[0]
+	if s.Name() != "unifi.GetDeviceByMAC" {
+		t.Errorf("span name = %q", s.Name())
+	}
+	if s.Status().Code != codes.Error {
+		t.Errorf("span status = %v, want %v", s.Status().Code, codes.Error)
+	}
+	if attrs := s.Attributes(); len(attrs) != 1 || attrs[0].Value.AsString() != "aa:bb:cc:dd:ee:ff" {
+		t.Errorf("span attributes = %v, want the mac", attrs)
+	}
+}
+
+func TestLazyClient_UnreachableController(t *testing.T) {
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	base := srv.URL
+	srv.Close()
+
+	c := &lazyClient{baseURL: base, insecure: true}
+	_, err := c.GetDeviceByMAC(context.Background(), "default", "aa:bb:cc:dd:ee:ff")
+	if !errors.Is(err, ErrControllerUnreachable) {
+		t.Errorf("GetDeviceByMAC() error = %v, want ErrControllerUnreachable", err)
+	}
+}
+
+func TestLazyClient_WaitingForLoginHonorsContext(t *testing.T) {
+	release := make(chan struct{})
+	srv := newFakeController(t, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+	}, nil)
+	defer close(release)
+
+	c := &lazyClient{baseURL: srv.URL, insecure: true}
+	loginCtx, cancelLogin := context.WithCancel(context.Background())
+	defer cancelLogin()
+	go func() { _ = c.init(loginCtx) }()
+
+	// Give the first caller time to take the login slot.
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := c.init(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("init() error = %v, want context.DeadlineExceeded", err)
+	}
+	if waited := time.Since(start); waited > time.Second {
+		t.Errorf("init() returned after %v, want it to give up at the deadline", waited)
+	}
+}
+
+func TestLazyClient_CallTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	srv := newFakeController(t, func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, `{"meta":{"rc":"ok"}}`)
+	}, map[string]http.HandlerFunc{
+		"/proxy/network/status": func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = io.WriteString(w, `{"meta":{"rc":"ok","server_version":"8.0.0"}}`)
+		},
+		// The controller accepts the call but never answers it.
+		"/proxy/network/api/s/default/stat/device/aa:bb:cc:dd:ee:ff": func(_ http.ResponseWriter, r *http.Request) {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+		},
+	})
+
+	c := &lazyClient{baseURL: srv.URL, insecure: true, callTimeout: 100 * time.Millisecond}
+	start := time.Now()
+	_, err := c.GetDeviceStats(context.Background(), "default", "aa:bb:cc:dd:ee:ff")
+	if err == nil {
+		t.Fatal("GetDeviceStats() succeeded against a controller that never answers")
+	}
+	if waited := time.Since(start); waited > 2*time.Second {
+		t.Errorf("GetDeviceStats() returned after %v, want it to give up after the call timeout", waited)
+	}
+}
+
+// sessionController is a fake UniFi OS controller that hands out a new
+// session cookie on each login and rejects requests with any other cookie.
+type sessionController struct {
+	srv     *httptest.Server
+	logins  atomic.Int32
+	session atomic.Int32
+	cycles  atomic.Int32
+}
+
+func newSessionController(t *testing.T, mac string) *sessionController {
+	t.Helper()
+	fc := &sessionController{}
+	withSession := func(h http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if c, err := r.Cookie("TOKEN"); err != nil || c.Value != fmt.Sprint(fc.session.Load()) {
+				w.WriteHeader(http.StatusUnauthorized)
+				_, _ = io.WriteString(w, `{"meta":{"rc":"error","msg":"api.err.LoginRequired"}}`)
+				return
+			}
+			h(w, r)
+		}
+	}
+	fc.srv = newFakeController(t, func(w http.ResponseWriter, _ *http.Request) {
+		n := fc.logins.Add(1)
+		fc.session.Store(n)
+		http.SetCookie(w, &http.Cookie{Name: "TOKEN", Value: fmt.Sprint(n), Path: "/"})
+		_, _ = io.WriteString(w, `{"meta":{"rc":"ok"}}`)
+	}, map[string]http.HandlerFunc{
+		"/proxy/network/status": withSession(func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = io.WriteString(w, `{"meta":{"rc":"ok","server_version":"8.0.0"}}`)
+		}),
+		"/proxy/network/api/s/default/stat/device/" + mac: withSession(func(w http.ResponseWriter, r *http.Request) {
+			http.ServeFile(w, r, "testdata/stat_device_usw.json")
+		}),
+		"/proxy/network/api/s/default/cmd/devmgr": withSession(func(w http.ResponseWriter, _ *http.Request) {
+			fc.cycles.Add(1)
+			_, _ = io.WriteString(w, `{"meta":{"rc":"ok"}}`)
+		}),
+	})
+	return fc
+}
+
+// expire invalidates the current session, as the controller does after
+// its session timeout.
+func (fc *sessionController) expire() {
+	fc.session.Store(0)
+}
+
+func TestLazyClient_LogsInOnFirstUse(t *testing.T) {
+	const mac = "aa:bb:cc:dd:ee:ff"
+	fc := newSessionController(t, mac)
+
+	cfg := config.Default()
+	cfg.APIEndpoint = fc.srv.URL
+	svc, err := NewBMCService(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := fc.logins.Load(); n != 0 {
+		t.Fatalf("%d logins before the first call, want 0", n)
+	}
+
+	c := svc.(*bmcService).client
+	for i := 0; i < 2; i++ {
+		if _, err := c.GetDeviceStats(context.Background(), "default", mac); err != nil {
+			t.Fatalf("GetDeviceStats() error = %v", err)
+		}
+	}
+	if n := fc.logins.Load(); n != 1 {
+		t.Errorf("%d logins, want 1", n)
+	}
+}
+
+func TestLazyClient_LogsInAgainWhenSessionExpires(t *testing.T) {
+	const mac = "aa:bb:cc:dd:ee:ff"
+	fc := newSessionController(t, mac)
+
+	c := &lazyClient{baseURL: fc.srv.URL, user: "admin", pass: "secret", insecure: true}
+	ctx := context.Background()
+	if _, err := c.GetDeviceStats(ctx, "default", mac); err != nil {
+		t.Fatalf("GetDeviceStats() error = %v", err)
+	}
+
+	fc.expire()
+	if _, err := c.GetDeviceStats(ctx, "default", mac); err != nil {
+		t.Fatalf("GetDeviceStats() after the session expired error = %v", err)
+	}
+	fc.expire()
+	if _, err := c.GetDeviceByMAC(ctx, "default", mac); err != nil {
+		t.Fatalf("GetDeviceByMAC() after the session expired error = %v", err)
+	}
+	fc.expire()
+	if err := c.PowerCyclePort(ctx, "default", mac, 1); err != nil {
+		t.Fatalf("PowerCyclePort() after the session expired error = %v", err)
+	}
+
+	if n := fc.logins.Load(); n != 4 {
+		t.Errorf("%d logins, want 4", n)
+	}
+	if n := fc.cycles.Load(); n != 1 {
+		t.Errorf("%d power cycles sent, want 1", n)
+	}
+}
+
+// recordingTransport records the path of every request it forwards, "/" for
+// requests to the bare base URL.
+type recordingTransport struct {
+	http.RoundTripper
+	mu    sync.Mutex
+	paths []string
+}
+
+func (t *recordingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	p := r.URL.Path
+	if p == "" {
+		p = "/"
+	}
+	t.mu.Lock()
+	t.paths = append(t.paths, p)
+	t.mu.Unlock()
+	return t.RoundTripper.RoundTrip(r)
+}
+
+func TestLazyClient_Transport(t *testing.T) {
+	const mac = "aa:bb:cc:dd:ee:ff"
+	fc := newSessionController(t, mac)
+	rt := &recordingTransport{RoundTripper: fc.srv.Client().Transport}
+
+	c := &lazyClient{baseURL: fc.srv.URL, transport: rt}
+	if _, err := c.GetDeviceStats(context.Background(), "default", mac); err != nil {
+		t.Fatalf("GetDeviceStats() error = %v", err)
+	}
+	want := []string{"/", "/", "/api/auth/login", "/proxy/network/status", "/proxy/network/api/s/default/stat/device/" + mac}
+	if !reflect.DeepEqual(rt.paths, want) {
+		t.Errorf("requests = %v, want %v", rt.paths, want)
+	}
+}
+
+func TestNewBMCService_CABundle(t *testing.T) {
+	const mac = "aa:bb:cc:dd:ee:ff"
+	fc := newSessionController(t, mac)
+	bundle := filepath.Join(t.TempDir(), "ca.pem")
+	pemCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: fc.srv.Certificate().Raw})
+	if err := os.WriteFile(bundle, pemCert, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	getStats := func(cfg config.Config) error {
+		t.Helper()
+		cfg.APIEndpoint = fc.srv.URL
+		svc, err := NewBMCService(cfg, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = svc.(*bmcService).client.GetDeviceStats(context.Background(), "default", mac)
+		return err
+	}
+
+	cfg := config.Default()
+	cfg.CABundle = bundle
+	if err := getStats(cfg); err != nil {
+		t.Errorf("GetDeviceStats() with the CA bundle error = %v", err)
+	}
+
+	secure := false
+	cfg = config.Default()
+	cfg.Insecure = &secure
+	var unknownCA x509.UnknownAuthorityError
+	if err := getStats(cfg); !errors.As(err, &unknownCA) {
+		t.Errorf("GetDeviceStats() verifying against the system roots error = %v, want %T", err, unknownCA)
+	}
+
+	cfg = config.Default()
+	cfg.CABundle = filepath.Join(t.TempDir(), "missing.pem")
+	if _, err := NewBMCService(cfg, nil); err == nil {
+		t.Error("NewBMCService() accepted a missing CA bundle")
+	}
+}
+
+// busyController is a fake controller that answers the first busy
+// requests to each of its routes with 429 or 503, as while rate limiting
+// or during a backup.
+type busyController struct {
+	srv   *httptest.Server
+	busy  int32
+	calls sync.Map // "METHOD path": *atomic.Int32
+}
+
+func newBusyController(t *testing.T, mac string, busy int32, retryAfter string) *busyController {
+	t.Helper()
+	fc := &busyController{busy: busy}
+	answer := func(status int, h http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			n, _ := fc.calls.LoadOrStore(r.Method+" "+r.URL.Path, new(atomic.Int32))
+			if n.(*atomic.Int32).Add(1) <= fc.busy {
+				if retryAfter != "" {
+					w.Header().Set("Retry-After", retryAfter)
+				}
+				w.WriteHeader(status)
+				_, _ = io.WriteString(w, `<html>busy</html>`)
+				return
+			}
+			h(w, r)
+		}
+	}
+	fc.srv = newFakeController(t, func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, `{"meta":{"rc":"ok"}}`)
+	}, map[string]http.HandlerFunc{
+		"/proxy/network/status": func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = io.WriteString(w, `{"meta":{"rc":"ok","server_version":"8.0.0"}}`)
+		},
+		"/proxy/network/api/s/default/stat/device/" + mac: answer(http.StatusTooManyRequests, func(w http.ResponseWriter, r *http.Request) {
+			http.ServeFile(w, r, "testdata/stat_device_usw.json")
+		}),
+		"/proxy/network/api/s/default/rest/device/switch": answer(http.StatusServiceUnavailable, func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.Copy(io.Discard, r.Body)
+			_, _ = fmt.Fprintf(w, `{"meta":{"rc":"ok"},"data":[{"_id":"switch","mac":%q}]}`, mac)
+		}),
+		"/proxy/network/api/s/default/cmd/devmgr": answer(http.StatusServiceUnavailable, func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = io.WriteString(w, `{"meta":{"rc":"ok"}}`)
+		}),
+	})
+	return fc
+}
+
+func (fc *busyController) count(method, path string) int32 {
+	n, ok := fc.calls.Load(method + " /proxy/network/api/s/default/" + path)
+	if !ok {
+		return 0
+	}
+	return n.(*atomic.Int32).Load()
+}
+
+func TestLazyClient_RetriesBusyController(t *testing.T) {
+	const mac = "aa:bb:cc:dd:ee:ff"
+	fc := newBusyController(t, mac, 1, "0")
+	c := &lazyClient{baseURL: fc.srv.URL, insecure: true, maxRetries: 2}
+	ctx := context.Background()
+
+	if _, err := c.GetDeviceStats(ctx, "default", mac); err != nil {
+		t.Fatalf("GetDeviceStats() error = %v", err)
+	}
+	if n := fc.count(http.MethodGet, "stat/device/"+mac); n != 2 {
+		t.Errorf("stat/device requested %d times, want 2", n)
+	}
+
+	d, err := c.UpdateDevice(ctx, "default", &unifi.Device{ID: "switch", MAC: mac})
+	if err != nil {
+		t.Fatalf("UpdateDevice() error = %v", err)
+	}
+	if d.MAC != mac {
+		t.Errorf("UpdateDevice() = %+v, want the device of the second answer", d)
+	}
+	if n := fc.count(http.MethodPut, "rest/device/switch"); n != 2 {
+		t.Errorf("rest/device updated %d times, want 2", n)
+	}
+
+	// A power cycle is not idempotent, so it is not sent again.
+	if err := c.PowerCyclePort(ctx, "default", mac, 1); err == nil {
+		t.Error("PowerCyclePort() succeeded, want the busy answer")
+	}
+	if n := fc.count(http.MethodPost, "cmd/devmgr"); n != 1 {
+		t.Errorf("cmd/devmgr posted %d times, want 1", n)
+	}
+}
+
+func TestLazyClient_RetriesExhausted(t *testing.T) {
+	const mac = "aa:bb:cc:dd:ee:ff"
+	fc := newBusyController(t, mac, 10, "0")
+	c := &lazyClient{baseURL: fc.srv.URL, insecure: true, maxRetries: 2}
+
+	_, err := c.GetDeviceStats(context.Background(), "default", mac)
+	if err == nil || !strings.Contains(err.Error(), "429 Too Many Requests") {
+		t.Fatalf("GetDeviceStats() error = %v, want the last 429 answer", err)
+	}
+	if n := fc.count(http.MethodGet, "stat/device/"+mac); n != 3 {
+		t.Errorf("stat/device requested %d times, want 3", n)
+	}
+}
+
+func TestLazyClient_RetryAfterPastDeadline(t *testing.T) {
+	const mac = "aa:bb:cc:dd:ee:ff"
+	fc := newBusyController(t, mac, 1, "20")
+	c := &lazyClient{baseURL: fc.srv.URL, insecure: true, maxRetries: 2}
+	if err := c.Preflight(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	start := time.Now()
+	if _, err := c.GetDeviceStats(ctx, "default", mac); err == nil {
+		t.Error("GetDeviceStats() succeeded, want the 429 answer")
+	}
+	if waited := time.Since(start); waited > time.Second {
+		t.Errorf("GetDeviceStats() returned after %v, want the answer as soon as Retry-After exceeds the deadline", waited)
+	}
+}
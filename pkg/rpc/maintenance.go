@@ -0,0 +1,70 @@
+package rpc
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync/atomic"
+)
+
+// ErrLocked is returned for power changes while the maintenance lock is
+// held.
+var ErrLocked = errors.New("power changes are locked for maintenance")
+
+// ErrReadOnly is returned for every change requested from a service
+// started read-only.
+var ErrReadOnly = errors.New("the service is read-only, changes are disabled")
+
+// maintenanceLock freezes the power state of every port and outlet, for
+// example during a maintenance window. It is kept in memory only, so a
+// restart releases it unless the server is started locked.
+type maintenanceLock struct {
+	locked atomic.Bool
+	// readOnly is set at startup and, unlike locked, cannot be lifted
+	// while the service runs.
+	readOnly bool
+}
+
+// check returns ErrReadOnly on a read-only service and ErrLocked while the
+// lock is held.
+func (l *maintenanceLock) check() error {
+	if l.readOnly {
+		return ErrReadOnly
+	}
+	if l.locked.Load() {
+		return ErrLocked
+	}
+	return nil
+}
+
+func (b *bmcService) SetLocked(locked bool) {
+	if b.maintenance.locked.Swap(locked) != locked {
+		b.logger.Warn("maintenance lock changed", "locked", locked)
+	}
+}
+
+// LockState is the body of the maintenance lock endpoint.
+type LockState struct {
+	Locked bool `json:"locked"`
+}
+
+// LockHandler answers GET with the LockState of the maintenance lock and
+// takes a LockState on POST to lock or unlock power changes. A read-only
+// service refuses POST.
+func (b *bmcService) LockHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		if b.maintenance.readOnly {
+			writeCallError(w, ResponsePayload{}, ErrReadOnly, ErrReadOnly.Error())
+			return
+		}
+		var req LockState
+		if err := b.decodeRequest(r, &req); err != nil {
+			writeRequestError(w, err)
+			return
+		}
+		b.SetLocked(req.Locked)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(LockState{Locked: b.maintenance.locked.Load()})
+}
@@ -0,0 +1,145 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRPCHandler_LockedRejectsSet(t *testing.T) {
+	fc := &fakeClient{device: newTestDevice("auto"), stats: loadDeviceStats(t, "stat_device_usw.json")}
+	svc := newTestService(fc, nil)
+	svc.SetLocked(true)
+
+	for _, body := range []string{
+		`{"id":1,"method":"setPowerState","params":{"state":"off"}}`,
+		`{"id":1,"method":"setPowerState","params":{"state":"cycle"}}`,
+		`{"id":1,"method":"setPowerStateBatch","params":{"ports":[{"port":1,"state":"off"}]}}`,
+		`{"id":1,"method":"powerCycleAll","params":{}}`,
+	} {
+		rec := serveRPC(context.Background(), t, svc, body)
+		if rec.Code != http.StatusLocked {
+			t.Errorf("%s: status = %d, want %d", body, rec.Code, http.StatusLocked)
+			continue
+		}
+		if got := responseReason(t, rec); got != ReasonLocked {
+			t.Errorf("%s: reason = %q, want %q", body, got, ReasonLocked)
+		}
+	}
+	if len(fc.updates) != 0 || len(fc.cycled) != 0 {
+		t.Errorf("locked service sent %d updates and cycled %v", len(fc.updates), fc.cycled)
+	}
+}
+
+func TestRPCHandler_LockedAllowsGet(t *testing.T) {
+	svc := newTestService(&fakeClient{device: newTestDevice("auto")}, nil)
+	svc.SetLocked(true)
+
+	for _, body := range []string{
+		`{"id":1,"method":"getPowerState"}`,
+		`{"id":1,"method":"ping"}`,
+	} {
+		if rec := serveRPC(context.Background(), t, svc, body); rec.Code != http.StatusOK {
+			t.Errorf("%s: status = %d, want %d: %s", body, rec.Code, http.StatusOK, rec.Body)
+		}
+	}
+}
+
+func TestLockHandler(t *testing.T) {
+	fc := &fakeClient{device: newTestDevice("auto")}
+	svc := newTestService(fc, nil)
+
+	lock := func(method, body string) LockState {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		svc.LockHandler(rec, httptest.NewRequest(method, "/admin/lock", strings.NewReader(body)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s /admin/lock: status = %d, want %d", method, rec.Code, http.StatusOK)
+		}
+		var st LockState
+		if err := json.Unmarshal(rec.Body.Bytes(), &st); err != nil {
+			t.Fatal(err)
+		}
+		return st
+	}
+
+	if st := lock(http.MethodPost, `{"locked":true}`); !st.Locked {
+		t.Fatal("POST locked=true did not lock")
+	}
+	// The views of the service bound to a controller share the lock.
+	view, err := svc.forHost("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := view.SetPortPower(context.Background(), "aa:bb:cc:dd:ee:ff", "1", PowerStateOff); !errors.Is(err, ErrLocked) {
+		t.Errorf("SetPortPower() while locked error = %v, want %v", err, ErrLocked)
+	}
+	if st := lock(http.MethodGet, ""); !st.Locked {
+		t.Error("GET reports unlocked while locked")
+	}
+
+	if st := lock(http.MethodPost, `{"locked":false}`); st.Locked {
+		t.Fatal("POST locked=false did not unlock")
+	}
+	if err := svc.SetPortPower(context.Background(), "aa:bb:cc:dd:ee:ff", "1", PowerStateOff); err != nil {
+		t.Errorf("SetPortPower() after unlocking error = %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	svc.LockHandler(rec, httptest.NewRequest(http.MethodPost, "/admin/lock", strings.NewReader(`{"locked":`)))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid body: status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestRPCHandler_ReadOnly(t *testing.T) {
+	fc := &fakeClient{device: newTestDevice("auto"), stats: loadDeviceStats(t, "stat_device_usw.json")}
+	svc := newTestService(fc, nil)
+	svc.maintenance = &maintenanceLock{readOnly: true}
+	// Releasing the maintenance lock does not lift read-only.
+	svc.SetLocked(false)
+
+	for _, body := range []string{
+		`{"id":1,"method":"setPowerState","params":{"state":"off"}}`,
+		`{"id":1,"method":"setPowerState","params":{"state":"reset"}}`,
+		`{"id":1,"method":"setPowerStateBatch","params":{"ports":[{"port":1,"state":"off"}]}}`,
+		`{"id":1,"method":"powerCycleAll","params":{}}`,
+		`{"id":1,"method":"setBootDevice","params":{"device":"pxe"}}`,
+	} {
+		rec := serveRPC(context.Background(), t, svc, body)
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("%s: status = %d, want %d", body, rec.Code, http.StatusForbidden)
+			continue
+		}
+		if got := responseReason(t, rec); got != ReasonReadOnly {
+			t.Errorf("%s: reason = %q, want %q", body, got, ReasonReadOnly)
+		}
+	}
+	if len(fc.updates) != 0 || len(fc.cycled) != 0 {
+		t.Errorf("read-only service sent %d updates and cycled %v", len(fc.updates), fc.cycled)
+	}
+	if p, ok := svc.bootDevices.Get(Machine{MacAddress: "aa:bb:cc:dd:ee:ff", PortIdx: "1"}); ok {
+		t.Errorf("read-only service stored boot device %+v", p)
+	}
+
+	for _, body := range []string{
+		`{"id":1,"method":"getPowerState"}`,
+		`{"id":1,"method":"getStatus"}`,
+		`{"id":1,"method":"getPoEStatus"}`,
+		`{"id":1,"method":"ping"}`,
+	} {
+		if rec := serveRPC(context.Background(), t, svc, body); rec.Code != http.StatusOK {
+			t.Errorf("%s: status = %d, want %d: %s", body, rec.Code, http.StatusOK, rec.Body)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	svc.LockHandler(rec, httptest.NewRequest(http.MethodPost, "/admin/lock", strings.NewReader(`{"locked":true}`)))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("POST /admin/lock: status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+}
@@ -0,0 +1,147 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/paultyng/go-unifi/unifi"
+)
+
+// setRelayState switches outlet idx of a PDU such as the USP-PDU-Pro. It
+// reports whether the device needs to be pushed back to the controller.
+func setRelayState(dev *unifi.Device, idx int, state string) (changed bool, err error) {
+	st, err := ParsePowerState(state)
+	if err != nil {
+		return false, err
+	}
+	if st != PoweredOn && st != PoweredOff {
+		return false, fmt.Errorf("%w: power state %q cannot be set", ErrInvalidState, state)
+	}
+	relay := st == PoweredOn
+
+	for i, o := range dev.OutletOverrides {
+		if o.Index == idx {
+			if o.RelayState == relay {
+				return false, nil
+			}
+			dev.OutletOverrides[i].RelayState = relay
+			return true, nil
+		}
+	}
+
+	return false, fmt.Errorf("%w: outlet %d not found on device %s", ErrPortNotFound, idx, dev.MAC)
+}
+
+func (b *bmcService) setOutletPower(ctx context.Context, macAddress string, outletIdx string, state string) error {
+	if err := b.maintenance.check(); err != nil {
+		return err
+	}
+	idx, err := strconv.Atoi(outletIdx)
+	if err != nil {
+		return fmt.Errorf("%w: error getting integer value from outlet %s: %w", ErrInvalidPort, outletIdx, err)
+	}
+
+	unlock, err := b.locks.lock(ctx, macAddress)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	dev, err := b.getDevice(ctx, macAddress)
+	if err != nil {
+		return fmt.Errorf("error getting device by MAC Address %s: %w", macAddress, err)
+	}
+
+	changed, err := setRelayState(dev, idx, state)
+	if err != nil || !changed {
+		return err
+	}
+
+	if err = b.updateDevice(ctx, dev); err != nil {
+		return fmt.Errorf("error updating device: %w", err)
+	}
+
+	return nil
+}
+
+func (b *bmcService) GetOutletPower(ctx context.Context, macAddress string, outletIdx string) (string, error) {
+	idx, err := strconv.Atoi(outletIdx)
+	if err != nil {
+		return "", fmt.Errorf("%w: error getting integer value from outlet %s: %w", ErrInvalidPort, outletIdx, err)
+	}
+
+	dev, err := b.getCachedDevice(ctx, macAddress)
+	if err != nil {
+		return "", fmt.Errorf("error getting device by MAC Address %s: %w", macAddress, err)
+	}
+
+	for _, o := range dev.OutletOverrides {
+		if o.Index == idx {
+			return devicePowerState(dev.State, relayState(o.RelayState)).String(), nil
+		}
+	}
+
+	return "", fmt.Errorf("%w: outlet %d not found on device %s", ErrPortNotFound, idx, dev.MAC)
+}
+
+// OutletRPCHandler serves the power methods for a PDU outlet addressed by
+// the {mac} and {outlet} route variables.
+func (b *bmcService) OutletRPCHandler(w http.ResponseWriter, r *http.Request) {
+	req, end, ok := b.beginRPC(w, r)
+	defer end()
+	if !ok {
+		return
+	}
+
+	vars := mux.Vars(r)
+	mac, outlet := vars["mac"], vars["outlet"]
+	logger := b.rpcLogger(r, req, Machine{MacAddress: mac}).With("outlet", outlet)
+
+	rp := ResponsePayload{
+		ID:   req.ID,
+		Host: req.Host,
+	}
+
+	b, hostErr := b.forHost(req.Host)
+	if hostErr != nil {
+		logger.Error("error selecting controller", "error", hostErr)
+		writeError(w, rp, http.StatusBadRequest, ReasonUnknownHost, hostErr.Error())
+		return
+	}
+
+	params, ok := b.methodParams(w, rp, logger, outletMethods, req)
+	if !ok {
+		return
+	}
+
+	switch req.Method {
+	case PowerGetMethod:
+		state, err := b.GetOutletPower(r.Context(), mac, outlet)
+		if err != nil {
+			failCall(w, rp, logger, err, fmt.Sprintf("error getting power state for MAC Address %s, Outlet Index %s: %v", mac, outlet, err))
+			return
+		}
+		rp.Result = state
+	case PowerSetMethod:
+		p := params.(*PowerSetParams)
+		if err := b.setOutletPower(r.Context(), mac, outlet, p.State); err != nil {
+			failCall(w, rp, logger, err, fmt.Sprintf("error setting power for MAC Address %s, Outlet Index %s: %v", mac, outlet, err))
+			return
+		}
+	case PingMethod:
+		res, err := b.ping(r.Context(), mac)
+		if err != nil {
+			failCall(w, rp, logger, err, fmt.Sprintf("error checking MAC Address %s: %v", mac, err))
+			return
+		}
+		rp.Result = res
+	default:
+		logger.Warn("unknown rpc method")
+		writeError(w, rp, http.StatusNotFound, ReasonUnknownMethod, fmt.Sprintf("unknown method %q", req.Method))
+		return
+	}
+	endRPC(w, logger, rp)
+}
@@ -0,0 +1,83 @@
+package rpc
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/paultyng/go-unifi/unifi"
+)
+
+func newTestPDU(relays ...bool) *unifi.Device {
+	d := &unifi.Device{ID: "pdu-id", MAC: "aa:bb:cc:00:00:01"}
+	for i, r := range relays {
+		d.OutletOverrides = append(d.OutletOverrides, unifi.DeviceOutletOverrides{Index: i + 1, RelayState: r})
+	}
+	return d
+}
+
+func TestSetOutletPower(t *testing.T) {
+	fc := &fakeClient{device: newTestPDU(true, false)}
+	svc := newTestService(fc, nil)
+	ctx := context.Background()
+
+	if err := svc.setOutletPower(ctx, "aa:bb:cc:00:00:01", "1", "off"); err != nil {
+		t.Fatal(err)
+	}
+	if fc.updated == nil || fc.updated.OutletOverrides[0].RelayState {
+		t.Fatal("outlet 1 relay was not switched off")
+	}
+
+	fc.device, fc.updated = fc.updated, nil
+	if err := svc.setOutletPower(ctx, "aa:bb:cc:00:00:01", "2", "on"); err != nil {
+		t.Fatal(err)
+	}
+	if fc.updated == nil || !fc.updated.OutletOverrides[1].RelayState {
+		t.Fatal("outlet 2 relay was not switched on")
+	}
+
+	fc.device = fc.updated
+	if state, err := svc.GetOutletPower(ctx, "aa:bb:cc:00:00:01", "2"); err != nil || state != "on" {
+		t.Errorf("GetOutletPower() = %q, %v, want on", state, err)
+	}
+}
+
+func TestSetOutletPower_Unchanged(t *testing.T) {
+	fc := &fakeClient{device: newTestPDU(true)}
+	svc := newTestService(fc, nil)
+
+	if err := svc.setOutletPower(context.Background(), "aa:bb:cc:00:00:01", "1", "on"); err != nil {
+		t.Fatal(err)
+	}
+	if fc.updated != nil {
+		t.Error("device was updated although the relay already matched")
+	}
+}
+
+func TestSetOutletPower_UnknownOutlet(t *testing.T) {
+	svc := newTestService(&fakeClient{device: newTestPDU(true)}, nil)
+
+	if err := svc.setOutletPower(context.Background(), "aa:bb:cc:00:00:01", "8", "off"); err == nil {
+		t.Error("setOutletPower() on a missing outlet did not fail")
+	}
+}
+
+func TestOutletRPCHandler_CountsRequests(t *testing.T) {
+	svc := newTestService(&fakeClient{device: newTestPDU(true)}, nil)
+	svc.stats = newRPCStats()
+
+	r := mux.NewRouter()
+	r.HandleFunc("/device/{mac}/outlet/{outlet}/rpc", svc.OutletRPCHandler).Methods("POST")
+	for _, body := range []string{`{"id":1,"method":"getPowerState"}`, `{"id":2,"method":"nope"}`} {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/device/aa:bb:cc:00:00:01/outlet/1/rpc", strings.NewReader(body)))
+	}
+
+	if st := svc.stats.snapshot(time.Now()); st.InFlight != 0 || st.Served != 2 {
+		t.Errorf("inFlight = %d, served = %d, want 0 and 2", st.InFlight, st.Served)
+	}
+}
@@ -1,15 +1,26 @@
 package rpc
 
-import "fmt"
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+)
 
 type Method string
 
 const (
-	BootDeviceMethod   Method = "setBootDevice"
-	PowerSetMethod     Method = "setPowerState"
-	PowerGetMethod     Method = "getPowerState"
-	VirtualMediaMethod Method = "setVirtualMedia"
-	PingMethod         Method = "ping"
+	BootDeviceMethod    Method = "setBootDevice"
+	BootDeviceGetMethod Method = "getBootDevice"
+	PowerSetMethod      Method = "setPowerState"
+	PowerSetBatchMethod Method = "setPowerStateBatch"
+	PowerGetMethod      Method = "getPowerState"
+	PoEStatusMethod     Method = "getPoEStatus"
+	PowerCycleAllMethod Method = "powerCycleAll"
+	StatusMethod        Method = "getStatus"
+	VirtualMediaMethod  Method = "setVirtualMedia"
+	PingMethod          Method = "ping"
+	SwitchInfoMethod    Method = "getSwitchInfo"
 )
 
 // RequestPayload is the payload sent to the ConsumerURL.
@@ -20,6 +31,21 @@ type RequestPayload struct {
 	Params any    `json:"params,omitempty"`
 }
 
+// decodeParams converts the generically decoded Params of a RequestPayload
+// into the concrete parameter type of a method. When strict is set params
+// carrying fields unknown to dst are rejected.
+func decodeParams(params any, dst any, strict bool) error {
+	b, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	if strict {
+		dec.DisallowUnknownFields()
+	}
+	return dec.Decode(dst)
+}
+
 // BootDeviceParams are the parameters options used when setting a boot device.
 type BootDeviceParams struct {
 	Device     string `json:"device"`
@@ -27,9 +53,95 @@ type BootDeviceParams struct {
 	EFIBoot    bool   `json:"efiBoot"`
 }
 
+// knownBootDevices are the boot devices accepted in BootDeviceParams.Device,
+// next to the empty device that clears the recorded one.
+var knownBootDevices = []string{"pxe", "disk", "bios", "cdrom"}
+
+func (p BootDeviceParams) validate() error {
+	if p.Device != "" && !slices.Contains(knownBootDevices, p.Device) {
+		return fmt.Errorf("unknown boot device %q, use one of %v", p.Device, knownBootDevices)
+	}
+	return nil
+}
+
+// BootDeviceResult is the result of setBootDevice. UniFi devices cannot
+// change the boot order of the machines they power, so the boot device is
+// only recorded for getBootDevice and Supported is always false.
+type BootDeviceResult struct {
+	Acknowledged bool   `json:"acknowledged"`
+	Device       string `json:"device"`
+	Persistent   bool   `json:"persistent"`
+	EFIBoot      bool   `json:"efiBoot"`
+	Supported    bool   `json:"supported"`
+	Message      string `json:"message"`
+}
+
+// Power states accepted in PowerSetParams.State.
+const (
+	// PowerStateOn enables PoE on the port (mode "auto") or closes the outlet relay.
+	PowerStateOn = "on"
+	// PowerStateOff disables PoE or opens the relay. This is a hard power cut.
+	PowerStateOff = "off"
+	// PowerStateSoft asks for an ACPI style graceful shutdown. PoE and PDU
+	// relays can only cut power, so this is rejected with ErrNotSupported
+	// instead of being silently treated as PowerStateOff.
+	PowerStateSoft = "soft"
+	// PowerStateReset is a cold restart of a port: off, a dwell of
+	// config.ResetDwell, then on again.
+	PowerStateReset = "reset"
+	// PowerStateCycle is the quick PoE bounce of the controller's power
+	// cycle command, which may be too short for some devices to fully
+	// reset.
+	PowerStateCycle = "cycle"
+	// PowerStateToggle turns a port off when it is on or powering on, and
+	// on when it is off or powering off. The current state is read under
+	// the same device lock as the change, so concurrent toggles do not
+	// cancel out unnoticed.
+	PowerStateToggle = "toggle"
+)
+
 // PowerSetParams are the parameters options used when setting the power state.
 type PowerSetParams struct {
 	State string `json:"state"`
+	// Force sends the change to the controller even when the port is
+	// already in State.
+	Force bool `json:"force,omitempty"`
+	// Port, when set, is the port to change instead of the one of the
+	// route or the default port. It is numbered like in the route and
+	// ignored by the outlet endpoint.
+	Port int `json:"port,omitempty"`
+}
+
+// PowerSetResult is the result of the setPowerState RPC method. Previous is the
+// state the port was in before the request, so callers can tell a no-op
+// from an actual change.
+type PowerSetResult struct {
+	Previous string `json:"previous"`
+	Current  string `json:"current"`
+	// WakeSent is set when a Wake-on-LAN magic packet was sent to the
+	// machine after powering its port on.
+	WakeSent bool `json:"wakeSent,omitempty"`
+}
+
+// PowerSetBatchParams are the parameters used when setting the power state
+// of several ports on the same device in one request.
+type PowerSetBatchParams struct {
+	Ports []PortPowerSetParams `json:"ports"`
+}
+
+// PortPowerSetParams is a single port entry of PowerSetBatchParams.
+type PortPowerSetParams struct {
+	Port  int    `json:"port"`
+	State string `json:"state"`
+}
+
+// PortPowerSetResult is the outcome for one port of a batch power request.
+type PortPowerSetResult struct {
+	Port  int    `json:"port"`
+	State string `json:"state"`
+	Error string `json:"error,omitempty"`
+	// WakeSent is set like PowerSetResult.WakeSent.
+	WakeSent bool `json:"wakeSent,omitempty"`
 }
 
 // PowerGetParams are the parameters options used when getting the power state.
@@ -47,11 +159,29 @@ type ResponsePayload struct {
 	Host   string         `json:"host"`
 	Result any            `json:"result,omitempty"`
 	Error  *ResponseError `json:"error,omitempty"`
+	// Port and PortAlias are set when the request named the port by its
+	// alias, giving the port number the alias resolved to.
+	Port      string `json:"port,omitempty"`
+	PortAlias string `json:"portAlias,omitempty"`
+	// Stale is set when Result is the last power state set through the
+	// service, answered because the controller is unreachable.
+	Stale bool `json:"stale,omitempty"`
 }
 
 type ResponseError struct {
 	Code    int    `json:"code"`
 	Message string `json:"message"`
+	// Reason classifies the error for clients, see the Reason constants.
+	Reason string `json:"reason,omitempty"`
+}
+
+// Error makes a ResponseError usable as the error of a failed call, as the
+// rpcclient package returns it.
+func (e *ResponseError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("rpc error %d: %s", e.Code, e.Message)
+	}
+	return fmt.Sprintf("rpc error %d (%s): %s", e.Code, e.Reason, e.Message)
 }
 
 type PowerGetResult string
@@ -59,6 +189,13 @@ type PowerGetResult string
 const (
 	PoweredOn  PowerGetResult = "on"
 	PoweredOff PowerGetResult = "off"
+	// PoweringOn and PoweringOff are reported while the controller is still
+	// provisioning a power change to the device.
+	PoweringOn  PowerGetResult = "powering on"
+	PoweringOff PowerGetResult = "powering off"
+	// NotPoE is reported for ports that cannot supply PoE at all, so they
+	// are not mistaken for ports that are switched off.
+	NotPoE PowerGetResult = "not-poe"
 )
 
 func (p PowerGetResult) String() string {
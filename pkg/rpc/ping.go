@@ -0,0 +1,48 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/paultyng/go-unifi/unifi"
+)
+
+// PingResult is the answer to ping when the service checks the switch.
+type PingResult struct {
+	Pong   bool   `json:"pong"`
+	Switch string `json:"switch"`
+	// ServerTime is the clock of the service when it answered, for clients
+	// to estimate their clock skew.
+	ServerTime time.Time `json:"serverTime"`
+	// SwitchRTTMs is how long reading the device from the controller took,
+	// in milliseconds. The controller answers from the state the switch
+	// last reported, so this is the latency of managing the switch rather
+	// than a probe of the switch itself.
+	SwitchRTTMs float64 `json:"switchRttMs"`
+}
+
+// ping answers the ping method with a static "pong", unless pingChecksSwitch
+// is set. The device is then read from the controller, bypassing the device
+// cache, so that a health check fails when the switch cannot be managed,
+// and the answer is a PingResult with the time taken.
+func (b *bmcService) ping(ctx context.Context, macAddress string) (any, error) {
+	if !b.pingChecksSwitch {
+		return "pong", nil
+	}
+	start := time.Now()
+	dev, err := b.client.GetDeviceByMAC(ctx, b.site, macAddress)
+	rtt := time.Since(start)
+	if err != nil {
+		return nil, fmt.Errorf("error getting device by MAC Address %s: %w", macAddress, err)
+	}
+	if dev.State == unifi.DeviceStateHeartbeatMissed {
+		return nil, fmt.Errorf("device %s missed its heartbeat to the controller", macAddress)
+	}
+	return PingResult{
+		Pong:        true,
+		Switch:      "reachable",
+		ServerTime:  time.Now().UTC(),
+		SwitchRTTMs: float64(rtt.Microseconds()) / 1000,
+	}, nil
+}
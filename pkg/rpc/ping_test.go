@@ -0,0 +1,105 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/paultyng/go-unifi/unifi"
+)
+
+func TestRPCHandler_Ping(t *testing.T) {
+	missed := newTestDevice("auto")
+	missed.State = unifi.DeviceStateHeartbeatMissed
+
+	tests := []struct {
+		name        string
+		checkSwitch bool
+		client      *fakeClient
+		wantStatus  int
+		want        any
+	}{
+		// The static pong does not touch the controller, so a failing one
+		// goes unnoticed.
+		{name: "static", client: &fakeClient{err: errors.New("switch is down")}, wantStatus: http.StatusOK, want: "pong"},
+		{name: "switch reachable", checkSwitch: true, client: &fakeClient{device: newTestDevice("auto")}, wantStatus: http.StatusOK, want: map[string]any{"pong": true, "switch": "reachable"}},
+		{name: "switch down", checkSwitch: true, client: &fakeClient{err: errors.New("switch is down")}, wantStatus: http.StatusBadGateway},
+		{name: "heartbeat missed", checkSwitch: true, client: &fakeClient{device: missed}, wantStatus: http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := newTestService(tt.client, nil)
+			svc.pingChecksSwitch = tt.checkSwitch
+
+			rec := serveRPC(context.Background(), t, svc, `{"id":1,"method":"ping"}`)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			var rp ResponsePayload
+			if err := json.Unmarshal(rec.Body.Bytes(), &rp); err != nil {
+				t.Fatal(err)
+			}
+			if tt.want == nil {
+				if rp.Error == nil {
+					t.Errorf("response %s has no error", rec.Body)
+				}
+				return
+			}
+			// Live answers carry timings as well, see TestRPCHandler_PingTiming.
+			if m, ok := rp.Result.(map[string]any); ok {
+				delete(m, "serverTime")
+				delete(m, "switchRttMs")
+			}
+			if !reflect.DeepEqual(rp.Result, tt.want) {
+				t.Errorf("result = %#v, want %#v", rp.Result, tt.want)
+			}
+		})
+	}
+}
+
+// slowClient takes delay to answer GetDeviceByMAC.
+type slowClient struct {
+	*fakeClient
+	delay time.Duration
+}
+
+func (c *slowClient) GetDeviceByMAC(ctx context.Context, site, mac string) (*unifi.Device, error) {
+	time.Sleep(c.delay)
+	return c.fakeClient.GetDeviceByMAC(ctx, site, mac)
+}
+
+func TestRPCHandler_PingTiming(t *testing.T) {
+	const delay = 20 * time.Millisecond
+	svc := newTestService(&slowClient{fakeClient: &fakeClient{device: newTestDevice("auto")}, delay: delay}, nil)
+
+	rec := serveRPC(context.Background(), t, svc, `{"id":1,"method":"ping"}`)
+	if want := `"result":"pong"`; !strings.Contains(rec.Body.String(), want) {
+		t.Errorf("static body = %s, want it to contain %s", rec.Body, want)
+	}
+
+	svc.pingChecksSwitch = true
+	before := time.Now().UTC()
+	rec = serveRPC(context.Background(), t, svc, `{"id":2,"method":"ping"}`)
+	after := time.Now().UTC()
+	var rp struct {
+		Result PingResult `json:"result"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &rp); err != nil {
+		t.Fatal(err)
+	}
+	res := rp.Result
+	if !res.Pong || res.Switch != "reachable" {
+		t.Errorf("result = %+v, want a pong from a reachable switch", res)
+	}
+	if res.ServerTime.Before(before) || res.ServerTime.After(after) {
+		t.Errorf("serverTime = %v, want it between %v and %v", res.ServerTime, before, after)
+	}
+	if limit := float64(after.Sub(before).Microseconds()) / 1000; res.SwitchRTTMs < float64(delay.Milliseconds()) || res.SwitchRTTMs > limit {
+		t.Errorf("switchRttMs = %v, want at least %v and at most %v", res.SwitchRTTMs, delay.Milliseconds(), limit)
+	}
+}
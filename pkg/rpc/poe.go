@@ -0,0 +1,411 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/paultyng/go-unifi/unifi"
+)
+
+// PoEPortStatus is the live PoE reading of a single switch port.
+type PoEPortStatus struct {
+	Port       int     `json:"port"`
+	Name       string  `json:"name,omitempty"`
+	Up         bool    `json:"up"`
+	PoE        bool    `json:"poe"`
+	Mode       string  `json:"mode,omitempty"`
+	Class      string  `json:"class,omitempty"`
+	PowerWatts float64 `json:"watts"`
+	Voltage    float64 `json:"voltage"`
+	CurrentMA  float64 `json:"currentMilliamps"`
+	// SpeedMbps is the negotiated link speed, 0 while the link is down.
+	SpeedMbps int `json:"speedMbps"`
+	// ClassNumber is the PoE class parsed from Class, -1 when unknown.
+	ClassNumber int `json:"classNumber"`
+	// Standard is the PoE standard implied by the class: 802.3af for
+	// classes 0 to 3, 802.3at for class 4 and 802.3bt (PoE++) for classes
+	// 5 to 8.
+	Standard string   `json:"standard,omitempty"`
+	Fault    PoEFault `json:"fault"`
+}
+
+// PoEFault tells whether the PoE of a port is failing. The controller only
+// reports whether a port has good power, not why it has not, so every
+// fault is PoEFaultUnknown.
+type PoEFault string
+
+const (
+	PoEFaultNone    PoEFault = "none"
+	PoEFaultUnknown PoEFault = "unknown"
+)
+
+// poeFault reports a fault for a port with PoE enabled and a powered device
+// classified on it, but without good power. Enabled ports without a powered
+// device, such as one with a self powered machine, have no class and are
+// not faulted.
+func poeFault(p portStat) PoEFault {
+	if p.PortPoE && p.PoEEnable && !p.PoEGood && parsePoEClass(string(p.PoEClass)) >= 0 {
+		return PoEFaultUnknown
+	}
+	return PoEFaultNone
+}
+
+// PowerTotalResult is the aggregate PoE draw of a switch.
+type PowerTotalResult struct {
+	TotalWatts float64 `json:"totalWatts"`
+	// BudgetWatts is the PoE power budget of the switch and RemainingWatts
+	// what is left of it after TotalWatts. Both are zero when the switch
+	// reports no budget.
+	BudgetWatts    float64         `json:"budgetWatts"`
+	RemainingWatts float64         `json:"remainingWatts"`
+	Ports          []PoEPortStatus `json:"ports"`
+	// Warnings describes the ports left out because the controller
+	// reported them in a form that did not decode. It is only filled in
+	// the lenient stats parse mode, the strict one fails instead.
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// PortPowerStatus is the power state of a single port as listed by
+// PortsHandler.
+type PortPowerStatus struct {
+	Port  int     `json:"port"`
+	State string  `json:"state,omitempty"`
+	Watts float64 `json:"watts"`
+}
+
+// parsePoEClass extracts the class number from a controller PoE class such
+// as "Class 6" or "6", returning -1 for "Unknown" and anything else without
+// a class from 0 to 8.
+func parsePoEClass(class string) int {
+	n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(class), "Class")))
+	if err != nil || n < 0 || n > 8 {
+		return -1
+	}
+	return n
+}
+
+// poeStandard returns the PoE standard defining class, or the empty string
+// for an unknown class.
+func poeStandard(class int) string {
+	switch {
+	case class < 0:
+		return ""
+	case class <= 3:
+		return "802.3af"
+	case class == 4:
+		return "802.3at"
+	default:
+		return "802.3bt"
+	}
+}
+
+func newPoEPortStatus(p portStat) PoEPortStatus {
+	class := parsePoEClass(string(p.PoEClass))
+	return PoEPortStatus{
+		Port:        p.PortIdx,
+		Name:        p.Name,
+		Up:          p.Up,
+		SpeedMbps:   p.Speed,
+		PoE:         p.PortPoE,
+		Mode:        p.PoEMode,
+		Class:       string(p.PoEClass),
+		ClassNumber: class,
+		Standard:    poeStandard(class),
+		PowerWatts:  float64(p.PoEPower),
+		Voltage:     float64(p.PoEVoltage),
+		CurrentMA:   float64(p.PoECurrent),
+		Fault:       poeFault(p),
+	}
+}
+
+// GetAllPoEStatus returns the live PoE status of every port on the device
+// from a single controller request.
+func (b *bmcService) GetAllPoEStatus(ctx context.Context, macAddress string) ([]PoEPortStatus, error) {
+	res, err := b.getPoEStatus(ctx, macAddress)
+	if err != nil {
+		return nil, err
+	}
+	return res.Ports, nil
+}
+
+// livePorts is GetAllPoEStatus together with the state of the device,
+// which the power state of its ports depends on while it is provisioning.
+func (b *bmcService) livePorts(ctx context.Context, macAddress string) (unifi.DeviceState, []PoEPortStatus, error) {
+	if err := checkMAC(macAddress); err != nil {
+		return unifi.DeviceStateUnknown, nil, err
+	}
+	stats, err := b.deviceStats(ctx, macAddress, b.statsParse)
+	if err != nil {
+		return unifi.DeviceStateUnknown, nil, err
+	}
+	return stats.State, totalPower(stats).Ports, nil
+}
+
+// GetPoEStatusForPorts returns the live PoE status of the given ports of the
+// device, keyed by port, from the same single controller request as
+// GetAllPoEStatus. Ports are numbered as on the switch. A port that the
+// device does not have fails the whole call.
+func (b *bmcService) GetPoEStatusForPorts(ctx context.Context, macAddress string, ports []int) (map[int]PoEPortStatus, error) {
+	all, err := b.GetAllPoEStatus(ctx, macAddress)
+	if err != nil {
+		return nil, err
+	}
+	return filterPorts(all, ports)
+}
+
+func filterPorts(all []PoEPortStatus, ports []int) (map[int]PoEPortStatus, error) {
+	byPort := make(map[int]PoEPortStatus, len(all))
+	last := 0
+	for _, p := range all {
+		byPort[p.Port] = p
+		last = max(last, p.Port)
+	}
+	res := make(map[int]PoEPortStatus, len(ports))
+	for _, p := range ports {
+		st, ok := byPort[p]
+		if !ok {
+			return nil, fmt.Errorf("%w: port %d is out of range, device has %d ports", ErrPortNotFound, p, last)
+		}
+		res[p] = st
+	}
+	return res, nil
+}
+
+// selectPorts narrows all to the ports named by list, in the order of all.
+func selectPorts(all []PoEPortStatus, list string) ([]PoEPortStatus, error) {
+	ports, err := parsePortList(list)
+	if err != nil {
+		return nil, err
+	}
+	byPort, err := filterPorts(all, ports)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]PoEPortStatus, 0, len(byPort))
+	for _, p := range all {
+		if _, ok := byPort[p.Port]; ok {
+			res = append(res, p)
+		}
+	}
+	return res, nil
+}
+
+// parsePortList parses a comma separated list of ports and port ranges
+// such as "1-8,12" into the ports it names, in order and without
+// duplicates.
+func parsePortList(s string) ([]int, error) {
+	var ports []int
+	seen := map[int]bool{}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		from, to, isRange := strings.Cut(part, "-")
+		first, err := parsePortIdx(strings.TrimSpace(from))
+		if err != nil {
+			return nil, err
+		}
+		last := first
+		if isRange {
+			if last, err = parsePortIdx(strings.TrimSpace(to)); err != nil {
+				return nil, err
+			}
+			if last < first {
+				return nil, fmt.Errorf("%w: port range %s is reversed", ErrInvalidPort, part)
+			}
+		}
+		for p := first; p <= last; p++ {
+			if !seen[p] {
+				seen[p] = true
+				ports = append(ports, p)
+			}
+		}
+	}
+	return ports, nil
+}
+
+// PortStatus is the result of the getStatus RPC method: the power state of
+// a port together with its live PoE readings.
+type PortStatus struct {
+	State     string  `json:"state"`
+	Watts     float64 `json:"watts"`
+	Voltage   float64 `json:"voltage"`
+	CurrentMA float64 `json:"currentMilliamps"`
+	Class     string  `json:"class,omitempty"`
+	LinkUp    bool    `json:"linkUp"`
+	// Fault is set when the port has PoE enabled but no good power, see
+	// poeFault.
+	Fault PoEFault `json:"fault"`
+}
+
+// getPortStatus returns the status of a single port. The power state is
+// derived from the PoE mode in the stats, so a single controller request
+// serves both.
+func (b *bmcService) getPortStatus(ctx context.Context, macAddress string, portIdx string) (PortStatus, error) {
+	p, err := b.portIdx(portIdx)
+	if err != nil {
+		return PortStatus{}, err
+	}
+	if err = checkMAC(macAddress); err != nil {
+		return PortStatus{}, err
+	}
+	stats, err := b.deviceStats(ctx, macAddress, b.statsParse)
+	if err != nil {
+		return PortStatus{}, err
+	}
+
+	ps, err := findPortStat(stats, p)
+	if err != nil {
+		return PortStatus{}, err
+	}
+	return PortStatus{
+		State:     b.livePortState(stats.State, ps.PortIdx, ps.PortPoE, ps.PoEMode).String(),
+		Watts:     float64(ps.PoEPower),
+		Voltage:   float64(ps.PoEVoltage),
+		CurrentMA: float64(ps.PoECurrent),
+		Class:     string(ps.PoEClass),
+		LinkUp:    ps.Up,
+		Fault:     poeFault(ps),
+	}, nil
+}
+
+// findPortStat returns the port table row of port p.
+func findPortStat(stats *deviceStats, p int) (portStat, error) {
+	ports := 0
+	for _, ps := range stats.PortTable {
+		if ps.PortIdx == p {
+			return ps, nil
+		}
+		ports = max(ports, ps.PortIdx)
+	}
+	return portStat{}, fmt.Errorf("%w: port %d is out of range, device %s has %d ports", ErrPortNotFound, p, stats.MAC, ports)
+}
+
+// getPoEStatus returns the PoE status of the device and its ports from a
+// single controller request. It backs the getPoEStatus RPC method, which
+// reports the whole switch since the PoE budget is shared by every port.
+func (b *bmcService) getPoEStatus(ctx context.Context, macAddress string) (PowerTotalResult, error) {
+	return b.poeStatus(ctx, macAddress, b.statsParse)
+}
+
+func (b *bmcService) poeStatus(ctx context.Context, macAddress string, mode statsParseMode) (PowerTotalResult, error) {
+	if err := checkMAC(macAddress); err != nil {
+		return PowerTotalResult{}, err
+	}
+	stats, err := b.deviceStats(ctx, macAddress, mode)
+	if err != nil {
+		return PowerTotalResult{}, err
+	}
+	return totalPower(stats), nil
+}
+
+// deviceStats reads the stats of the device, failing in the strict mode
+// when any port table row did not decode and logging the skipped rows in
+// the lenient one.
+func (b *bmcService) deviceStats(ctx context.Context, macAddress string, mode statsParseMode) (*deviceStats, error) {
+	stats, err := b.client.GetDeviceStats(ctx, b.site, macAddress)
+	if err != nil {
+		return nil, fmt.Errorf("error getting device stats by MAC Address %s: %w", macAddress, err)
+	}
+	if len(stats.SkippedRows) == 0 {
+		return stats, nil
+	}
+	if mode == parseStrict {
+		return nil, fmt.Errorf("error decoding device stats of %s: %d port table rows are malformed: %s", macAddress, len(stats.SkippedRows), strings.Join(stats.SkippedRows, "; "))
+	}
+	b.logger.Warn("skipped malformed port table rows", "mac", macAddress, "rows", stats.SkippedRows)
+	return stats, nil
+}
+
+func totalPower(stats *deviceStats) PowerTotalResult {
+	res := PowerTotalResult{
+		BudgetWatts: float64(stats.TotalMaxPower),
+		Ports:       make([]PoEPortStatus, 0, len(stats.PortTable)),
+	}
+	for _, p := range stats.PortTable {
+		st := newPoEPortStatus(p)
+		res.Ports = append(res.Ports, st)
+		res.TotalWatts += st.PowerWatts
+	}
+	if res.BudgetWatts > 0 {
+		res.RemainingWatts = res.BudgetWatts - res.TotalWatts
+	}
+	res.Warnings = stats.SkippedRows
+	return res
+}
+
+// PowerTotalHandler reports the total PoE draw of the device addressed by
+// the {mac} route variable together with the per port breakdown. The
+// optional host query parameter selects the controller like the host field
+// of an RPC request does, and the optional parse query parameter, strict or
+// lenient, overrides the configured stats parse mode.
+func (b *bmcService) PowerTotalHandler(w http.ResponseWriter, r *http.Request) {
+	mac := mux.Vars(r)["mac"]
+	logger := b.requestLogger(r).With("mac", mac)
+
+	svc, err := b.forHost(r.URL.Query().Get("host"))
+	if err != nil {
+		logger.Error("error selecting controller", "error", err)
+		writeError(w, ResponsePayload{}, http.StatusBadRequest, ReasonUnknownHost, err.Error())
+		return
+	}
+
+	mode, err := parseStatsParseMode(r.URL.Query().Get("parse"), svc.statsParse)
+	if err != nil {
+		logger.Error("error parsing parse mode", "error", err)
+		writeError(w, ResponsePayload{}, http.StatusBadRequest, ReasonInvalidParams, err.Error())
+		return
+	}
+
+	res, err := svc.poeStatus(r.Context(), mac, mode)
+	if err != nil {
+		logger.Error("error getting PoE status", "error", err)
+		writeCallError(w, ResponsePayload{}, err, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(res)
+}
+
+// PortsHandler lists every port of the device addressed by the {mac} route
+// variable with its power state, from a single controller request. The
+// optional ports query parameter, such as ports=1-8,12, narrows the list to
+// those ports. The host query parameter is handled like in
+// PowerTotalHandler.
+func (b *bmcService) PortsHandler(w http.ResponseWriter, r *http.Request) {
+	mac := mux.Vars(r)["mac"]
+	logger := b.requestLogger(r).With("mac", mac)
+
+	svc, err := b.forHost(r.URL.Query().Get("host"))
+	if err != nil {
+		logger.Error("error selecting controller", "error", err)
+		writeError(w, ResponsePayload{}, http.StatusBadRequest, ReasonUnknownHost, err.Error())
+		return
+	}
+
+	dev, ports, err := svc.livePorts(r.Context(), mac)
+	if err == nil && r.URL.Query().Has("ports") {
+		ports, err = selectPorts(ports, r.URL.Query().Get("ports"))
+	}
+	if err != nil {
+		logger.Error("error getting PoE status", "error", err)
+		writeCallError(w, ResponsePayload{}, err, err.Error())
+		return
+	}
+
+	res := make([]PortPowerStatus, 0, len(ports))
+	for _, p := range ports {
+		res = append(res, PortPowerStatus{
+			Port:  p.Port,
+			State: svc.livePortState(dev, p.Port, p.PoE, p.Mode).String(),
+			Watts: p.PowerWatts,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(res)
+}
@@ -0,0 +1,462 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/paultyng/go-unifi/unifi"
+)
+
+func loadDeviceStats(t *testing.T, name string) *deviceStats {
+	t.Helper()
+	f, err := os.Open("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	stats, err := decodeDeviceStats(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stats
+}
+
+func TestDecodeDeviceStats(t *testing.T) {
+	stats := loadDeviceStats(t, "stat_device_usw.json")
+
+	if got := len(stats.PortTable); got != 5 {
+		t.Fatalf("parsed %d ports, want 5", got)
+	}
+	if got := float64(stats.PortTable[0].PoEPower); got != 5.43 {
+		t.Errorf("port 1 power = %v, want 5.43", got)
+	}
+	if got := float64(stats.PortTable[3].PoEPower); got != 3.2 {
+		t.Errorf("port 4 numeric power = %v, want 3.2", got)
+	}
+}
+
+func TestDecodeDeviceStats_PoEPlusPlus(t *testing.T) {
+	stats := loadDeviceStats(t, "stat_device_usw_pro_max.json")
+
+	want := []struct {
+		class    int
+		standard string
+		watts    float64
+	}{
+		{class: 6, standard: "802.3bt", watts: 51.2},
+		{class: 5, standard: "802.3bt", watts: 38.5},
+		{class: 4, standard: "802.3at", watts: 12.8},
+		{class: -1},
+	}
+	if len(stats.PortTable) != len(want) {
+		t.Fatalf("parsed %d ports, want %d", len(stats.PortTable), len(want))
+	}
+	for i, w := range want {
+		got := newPoEPortStatus(stats.PortTable[i])
+		if got.ClassNumber != w.class || got.Standard != w.standard || got.PowerWatts != w.watts {
+			t.Errorf("port %d = class %d %q %vW, want class %d %q %vW",
+				got.Port, got.ClassNumber, got.Standard, got.PowerWatts, w.class, w.standard, w.watts)
+		}
+	}
+}
+
+func TestDecodeDeviceStats_MixedPoE(t *testing.T) {
+	stats := loadDeviceStats(t, "stat_device_usw_mixed.json")
+	svc := newTestService(&fakeClient{}, nil)
+
+	want := []PowerGetResult{PoweredOn, PoweredOff, NotPoE, NotPoE}
+	if len(stats.PortTable) != len(want) {
+		t.Fatalf("parsed %d ports, want %d", len(stats.PortTable), len(want))
+	}
+	for i, w := range want {
+		p := stats.PortTable[i]
+		if got := svc.portPowerState(p.PortIdx, p.PortPoE, p.PoEMode); got != w {
+			t.Errorf("port %d state = %q, want %q", p.PortIdx, got, w)
+		}
+		if !p.PortPoE && (p.PoEPower != 0 || p.PoEVoltage != 0 || p.PoECurrent != 0) {
+			t.Errorf("port %d without PoE has readings %v W, %v V, %v mA, want none", p.PortIdx, p.PoEPower, p.PoEVoltage, p.PoECurrent)
+		}
+	}
+	if got := totalPower(stats).TotalWatts; got != 7.91 {
+		t.Errorf("total = %v, want 7.91", got)
+	}
+}
+
+func TestGetPortStatus_NotPoE(t *testing.T) {
+	svc := newTestService(&fakeClient{stats: loadDeviceStats(t, "stat_device_usw_mixed.json")}, nil)
+
+	st, err := svc.getPortStatus(context.Background(), "aa:bb:cc:dd:ee:ff", "25")
+	if err != nil {
+		t.Fatalf("getPortStatus() error = %v", err)
+	}
+	if st.State != string(NotPoE) || !st.LinkUp {
+		t.Errorf("status = %+v, want state %q with the link up", st, NotPoE)
+	}
+}
+
+func TestGetPower_MixedPoE(t *testing.T) {
+	stats := loadDeviceStats(t, "stat_device_usw_mixed.json")
+	svc := newTestService(&fakeClient{stats: stats}, nil)
+
+	want := []PowerGetResult{PoweredOn, PoweredOff, NotPoE, NotPoE}
+	for i, w := range want {
+		port := strconv.Itoa(stats.PortTable[i].PortIdx)
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/device/aa:bb:cc:dd:ee:ff/port/"+port+"/rpc", strings.NewReader(`{"id":1,"method":"getPowerState"}`))
+		req = mux.SetURLVars(req, map[string]string{"mac": "aa:bb:cc:dd:ee:ff", "port": port})
+		svc.RPCHandler(rec, req)
+
+		var res ResponsePayload
+		if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+			t.Fatalf("port %s: %v: %s", port, err, rec.Body)
+		}
+		if res.Result != string(w) {
+			t.Errorf("port %s getPowerState = %v, want %q", port, res.Result, w)
+		}
+	}
+}
+
+func TestResponseError(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{name: "controller message", body: `{"meta":{"rc":"error","msg":"api.err.LoginRequired"}}`, want: ": api.err.LoginRequired"},
+		{name: "plain body", body: "  bad gateway\n", want: ": bad gateway"},
+		{name: "truncated body", body: strings.Repeat("x", 2*maxErrorBody), want: ": " + strings.Repeat("x", maxErrorBody)},
+		{name: "empty body", body: "", want: "for GET https://unifi/stat"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{Status: "401 Unauthorized", Body: io.NopCloser(strings.NewReader(tt.body))}
+			err := responseError(resp, "GET https://unifi/stat")
+			if !strings.HasSuffix(err.Error(), tt.want) {
+				t.Errorf("error = %q, want suffix %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestPowerTotalHandler(t *testing.T) {
+	fc := &fakeClient{stats: loadDeviceStats(t, "stat_device_usw.json")}
+	svc := newTestService(fc, nil)
+
+	r := mux.NewRouter()
+	r.HandleFunc("/device/{mac}/power/total", svc.PowerTotalHandler)
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/device/aa:bb:cc:dd:ee:ff/power/total", http.NoBody))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	var res PowerTotalResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if want := 5.43 + 6.12 + 3.2; math.Abs(res.TotalWatts-want) > 1e-9 {
+		t.Errorf("total = %v, want %v", res.TotalWatts, want)
+	}
+	if len(res.Ports) != 5 {
+		t.Errorf("breakdown has %d ports, want 5", len(res.Ports))
+	}
+	if want := 45 - (5.43 + 6.12 + 3.2); res.BudgetWatts != 45 || math.Abs(res.RemainingWatts-want) > 1e-9 {
+		t.Errorf("budget = %v, remaining = %v, want 45 and %v", res.BudgetWatts, res.RemainingWatts, want)
+	}
+}
+
+func TestRPCHandler_PoEStatus(t *testing.T) {
+	svc := newTestService(&fakeClient{stats: loadDeviceStats(t, "stat_device_usw_pro_max.json")}, nil)
+
+	rec := serveRPC(context.Background(), t, svc, `{"id":1,"method":"getPoEStatus"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	var rp struct {
+		Result PowerTotalResult `json:"result"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &rp); err != nil {
+		t.Fatal(err)
+	}
+	res := rp.Result
+	if want := 400 - (51.2 + 38.5 + 12.8); math.Abs(res.RemainingWatts-want) > 1e-9 {
+		t.Errorf("remaining = %v, want %v", res.RemainingWatts, want)
+	}
+	var speeds []int
+	for _, p := range res.Ports {
+		speeds = append(speeds, p.SpeedMbps)
+	}
+	if want := []int{2500, 2500, 1000, 0}; !reflect.DeepEqual(speeds, want) {
+		t.Errorf("link speeds = %v, want %v", speeds, want)
+	}
+}
+
+func TestRPCHandler_Status(t *testing.T) {
+	cc := &countingClient{fakeClient: fakeClient{stats: loadDeviceStats(t, "stat_device_usw.json")}}
+	svc := newTestService(cc, nil)
+
+	rec := serveRPC(context.Background(), t, svc, `{"id":1,"method":"getStatus"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	var rp struct {
+		Result PortStatus `json:"result"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &rp); err != nil {
+		t.Fatal(err)
+	}
+	want := PortStatus{State: "on", Watts: 5.43, Voltage: 53.1, CurrentMA: 102.26, Class: "Class 4", LinkUp: true}
+	if got := rp.Result; math.Abs(got.Watts-want.Watts) > 1e-6 || math.Abs(got.Voltage-want.Voltage) > 1e-6 ||
+		math.Abs(got.CurrentMA-want.CurrentMA) > 1e-6 || got.State != want.State || got.Class != want.Class || got.LinkUp != want.LinkUp {
+		t.Errorf("status = %+v, want %+v", got, want)
+	}
+	if cc.gets != 0 {
+		t.Errorf("getStatus fetched the device %d times, want only the stats", cc.gets)
+	}
+}
+
+func TestPortsHandler(t *testing.T) {
+	fc := &fakeClient{stats: loadDeviceStats(t, "stat_device_usw.json")}
+	svc := newTestService(fc, nil)
+
+	r := mux.NewRouter()
+	r.HandleFunc("/device/{mac}/ports", svc.PortsHandler)
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/device/aa:bb:cc:dd:ee:ff/ports", http.NoBody))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	var res []PortPowerStatus
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	want := []PortPowerStatus{
+		{Port: 1, State: PowerStateOn, Watts: 5.43},
+		{Port: 2, State: PowerStateOn, Watts: 6.12},
+		{Port: 3, State: PowerStateOff},
+		{Port: 4, State: PowerStateOn, Watts: 3.2},
+		{Port: 17, State: string(NotPoE)},
+	}
+	if !reflect.DeepEqual(res, want) {
+		t.Errorf("ports = %+v, want %+v", res, want)
+	}
+}
+
+func TestPortsHandler_Provisioning(t *testing.T) {
+	stats := loadDeviceStats(t, "stat_device_usw_mixed.json")
+	stats.State = unifi.DeviceStateProvisioning
+	svc := newTestService(&fakeClient{stats: stats}, nil)
+
+	r := mux.NewRouter()
+	r.HandleFunc("/device/{mac}/ports", svc.PortsHandler)
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/device/aa:bb:cc:dd:ee:ff/ports", http.NoBody))
+
+	var res []PortPowerStatus
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatalf("%v: %s", err, rec.Body)
+	}
+	want := []PowerGetResult{PoweringOn, PoweringOff, NotPoE, NotPoE}
+	if len(res) != len(want) {
+		t.Fatalf("ports = %+v, want %d", res, len(want))
+	}
+	for i, w := range want {
+		if res[i].State != string(w) {
+			t.Errorf("port %d state = %q, want %q", res[i].Port, res[i].State, w)
+		}
+	}
+}
+
+func TestGetAllPoEStatus_Error(t *testing.T) {
+	svc := newTestService(&fakeClient{err: context.DeadlineExceeded}, nil)
+	if _, err := svc.GetAllPoEStatus(context.Background(), "aa:bb:cc:dd:ee:ff"); err == nil {
+		t.Error("GetAllPoEStatus() did not return the client error")
+	}
+}
+
+func TestGetPoEStatusForPorts(t *testing.T) {
+	fc := &fakeClient{stats: loadDeviceStats(t, "stat_device_usw.json")}
+	svc := newTestService(fc, nil)
+
+	res, err := svc.GetPoEStatusForPorts(context.Background(), "aa:bb:cc:dd:ee:ff", []int{2, 4})
+	if err != nil {
+		t.Fatalf("GetPoEStatusForPorts() error = %v", err)
+	}
+	if len(res) != 2 || res[2].PowerWatts != 6.12 || res[4].PowerWatts != 3.2 {
+		t.Errorf("GetPoEStatusForPorts() = %+v, want ports 2 and 4", res)
+	}
+
+	if _, err = svc.GetPoEStatusForPorts(context.Background(), "aa:bb:cc:dd:ee:ff", []int{2, 9}); !errors.Is(err, ErrPortNotFound) {
+		t.Errorf("GetPoEStatusForPorts() with a missing port error = %v, want %v", err, ErrPortNotFound)
+	}
+}
+
+func TestParsePortList(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []int
+		wantErr bool
+	}{
+		{in: "3", want: []int{3}},
+		{in: "1-4,12", want: []int{1, 2, 3, 4, 12}},
+		{in: " 2 , 1-3 ", want: []int{2, 1, 3}},
+		{in: "4-2", wantErr: true},
+		{in: "1,,2", wantErr: true},
+		{in: "0-2", wantErr: true},
+		{in: "a-b", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parsePortList(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parsePortList(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if err != nil && !errors.Is(err, ErrInvalidPort) {
+			t.Errorf("parsePortList(%q) error = %v, want %v", tt.in, err, ErrInvalidPort)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parsePortList(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestPortsHandler_Subset(t *testing.T) {
+	fc := &fakeClient{stats: loadDeviceStats(t, "stat_device_usw.json")}
+	svc := newTestService(fc, nil)
+
+	r := mux.NewRouter()
+	r.HandleFunc("/device/{mac}/ports", svc.PortsHandler)
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/device/aa:bb:cc:dd:ee:ff/ports?ports=17,2-3", http.NoBody))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	var res []PortPowerStatus
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	want := []PortPowerStatus{
+		{Port: 2, State: PowerStateOn, Watts: 6.12},
+		{Port: 3, State: PowerStateOff},
+		{Port: 17, State: string(NotPoE)},
+	}
+	if !reflect.DeepEqual(res, want) {
+		t.Errorf("ports = %+v, want %+v", res, want)
+	}
+
+	for query, code := range map[string]int{"ports=2-x": http.StatusBadRequest, "ports=5": http.StatusNotFound} {
+		rec = httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/device/aa:bb:cc:dd:ee:ff/ports?"+query, http.NoBody))
+		if rec.Code != code {
+			t.Errorf("%s: status = %d, want %d", query, rec.Code, code)
+		}
+	}
+}
+
+func TestDecodeDeviceStats_MalformedRows(t *testing.T) {
+	stats := loadDeviceStats(t, "stat_device_usw_malformed.json")
+	if len(stats.PortTable) != 2 || stats.PortTable[0].PortIdx != 1 || stats.PortTable[1].PortIdx != 3 {
+		t.Errorf("port table = %+v, want ports 1 and 3", stats.PortTable)
+	}
+	if len(stats.SkippedRows) != 2 || !strings.HasPrefix(stats.SkippedRows[0], "port 2: ") || !strings.HasPrefix(stats.SkippedRows[1], "port_table row 3: ") {
+		t.Errorf("skipped rows = %q, want port 2 and row 3", stats.SkippedRows)
+	}
+}
+
+func TestPowerTotalHandler_ParseMode(t *testing.T) {
+	tests := []struct {
+		name     string
+		mode     statsParseMode
+		query    string
+		wantCode int
+		wantPort []int
+	}{
+		{name: "strict", mode: parseStrict, wantCode: http.StatusBadGateway},
+		{name: "lenient", mode: parseLenient, wantCode: http.StatusOK, wantPort: []int{1, 3}},
+		{name: "lenient query", mode: parseStrict, query: "?parse=lenient", wantCode: http.StatusOK, wantPort: []int{1, 3}},
+		{name: "strict query", mode: parseLenient, query: "?parse=strict", wantCode: http.StatusBadGateway},
+		{name: "unknown query", query: "?parse=loose", wantCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := newTestService(&fakeClient{stats: loadDeviceStats(t, "stat_device_usw_malformed.json")}, nil)
+			svc.statsParse = tt.mode
+
+			r := mux.NewRouter()
+			r.HandleFunc("/device/{mac}/power/total", svc.PowerTotalHandler)
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/device/aa:bb:cc:dd:ee:ff/power/total"+tt.query, http.NoBody))
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantCode, rec.Body)
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+
+			var res PowerTotalResult
+			if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+				t.Fatal(err)
+			}
+			var ports []int
+			for _, p := range res.Ports {
+				ports = append(ports, p.Port)
+			}
+			if !reflect.DeepEqual(ports, tt.wantPort) {
+				t.Errorf("ports = %v, want %v", ports, tt.wantPort)
+			}
+			if len(res.Warnings) != 2 {
+				t.Errorf("warnings = %q, want one per malformed row", res.Warnings)
+			}
+		})
+	}
+}
+
+func TestGetPortStatus_StrictParse(t *testing.T) {
+	svc := newTestService(&fakeClient{stats: loadDeviceStats(t, "stat_device_usw_malformed.json")}, nil)
+	if _, err := svc.getPortStatus(context.Background(), "aa:bb:cc:dd:ee:ff", "1"); err == nil {
+		t.Error("getPortStatus() with malformed rows did not fail in the strict mode")
+	}
+	svc.statsParse = parseLenient
+	if st, err := svc.getPortStatus(context.Background(), "aa:bb:cc:dd:ee:ff", "1"); err != nil || st.State != PowerStateOn {
+		t.Errorf("getPortStatus() = %+v, %v, want port 1 on", st, err)
+	}
+}
+
+func TestGetPoEStatus_Faults(t *testing.T) {
+	svc := newTestService(&fakeClient{stats: loadDeviceStats(t, "stat_device_usw_fault.json")}, nil)
+
+	ports, err := svc.GetAllPoEStatus(context.Background(), "aa:bb:cc:dd:ee:ff")
+	if err != nil {
+		t.Fatalf("GetAllPoEStatus() error = %v", err)
+	}
+	// Port 2 has a classified device but no good power. Port 3 has a self
+	// powered machine, port 4 is off and port 17 has no PoE.
+	want := map[int]PoEFault{1: PoEFaultNone, 2: PoEFaultUnknown, 3: PoEFaultNone, 4: PoEFaultNone, 17: PoEFaultNone}
+	for _, p := range ports {
+		if p.Fault != want[p.Port] {
+			t.Errorf("port %d fault = %q, want %q", p.Port, p.Fault, want[p.Port])
+		}
+	}
+
+	st, err := svc.getPortStatus(context.Background(), "aa:bb:cc:dd:ee:ff", "2")
+	if err != nil {
+		t.Fatalf("getPortStatus() error = %v", err)
+	}
+	if st.Fault != PoEFaultUnknown {
+		t.Errorf("getStatus fault = %q, want %q", st.Fault, PoEFaultUnknown)
+	}
+}
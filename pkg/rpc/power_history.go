@@ -0,0 +1,159 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PowerEvent is a power change made through the service.
+type PowerEvent struct {
+	// State is the requested state, such as "on" or "cycle", and Result
+	// the state the port was left in.
+	State  string    `json:"state"`
+	Result string    `json:"result"`
+	Time   time.Time `json:"time"`
+}
+
+// maxPowerEvents bounds the history kept per port.
+const maxPowerEvents = 50
+
+// powerHistory records the power changes made through the service per
+// machine, so that the last commanded state survives restarts and can stand
+// in for the switch while its controller is unreachable. When path is empty
+// the history only lives in memory.
+type powerHistory struct {
+	path string
+
+	mu     sync.Mutex
+	events map[string][]PowerEvent
+}
+
+func newPowerHistory(path string) (*powerHistory, error) {
+	h := &powerHistory{
+		path:   path,
+		events: map[string][]PowerEvent{},
+	}
+	if path == "" {
+		return h, nil
+	}
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return h, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading state file %s: %w", path, err)
+	}
+	if len(b) == 0 {
+		return h, nil
+	}
+	if err := json.Unmarshal(b, &h.events); err != nil {
+		return nil, fmt.Errorf("error parsing state file %s: %w", path, err)
+	}
+	return h, nil
+}
+
+// Record appends e to the history of m, dropping the oldest events beyond
+// maxPowerEvents.
+func (h *powerHistory) Record(m Machine, e PowerEvent) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	key := bootDeviceKey(m)
+	events := append(h.events[key], e)
+	if len(events) > maxPowerEvents {
+		events = append([]PowerEvent(nil), events[len(events)-maxPowerEvents:]...)
+	}
+	h.events[key] = events
+	return h.save()
+}
+
+// Events returns the history of m, oldest first.
+func (h *powerHistory) Events(m Machine) []PowerEvent {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return append([]PowerEvent{}, h.events[bootDeviceKey(m)]...)
+}
+
+// Last returns the latest power change of m.
+func (h *powerHistory) Last(m Machine) (PowerEvent, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	events := h.events[bootDeviceKey(m)]
+	if len(events) == 0 {
+		return PowerEvent{}, false
+	}
+	return events[len(events)-1], true
+}
+
+// save writes the history to its file. The caller must hold h.mu.
+func (h *powerHistory) save() error {
+	if h.path == "" {
+		return nil
+	}
+
+	b, err := json.MarshalIndent(h.events, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = writeFileAtomic(h.path, b); err != nil {
+		return fmt.Errorf("error writing state file %s: %w", h.path, err)
+	}
+	return nil
+}
+
+// recordPower adds a successful power change of the port to the history.
+// The change has already been made, so failing to persist it is only
+// logged.
+func (b *bmcService) recordPower(macAddress, portIdx, state, result string) {
+	if b.history == nil {
+		return
+	}
+	m, _ := b.aliases.resolve(Machine{MacAddress: macAddress, PortIdx: portIdx})
+	e := PowerEvent{
+		State:  strings.ToLower(strings.TrimSpace(state)),
+		Result: result,
+		Time:   time.Now().UTC(),
+	}
+	if err := b.history.Record(m, e); err != nil {
+		b.logger.Warn("error recording power change", "mac", macAddress, "port", portIdx, "error", err)
+	}
+}
+
+// powerOrLastKnown is GetPower for m, whose port must be resolved. With
+// staleFallback set it answers the last state set through the service while
+// the controller is unreachable, reporting it as stale.
+func (b *bmcService) powerOrLastKnown(ctx context.Context, m Machine) (state string, stale bool, err error) {
+	state, err = b.GetPower(ctx, m.MacAddress, m.PortIdx)
+	if err == nil || !b.staleFallback || b.history == nil || !errors.Is(err, ErrControllerUnreachable) {
+		return state, false, err
+	}
+	last, ok := b.history.Last(m)
+	if !ok {
+		return "", false, err
+	}
+	b.logger.Warn("controller unreachable, answering the last known power state", "mac", m.MacAddress, "port", m.PortIdx, "since", last.Time, "error", err)
+	return last.Result, true, nil
+}
+
+// PowerHistoryHandler lists the power changes made through the service on
+// the port addressed by the {mac} and {port} route variables, oldest first.
+func (b *bmcService) PowerHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	m, _ := b.aliases.resolve(getMachine(r))
+	events := []PowerEvent{}
+	if b.history != nil {
+		events = b.history.Events(m)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(events)
+}
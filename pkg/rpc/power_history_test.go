@@ -0,0 +1,117 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestPowerHistory_PersistsAcrossRestarts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	m := Machine{MacAddress: "AA:BB:CC:DD:EE:FF", PortIdx: "1"}
+	at := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	want := []PowerEvent{
+		{State: PowerStateOff, Result: PowerStateOff, Time: at},
+		{State: PowerStateCycle, Result: PowerStateOn, Time: at.Add(time.Minute)},
+	}
+
+	h, err := newPowerHistory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range want {
+		if err = h.Record(m, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	h, err = newPowerHistory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lower := Machine{MacAddress: "aa:bb:cc:dd:ee:ff", PortIdx: "1"}
+	if got := h.Events(lower); !reflect.DeepEqual(got, want) {
+		t.Errorf("Events() after reload = %+v, want %+v", got, want)
+	}
+	if last, ok := h.Last(lower); !ok || last != want[1] {
+		t.Errorf("Last() = %+v, %v, want %+v, true", last, ok, want[1])
+	}
+	if _, ok := h.Last(Machine{MacAddress: "aa:bb:cc:dd:ee:ff", PortIdx: "2"}); ok {
+		t.Error("Last() found a power change for a port that was never set")
+	}
+}
+
+func TestPowerHistory_Bounded(t *testing.T) {
+	h, _ := newPowerHistory("")
+	m := Machine{MacAddress: "aa:bb:cc:dd:ee:ff", PortIdx: "1"}
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < maxPowerEvents+5; i++ {
+		_ = h.Record(m, PowerEvent{State: PowerStateOn, Result: PowerStateOn, Time: start.Add(time.Duration(i) * time.Second)})
+	}
+	events := h.Events(m)
+	if len(events) != maxPowerEvents || !events[0].Time.Equal(start.Add(5*time.Second)) {
+		t.Errorf("kept %d events from %v, want the latest %d", len(events), events[0].Time, maxPowerEvents)
+	}
+}
+
+func TestRPCHandler_StaleFallback(t *testing.T) {
+	for _, fallback := range []bool{false, true} {
+		t.Run(fmt.Sprintf("fallback=%v", fallback), func(t *testing.T) {
+			fc := &fakeClient{device: newTestDevice("auto")}
+			svc := newTestService(fc, nil)
+			svc.staleFallback = fallback
+
+			if rec := serveRPC(context.Background(), t, svc, `{"id":1,"method":"setPowerState","params":{"state":"off"}}`); rec.Code != http.StatusOK {
+				t.Fatalf("setPowerState status = %d: %s", rec.Code, rec.Body)
+			}
+			fc.err = fmt.Errorf("%w: dial tcp: connection refused", ErrControllerUnreachable)
+
+			rec := serveRPC(context.Background(), t, svc, `{"id":2,"method":"getPowerState"}`)
+			if !fallback {
+				if rec.Code != http.StatusServiceUnavailable {
+					t.Errorf("status = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+				}
+				return
+			}
+			var rp ResponsePayload
+			if err := json.Unmarshal(rec.Body.Bytes(), &rp); err != nil {
+				t.Fatal(err)
+			}
+			if rec.Code != http.StatusOK || rp.Result != PowerStateOff || !rp.Stale {
+				t.Errorf("response = %d %s, want the last state off flagged as stale", rec.Code, rec.Body)
+			}
+		})
+	}
+}
+
+func TestPowerHistoryHandler(t *testing.T) {
+	svc := newTestService(&fakeClient{device: newTestDevice("auto")}, nil)
+	serveRPC(context.Background(), t, svc, `{"id":1,"method":"setPowerState","params":{"state":"Off"}}`)
+
+	r := mux.NewRouter()
+	r.HandleFunc("/device/{mac}/ports/{port}/history", svc.PowerHistoryHandler)
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/device/aa:bb:cc:dd:ee:ff/ports/1/history", http.NoBody))
+
+	var events []PowerEvent
+	if err := json.Unmarshal(rec.Body.Bytes(), &events); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].State != PowerStateOff || events[0].Result != PowerStateOff || events[0].Time.IsZero() {
+		t.Errorf("history = %+v, want the off request", events)
+	}
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/device/aa:bb:cc:dd:ee:ff/ports/2/history", http.NoBody))
+	if got := rec.Body.String(); got != "[]\n" {
+		t.Errorf("history of an unchanged port = %q, want an empty list", got)
+	}
+}
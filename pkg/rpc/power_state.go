@@ -0,0 +1,165 @@
+package rpc
+
+import (
+	"fmt"
+	"maps"
+	"strings"
+
+	"github.com/paultyng/go-unifi/unifi"
+)
+
+// poeModeMap maps every power state that can be set on a port to the
+// controller PoE mode implementing it.
+type poeModeMap map[PowerGetResult]string
+
+// defaultPoEModes is the mapping used unless the configuration overrides it.
+var defaultPoEModes = poeModeMap{
+	PoweredOn:  "auto",
+	PoweredOff: "off",
+}
+
+// validPoEModes are the PoE modes the controller accepts in a port override.
+var validPoEModes = map[string]bool{
+	"auto":        true,
+	"pasv24":      true,
+	"passthrough": true,
+	"off":         true,
+}
+
+// newPoEModeMap applies overrides, keyed by power state, to the default
+// mapping. Passive PoE devices for example need "on" mapped to "pasv24".
+func newPoEModeMap(overrides map[string]string) (poeModeMap, error) {
+	modes := maps.Clone(defaultPoEModes)
+	for k, mode := range overrides {
+		st, err := ParsePowerState(k)
+		if err != nil || (st != PoweredOn && st != PoweredOff) {
+			return nil, fmt.Errorf("poeModes: %q is not a power state, use %q or %q", k, PowerStateOn, PowerStateOff)
+		}
+		if !validPoEModes[mode] {
+			return nil, fmt.Errorf("poeModes: %q is not a PoE mode, use one of auto, pasv24, passthrough or off", mode)
+		}
+		modes[st] = mode
+	}
+	if modes[PoweredOn] == modes[PoweredOff] {
+		return nil, fmt.Errorf("poeModes: %q and %q both map to PoE mode %q", PowerStateOn, PowerStateOff, modes[PoweredOn])
+	}
+	return modes, nil
+}
+
+// newPortOnModes validates the PoE modes that turn on the switch ports of
+// overrides instead of the on mode of modes.
+func newPortOnModes(overrides map[int]string, modes poeModeMap) (map[int]string, error) {
+	for p, mode := range overrides {
+		if p < 1 {
+			return nil, fmt.Errorf("poeOnModes: %d is not a port number", p)
+		}
+		if !validPoEModes[mode] {
+			return nil, fmt.Errorf("poeOnModes: port %d: %q is not a PoE mode, use one of auto, pasv24 or passthrough", p, mode)
+		}
+		if mode == modes[PoweredOff] {
+			return nil, fmt.Errorf("poeOnModes: port %d: %q is the PoE mode of %q", p, mode, PowerStateOff)
+		}
+	}
+	return overrides, nil
+}
+
+// portModes returns the mapping for the switch port p, which turns on with
+// its entry in portOnModes when it has one.
+func (b *bmcService) portModes(p int) poeModeMap {
+	mode, ok := b.portOnModes[p]
+	if !ok {
+		return b.poeModes
+	}
+	modes := maps.Clone(b.poeModes)
+	modes[PoweredOn] = mode
+	return modes
+}
+
+// setPortPoEMode updates the PoE mode of the switch port p on dev to match
+// state, see setPoeMode. With preservePoEMode set, turning on only changes
+// ports that are off, so that PoE modes set by hand in the controller, such
+// as passthrough, are left alone.
+func (b *bmcService) setPortPoEMode(dev *unifi.Device, p int, state string) (bool, error) {
+	if b.preservePoEMode {
+		if st, err := ParsePowerState(state); err == nil && st == PoweredOn && b.modeState(p, portPoeMode(dev, p)) == PoweredOn {
+			return false, nil
+		}
+	}
+	return setPoeMode(b.portModes(p), dev, p, state)
+}
+
+// modeState maps the PoE mode of the switch port p to its power state.
+// With preservePoEMode set, every PoE mode but off powers the port and
+// reads as on.
+func (b *bmcService) modeState(p int, mode string) PowerGetResult {
+	st := b.portModes(p).state(mode)
+	if st == "" && b.preservePoEMode && validPoEModes[mode] && mode != "off" {
+		return PoweredOn
+	}
+	return st
+}
+
+// portPowerState maps the live state of the switch port p to the power
+// state reported to clients, telling ports without PoE apart from ports
+// with PoE turned off. See modeState.
+func (b *bmcService) portPowerState(p int, poe bool, mode string) PowerGetResult {
+	if !poe {
+		return NotPoE
+	}
+	return b.modeState(p, mode)
+}
+
+// ParsePowerState parses a power state as accepted in PowerSetParams.State
+// or reported by getPowerState, ignoring case and surrounding white space.
+// PowerStateSoft is recognized but returns an error wrapping ErrNotSupported.
+func ParsePowerState(s string) (PowerGetResult, error) {
+	switch st := PowerGetResult(strings.ToLower(strings.TrimSpace(s))); st {
+	case PoweredOn, PoweredOff, PoweringOn, PoweringOff:
+		return st, nil
+	case PowerStateSoft:
+		return "", errSoftPower
+	}
+	return "", fmt.Errorf("%w: unsupported power state %q", ErrInvalidState, s)
+}
+
+// state maps a controller PoE mode to the power state reported to clients.
+// Modes without a power state, including ports without PoE, map to the
+// empty state.
+func (m poeModeMap) state(mode string) PowerGetResult {
+	for state, pm := range m {
+		if pm == mode {
+			return state
+		}
+	}
+	return ""
+}
+
+// relayState maps a PDU outlet relay to the power state reported to clients.
+func relayState(relay bool) PowerGetResult {
+	if relay {
+		return PoweredOn
+	}
+	return PoweredOff
+}
+
+// devicePowerState reports st as its transitional state while a device in
+// state dev is still provisioning, that is while the change leading to st is
+// being applied.
+func devicePowerState(dev unifi.DeviceState, st PowerGetResult) PowerGetResult {
+	if dev != unifi.DeviceStateProvisioning {
+		return st
+	}
+	switch st {
+	case PoweredOn:
+		return PoweringOn
+	case PoweredOff:
+		return PoweringOff
+	}
+	return st
+}
+
+// livePortState is portPowerState as reported while the device is in state
+// dev, see devicePowerState.
+func (b *bmcService) livePortState(dev unifi.DeviceState, p int, poe bool, mode string) PowerGetResult {
+	return devicePowerState(dev, b.portPowerState(p, poe, mode))
+}
@@ -0,0 +1,149 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestParsePowerState(t *testing.T) {
+	tests := []struct {
+		in           string
+		want         PowerGetResult
+		wantErr      bool
+		notSupported bool
+	}{
+		{in: "on", want: PoweredOn},
+		{in: "off", want: PoweredOff},
+		{in: " OFF ", want: PoweredOff},
+		{in: "powering on", want: PoweringOn},
+		{in: "Powering Off", want: PoweringOff},
+		{in: "soft", wantErr: true, notSupported: true},
+		{in: "reset", wantErr: true},
+		{in: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParsePowerState(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParsePowerState(%q) = %q, %v, want %q, wantErr %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+		if errors.Is(err, ErrNotSupported) != tt.notSupported {
+			t.Errorf("ParsePowerState(%q) error = %v, want ErrNotSupported %v", tt.in, err, tt.notSupported)
+		}
+	}
+}
+
+func TestPoEModeState_RoundTrip(t *testing.T) {
+	for state, mode := range defaultPoEModes {
+		if got := defaultPoEModes.state(mode); got != state {
+			t.Errorf("state(%q) = %q, want %q", mode, got, state)
+		}
+	}
+	if got := defaultPoEModes.state("pasv24"); got != "" {
+		t.Errorf("state(pasv24) = %q, want empty", got)
+	}
+}
+
+func TestSetPoeMode_TransitionalStateRejected(t *testing.T) {
+	if _, err := setPoeMode(defaultPoEModes, newTestDevice("off"), 1, "powering on"); err == nil {
+		t.Error("setPoeMode() accepted a transitional state")
+	}
+}
+
+func TestNewPoEModeMap(t *testing.T) {
+	modes, err := newPoEModeMap(map[string]string{"on": "pasv24"})
+	if err != nil {
+		t.Fatalf("newPoEModeMap() error = %v", err)
+	}
+	dev := newTestDevice("off")
+	if changed, err := setPoeMode(modes, dev, 1, PowerStateOn); err != nil || !changed {
+		t.Fatalf("setPoeMode() = %v, %v", changed, err)
+	}
+	if got := dev.PortOverrides[0].PoeMode; got != "pasv24" {
+		t.Errorf("PoE mode = %q, want pasv24", got)
+	}
+	if got := modes.state("pasv24"); got != PoweredOn {
+		t.Errorf("state(pasv24) = %q, want %q", got, PoweredOn)
+	}
+
+	for _, overrides := range []map[string]string{
+		{"on": "48v"},
+		{"cycle": "auto"},
+		{"on": "off"},
+	} {
+		if _, err := newPoEModeMap(overrides); err == nil {
+			t.Errorf("newPoEModeMap(%v) accepted an invalid mapping", overrides)
+		}
+	}
+}
+
+func TestSetPortPower_PortOnModes(t *testing.T) {
+	fc := &fakeClient{device: newTestDevice("off", "off")}
+	svc := newTestService(fc, nil)
+	var err error
+	if svc.poeModes, err = newPoEModeMap(map[string]string{"on": "passthrough"}); err != nil {
+		t.Fatal(err)
+	}
+	if svc.portOnModes, err = newPortOnModes(map[int]string{2: "pasv24"}, svc.poeModes); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, port := range []string{"1", "2"} {
+		res, err := svc.setPortPower(context.Background(), "aa:bb:cc:dd:ee:ff", port, PowerStateOn, false)
+		if err != nil {
+			t.Fatalf("setPortPower(%s) error = %v", port, err)
+		}
+		if res.Current != PowerStateOn {
+			t.Errorf("setPortPower(%s) current = %q, want %q", port, res.Current, PowerStateOn)
+		}
+	}
+	got := fc.updates[len(fc.updates)-1].PortOverrides
+	if got[0].PoeMode != "passthrough" || got[1].PoeMode != "pasv24" {
+		t.Errorf("PoE modes = %q, %q, want passthrough and pasv24", got[0].PoeMode, got[1].PoeMode)
+	}
+
+	fc.device = fc.updates[len(fc.updates)-1]
+	if state, err := svc.GetPower(context.Background(), "aa:bb:cc:dd:ee:ff", "2"); err != nil || state != PowerStateOn {
+		t.Errorf("GetPower(2) = %q, %v, want %q", state, err, PowerStateOn)
+	}
+}
+
+func TestNewPortOnModes_Invalid(t *testing.T) {
+	for _, overrides := range []map[int]string{{1: "off"}, {1: "passive24"}, {0: "pasv24"}} {
+		if _, err := newPortOnModes(overrides, defaultPoEModes); err == nil {
+			t.Errorf("newPortOnModes(%v) accepted an invalid override", overrides)
+		}
+	}
+}
+
+func TestSetPortPower_PreservePoEMode(t *testing.T) {
+	for _, preserve := range []bool{false, true} {
+		fc := &fakeClient{device: newTestDevice("passthrough", "off")}
+		svc := newTestService(fc, nil)
+		svc.preservePoEMode = preserve
+
+		for _, port := range []string{"1", "2"} {
+			if _, err := svc.setPortPower(context.Background(), "aa:bb:cc:dd:ee:ff", port, PowerStateOn, false); err != nil {
+				t.Fatalf("preserve %v: setPortPower(%s) error = %v", preserve, port, err)
+			}
+		}
+		got := fc.updates[len(fc.updates)-1].PortOverrides
+		// Port 2 is off and turned on either way.
+		want := []string{"auto", "auto"}
+		if preserve {
+			want[0] = "passthrough"
+		}
+		if got[0].PoeMode != want[0] || got[1].PoeMode != want[1] {
+			t.Errorf("preserve %v: PoE modes = %q, %q, want %q", preserve, got[0].PoeMode, got[1].PoeMode, want)
+		}
+
+		fc.device = newTestDevice("passthrough")
+		state, err := svc.GetPower(context.Background(), "aa:bb:cc:dd:ee:ff", "1")
+		if err != nil {
+			t.Fatalf("preserve %v: GetPower() error = %v", preserve, err)
+		}
+		if want := map[bool]string{false: "", true: PowerStateOn}[preserve]; state != want {
+			t.Errorf("preserve %v: passthrough port reads %q, want %q", preserve, state, want)
+		}
+	}
+}
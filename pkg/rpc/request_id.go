@@ -0,0 +1,30 @@
+package rpc
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+)
+
+type requestIDKey struct{}
+
+// WithRequestID returns a copy of ctx carrying the correlation ID of the
+// request being served.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the correlation ID carried by ctx, or the empty string.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// requestLogger returns the service logger annotated with the correlation
+// ID of r, if it has one.
+func (b *bmcService) requestLogger(r *http.Request) *slog.Logger {
+	if id := RequestID(r.Context()); id != "" {
+		return b.logger.With("requestId", id)
+	}
+	return b.logger
+}
@@ -0,0 +1,59 @@
+package rpc
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// PortPower is the body of the REST power route of a port.
+type PortPower struct {
+	State string `json:"state"`
+	// Stale is set like in ResponsePayload.
+	Stale bool `json:"stale,omitempty"`
+}
+
+// PortPowerHandler serves the power state of the port addressed by the
+// {mac} and {port} route variables for clients that do not speak JSON-RPC.
+// GET answers a PortPower, PUT takes one to set the port on, off, reset or
+// cycle and answers the PowerSetResult. The host query parameter is handled
+// like in PowerTotalHandler.
+func (b *bmcService) PortPowerHandler(w http.ResponseWriter, r *http.Request) {
+	machine, alias := b.aliases.resolve(getMachine(r))
+	logger := b.requestLogger(r).With("mac", machine.MacAddress, "port", machine.PortIdx)
+	if alias != "" {
+		logger = logger.With("portAlias", alias)
+	}
+
+	svc, err := b.forHost(r.URL.Query().Get("host"))
+	if err != nil {
+		logger.Error("error selecting controller", "error", err)
+		writeError(w, ResponsePayload{}, http.StatusBadRequest, ReasonUnknownHost, err.Error())
+		return
+	}
+
+	var res any
+	if r.Method == http.MethodPut {
+		var req PortPower
+		if err = b.decodeRequest(r, &req); err != nil {
+			writeRequestError(w, err)
+			return
+		}
+		res, err = svc.setPortPower(r.Context(), machine.MacAddress, machine.PortIdx, req.State, false)
+		if err != nil {
+			failCall(w, ResponsePayload{}, logger, err, fmt.Sprintf("error setting power state for MAC Address %s, Port Index %s: %v", machine.MacAddress, machine.PortIdx, err))
+			return
+		}
+	} else {
+		state, stale, getErr := svc.powerOrLastKnown(r.Context(), machine)
+		if getErr != nil {
+			failCall(w, ResponsePayload{}, logger, getErr, fmt.Sprintf("error getting power state for MAC Address %s, Port Index %s: %v", machine.MacAddress, machine.PortIdx, getErr))
+			return
+		}
+		res = PortPower{State: state, Stale: stale}
+	}
+
+	logger.Info("power request handled", "httpMethod", r.Method)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(res)
+}
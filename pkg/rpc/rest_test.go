@@ -0,0 +1,57 @@
+package rpc
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestPortPowerHandler(t *testing.T) {
+	const mac = "aa:bb:cc:dd:ee:ff"
+	fc := NewFakeController()
+	fc.AddSwitch(mac, 8)
+	svc := newTestService(fc, nil)
+
+	r := mux.NewRouter()
+	r.HandleFunc("/device/{mac}/ports/{port}/power", svc.PortPowerHandler).Methods("GET", "PUT")
+	serve := func(method, port, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(method, "/device/"+mac+"/ports/"+port+"/power", strings.NewReader(body)))
+		return rec
+	}
+
+	rec := serve(http.MethodPut, "3", `{"state":"off"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("PUT status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	var set PowerSetResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &set); err != nil {
+		t.Fatal(err)
+	}
+	if set.Previous != PowerStateOn || set.Current != PowerStateOff {
+		t.Errorf("PUT result = %+v, want on to off", set)
+	}
+	if st, _ := fc.PortState(mac, 3); st != PoweredOff {
+		t.Errorf("port 3 = %q after PUT, want %q", st, PoweredOff)
+	}
+
+	rec = serve(http.MethodGet, "3", "")
+	var got PortPower
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK || got.State != PowerStateOff {
+		t.Errorf("GET = %d %+v, want port 3 off", rec.Code, got)
+	}
+
+	if rec = serve(http.MethodPut, "3", `{"state":"sideways"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("PUT with an unknown state: status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if rec = serve(http.MethodGet, "99", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("GET of a missing port: status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
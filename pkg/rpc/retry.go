@@ -0,0 +1,176 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// retryBaseDelay is the wait before the first retry, doubled on each
+// further attempt.
+const retryBaseDelay = 200 * time.Millisecond
+
+// isTransient reports whether err is a network level failure that is worth
+// retrying. Errors returned by the controller itself are never transient.
+func isTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout() && !errors.Is(err, context.DeadlineExceeded)
+}
+
+// withRetry runs op until it succeeds, fails permanently, or maxRetries
+// retries have been used. The backoff between attempts honors ctx.
+func withRetry(ctx context.Context, maxRetries int, baseDelay time.Duration, op func() error) error {
+	delay := baseDelay
+	for attempt := 0; ; attempt++ {
+		err := op()
+		if err == nil || attempt >= maxRetries || !isTransient(err) || ctx.Err() != nil {
+			return err
+		}
+
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+		delay *= 2
+	}
+}
+
+// maxRetryAfter caps the wait asked for by a Retry-After header, so that a
+// controller asking for minutes does not hold requests without a deadline.
+const maxRetryAfter = 30 * time.Second
+
+// retryStatusTransport retries the requests that the controller answers
+// with 429, or with a 5xx while it is busy with a backup. go-unifi reduces
+// failed responses to their message, so this is done below it rather than
+// by withRetry. Only idempotent requests are retried, after the wait of
+// their Retry-After header or else the same doubling backoff as withRetry.
+// The last response is returned when the retries run out, and an answer
+// asking for a wait past the request deadline is returned right away.
+type retryStatusTransport struct {
+	next       http.RoundTripper
+	maxRetries int
+	baseDelay  time.Duration
+}
+
+func (t *retryStatusTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !isReplayable(req) {
+		return t.next.RoundTrip(req)
+	}
+	ctx := req.Context()
+	delay := t.baseDelay
+	for attempt := 0; ; attempt++ {
+		resp, err := t.next.RoundTrip(req)
+		if err != nil || attempt >= t.maxRetries || !isRetryableStatus(resp.StatusCode) {
+			return resp, err
+		}
+		wait := delay
+		if d, ok := retryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+			wait = d
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			return resp, nil
+		}
+		_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxErrorBody))
+		resp.Body.Close()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+		delay *= 2
+
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(ctx)
+			req.Body = body
+		}
+	}
+}
+
+// isReplayable reports whether req is idempotent and its body, if any, can
+// be sent again.
+func isReplayable(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+	default:
+		return false
+	}
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+// isRetryableStatus reports whether a response with code is worth a retry.
+// 501 stays final since the controller will never implement the call.
+func isRetryableStatus(code int) bool {
+	return code == http.StatusTooManyRequests || (code >= 500 && code != http.StatusNotImplemented)
+}
+
+// retryAfter parses a Retry-After header, given in seconds or as an HTTP
+// date, into the wait it asks for at now, capped at maxRetryAfter.
+func retryAfter(v string, now time.Time) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	var d time.Duration
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		d = time.Duration(secs) * time.Second
+	} else if at, err := http.ParseTime(v); err == nil {
+		d = max(at.Sub(now), 0)
+	} else {
+		return 0, false
+	}
+	return min(d, maxRetryAfter), true
+}
+
+// isUnreachable reports whether err means the controller could not be
+// connected to at all: a failed name lookup, a refused connection or a
+// network without a route to it.
+func isUnreachable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EHOSTUNREACH) ||
+		errors.Is(err, syscall.ENETUNREACH) {
+		return true
+	}
+	var dnsErr *net.DNSError
+	var opErr *net.OpError
+	return errors.As(err, &dnsErr) || (errors.As(err, &opErr) && opErr.Op == "dial")
+}
+
+// markUnreachable wraps err with ErrControllerUnreachable when isUnreachable
+// reports it as such.
+func markUnreachable(err error) error {
+	if !isUnreachable(err) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", ErrControllerUnreachable, err)
+}
@@ -0,0 +1,90 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestWithRetry_TransientThenSuccess(t *testing.T) {
+	calls := 0
+	err := withRetry(context.Background(), 2, time.Millisecond, func() error {
+		calls++
+		if calls <= 2 {
+			return fmt.Errorf("unable to perform request: %w", syscall.ECONNRESET)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("withRetry() error = %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("op called %d times, want 3", calls)
+	}
+}
+
+func TestWithRetry_ExhaustsRetries(t *testing.T) {
+	calls := 0
+	err := withRetry(context.Background(), 2, time.Millisecond, func() error {
+		calls++
+		return syscall.ECONNRESET
+	})
+	if !errors.Is(err, syscall.ECONNRESET) {
+		t.Fatalf("withRetry() error = %v, want ECONNRESET", err)
+	}
+	if calls != 3 {
+		t.Errorf("op called %d times, want 3", calls)
+	}
+}
+
+func TestWithRetry_PermanentError(t *testing.T) {
+	calls := 0
+	_ = withRetry(context.Background(), 2, time.Millisecond, func() error {
+		calls++
+		return errors.New("api.err.InvalidPayload")
+	})
+	if calls != 1 {
+		t.Errorf("op called %d times for a permanent error, want 1", calls)
+	}
+}
+
+func TestWithRetry_HonorsContext(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_ = withRetry(ctx, 10, time.Second, func() error {
+		return syscall.ECONNRESET
+	})
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("withRetry() kept retrying for %v after the context expired", elapsed)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		header string
+		want   time.Duration
+		ok     bool
+	}{
+		{header: ""},
+		{header: "soon"},
+		{header: "-1"},
+		{header: "0", ok: true},
+		{header: "3", want: 3 * time.Second, ok: true},
+		{header: "3600", want: maxRetryAfter, ok: true},
+		{header: now.Add(5 * time.Second).Format(http.TimeFormat), want: 5 * time.Second, ok: true},
+		{header: now.Add(-time.Minute).Format(http.TimeFormat), ok: true},
+	}
+	for _, tt := range tests {
+		got, ok := retryAfter(tt.header, now)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("retryAfter(%q) = %v, %v, want %v, %v", tt.header, got, ok, tt.want, tt.ok)
+		}
+	}
+}
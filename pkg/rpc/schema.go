@@ -0,0 +1,179 @@
+package rpc
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// methodSpec describes an RPC method. The specs of an endpoint are what its
+// handler decodes params with and what SchemaHandler publishes, so the two
+// cannot drift apart.
+type methodSpec struct {
+	method      Method
+	description string
+	// params is the type params are decoded into, nil for methods without
+	// params.
+	params reflect.Type
+	// result is the type of ResponsePayload.Result, nil for methods that
+	// leave it empty.
+	result reflect.Type
+	// noPort marks methods of the whole device, which are accepted on
+	// routes naming no port even without a default port.
+	noPort bool
+}
+
+type methodSpecs []methodSpec
+
+func (s methodSpecs) lookup(m Method) (methodSpec, bool) {
+	for _, spec := range s {
+		if spec.method == m {
+			return spec, true
+		}
+	}
+	return methodSpec{}, false
+}
+
+func typeOf[T any]() reflect.Type {
+	return reflect.TypeOf((*T)(nil)).Elem()
+}
+
+// portMethods are the methods of the port RPC endpoint, in the order they
+// are listed by SchemaHandler.
+var portMethods = methodSpecs{
+	{method: PowerGetMethod, description: "Get the power state of the port.", result: typeOf[PowerGetResult]()},
+	{method: PowerSetMethod, description: "Set the power state of the port to on, off, reset, cycle or toggle.", params: typeOf[PowerSetParams](), result: typeOf[PowerSetResult]()},
+	{method: PowerSetBatchMethod, description: "Set the power state of several ports of the device with a single update.", params: typeOf[PowerSetBatchParams](), result: typeOf[[]PortPowerSetResult]()},
+	{method: PowerCycleAllMethod, description: "Power cycle every port of the device that is on, except the critical and excluded ports.", params: typeOf[PowerCycleAllParams](), result: typeOf[[]PortCycleResult]()},
+	{method: StatusMethod, description: "Get the power state and live PoE readings of the port.", result: typeOf[PortStatus]()},
+	{method: PoEStatusMethod, description: "Get the PoE draw and budget of the device and all its ports.", result: typeOf[PowerTotalResult]()},
+	{method: BootDeviceMethod, description: "Record the boot device requested for the machine on the port.", params: typeOf[BootDeviceParams](), result: typeOf[BootDeviceResult]()},
+	{method: BootDeviceGetMethod, description: "Get the boot device recorded for the machine on the port.", result: typeOf[BootDeviceParams]()},
+	{method: PingMethod, description: "Check that the service is up, and with --ping-checks-switch that the device answers the controller.", result: typeOf[string](), noPort: true},
+	{method: SwitchInfoMethod, description: "Get the model and firmware version of the device.", result: typeOf[SwitchInfo](), noPort: true},
+}
+
+// outletMethods are the methods of the PDU outlet RPC endpoint.
+var outletMethods = methodSpecs{
+	{method: PowerGetMethod, description: "Get the power state of the outlet.", result: typeOf[PowerGetResult]()},
+	{method: PowerSetMethod, description: "Switch the outlet on or off.", params: typeOf[PowerSetParams]()},
+	{method: PingMethod, description: "Check that the service is up, and with --ping-checks-switch that the device answers the controller.", result: typeOf[string]()},
+}
+
+// methodParams decodes the params of req as declared by specs, answering
+// the request itself and returning false when they do not decode. Unknown
+// methods have no params and are left to the handler to reject.
+func (b *bmcService) methodParams(w http.ResponseWriter, rp ResponsePayload, logger *slog.Logger, specs methodSpecs, req RequestPayload) (any, bool) {
+	spec, ok := specs.lookup(req.Method)
+	if !ok || spec.params == nil {
+		return nil, true
+	}
+	p := reflect.New(spec.params).Interface()
+	if err := decodeParams(req.Params, p, b.strict); err != nil {
+		logger.Error("error decoding params", "type", spec.params.Name(), "error", err)
+		writeError(w, rp, http.StatusBadRequest, ReasonInvalidParams, fmt.Sprintf("error decoding params to %s: %v", spec.params.Name(), err))
+		return nil, false
+	}
+	return p, true
+}
+
+// Schema describes the RPC endpoints and their methods.
+type Schema struct {
+	Endpoints []EndpointSchema `json:"endpoints"`
+}
+
+// EndpointSchema lists the methods accepted by one RPC endpoint.
+type EndpointSchema struct {
+	Path    string         `json:"path"`
+	Methods []MethodSchema `json:"methods"`
+}
+
+// MethodSchema describes a single RPC method. Params and Result are JSON
+// Schema style type descriptions and are omitted when the method has no
+// params or no result.
+type MethodSchema struct {
+	Name        Method      `json:"name"`
+	Description string      `json:"description"`
+	Params      *TypeSchema `json:"params,omitempty"`
+	Result      *TypeSchema `json:"result,omitempty"`
+}
+
+// TypeSchema is the subset of JSON Schema needed to describe the params
+// and results of the RPC methods.
+type TypeSchema struct {
+	Type       string                 `json:"type"`
+	Properties map[string]*TypeSchema `json:"properties,omitempty"`
+	Required   []string               `json:"required,omitempty"`
+	Items      *TypeSchema            `json:"items,omitempty"`
+}
+
+// typeSchema describes t. Struct fields are described by their JSON names
+// and are required unless tagged omitempty.
+func typeSchema(t reflect.Type) *TypeSchema {
+	if t == nil {
+		return nil
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return typeSchema(t.Elem())
+	case reflect.String:
+		return &TypeSchema{Type: "string"}
+	case reflect.Bool:
+		return &TypeSchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &TypeSchema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &TypeSchema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		return &TypeSchema{Type: "array", Items: typeSchema(t.Elem())}
+	case reflect.Struct:
+		s := &TypeSchema{Type: "object", Properties: map[string]*TypeSchema{}}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			s.Properties[name] = typeSchema(f.Type)
+			if !strings.Contains(opts, "omitempty") {
+				s.Required = append(s.Required, name)
+			}
+		}
+		return s
+	}
+	return &TypeSchema{Type: "object"}
+}
+
+func endpointSchema(path string, specs methodSpecs) EndpointSchema {
+	e := EndpointSchema{Path: path, Methods: make([]MethodSchema, 0, len(specs))}
+	for _, spec := range specs {
+		e.Methods = append(e.Methods, MethodSchema{
+			Name:        spec.method,
+			Description: spec.description,
+			Params:      typeSchema(spec.params),
+			Result:      typeSchema(spec.result),
+		})
+	}
+	return e
+}
+
+// SchemaHandler answers with a machine readable description of the RPC
+// methods of the port and outlet endpoints, their params and their results.
+func SchemaHandler(w http.ResponseWriter, _ *http.Request) {
+	s := Schema{Endpoints: []EndpointSchema{
+		endpointSchema("/device/{mac}/port/{port}/rpc", portMethods),
+		endpointSchema("/device/{mac}/outlet/{outlet}/rpc", outletMethods),
+	}}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(s)
+}
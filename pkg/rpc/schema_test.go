@@ -0,0 +1,65 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestPortMethods_Handled(t *testing.T) {
+	for _, spec := range portMethods {
+		t.Run(string(spec.method), func(t *testing.T) {
+			svc := newTestService(&fakeClient{device: newTestDevice("auto"), stats: loadDeviceStats(t, "stat_device_usw.json")}, nil)
+			rec := serveRPC(context.Background(), t, svc, `{"id":1,"method":"`+string(spec.method)+`","params":{}}`)
+			if rec.Code == http.StatusNotFound {
+				t.Errorf("method %s is described but not handled: %s", spec.method, rec.Body)
+			}
+		})
+	}
+
+	svc := newTestService(&fakeClient{device: newTestDevice("auto")}, nil)
+	rec := serveRPC(context.Background(), t, svc, `{"id":1,"method":"setVirtualMedia"}`)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("undescribed method: status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	if got := responseReason(t, rec); got != ReasonUnknownMethod {
+		t.Errorf("undescribed method: reason = %q, want %q", got, ReasonUnknownMethod)
+	}
+}
+
+func TestSchemaHandler(t *testing.T) {
+	rec := httptest.NewRecorder()
+	SchemaHandler(rec, httptest.NewRequest(http.MethodGet, "/schema", http.NoBody))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	var s Schema
+	if err := json.Unmarshal(rec.Body.Bytes(), &s); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Endpoints) != 2 || len(s.Endpoints[0].Methods) != len(portMethods) || len(s.Endpoints[1].Methods) != len(outletMethods) {
+		t.Fatalf("schema = %+v, want every port and outlet method", s)
+	}
+
+	methods := map[Method]MethodSchema{}
+	for _, m := range s.Endpoints[0].Methods {
+		methods[m.Name] = m
+	}
+	set := methods[PowerSetMethod]
+	if set.Params == nil || set.Params.Properties["state"].Type != "string" || set.Params.Properties["force"].Type != "boolean" {
+		t.Errorf("setPowerState params = %+v, want state and force", set.Params)
+	}
+	if want := []string{"state"}; !reflect.DeepEqual(set.Params.Required, want) {
+		t.Errorf("setPowerState required = %v, want %v", set.Params.Required, want)
+	}
+	if res := methods[PowerSetBatchMethod].Result; res == nil || res.Type != "array" || res.Items.Properties["port"].Type != "integer" {
+		t.Errorf("setPowerStateBatch result = %+v, want an array of port results", res)
+	}
+	if get := methods[PowerGetMethod]; get.Params != nil || get.Result.Type != "string" {
+		t.Errorf("getPowerState = %+v, want no params and a string result", get)
+	}
+}
@@ -2,107 +2,715 @@ package rpc
 
 import (
 	"context"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/paultyng/go-unifi/unifi"
 	"github.com/ubiquiti-community/unifi-rpc/pkg/config"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type BMCService interface {
 	RPCHandler(w http.ResponseWriter, r *http.Request)
+	OutletRPCHandler(w http.ResponseWriter, r *http.Request)
+	PowerTotalHandler(w http.ResponseWriter, r *http.Request)
+	PortsHandler(w http.ResponseWriter, r *http.Request)
+	// PortPowerHandler gets and sets the power state of a port without
+	// the JSON-RPC envelope.
+	PortPowerHandler(w http.ResponseWriter, r *http.Request)
+	// PowerHistoryHandler lists the power changes made through the
+	// service on a port.
+	PowerHistoryHandler(w http.ResponseWriter, r *http.Request)
+	WatchHandler(w http.ResponseWriter, r *http.Request)
+	// LockHandler reports the maintenance lock on GET and sets it on POST.
+	LockHandler(w http.ResponseWriter, r *http.Request)
+	// AdminStatusHandler reports the request counters, what the requests
+	// last saw of each port and the uptime.
+	AdminStatusHandler(w http.ResponseWriter, r *http.Request)
+	// DebugStatsHandler serves the output of DebugDeviceStats. It exposes
+	// the full device record of the controller and is only routed on
+	// request.
+	DebugStatsHandler(w http.ResponseWriter, r *http.Request)
+
+	// ForHost returns the service bound to the controller configured for
+	// host, as selected by the host field of an RPC request.
+	ForHost(host string) (BMCService, error)
+	GetPower(ctx context.Context, macAddress string, portIdx string) (string, error)
+	SetPortPower(ctx context.Context, macAddress string, portIdx string, state string) error
+	// GetSwitchInfo returns the model and firmware version of the device.
+	GetSwitchInfo(ctx context.Context, macAddress string) (SwitchInfo, error)
+	// DebugDeviceStats returns the stat/device response of the controller
+	// for the device as received, for reporting decoding problems.
+	DebugDeviceStats(ctx context.Context, macAddress string) ([]byte, error)
+	// SetLocked takes or releases the maintenance lock, which refuses
+	// power changes with ErrLocked while reads keep working.
+	SetLocked(locked bool)
+	// InFlight is the number of RPC requests being handled.
+	InFlight() int64
+	// Preflight logs in to every configured controller.
+	Preflight(ctx context.Context) error
+	// Close stops the background work of the controller clients, such as
+	// their keepalives.
+	Close()
+}
+
+// unifiClient is the subset of the controller API used by bmcService.
+type unifiClient interface {
+	GetDeviceByMAC(ctx context.Context, site, mac string) (*unifi.Device, error)
+	UpdateDevice(ctx context.Context, site string, d *unifi.Device) (*unifi.Device, error)
+	GetDeviceStats(ctx context.Context, site, mac string) (*deviceStats, error)
+	// GetRawDeviceStats returns the undecoded response GetDeviceStats reads.
+	GetRawDeviceStats(ctx context.Context, site, mac string) ([]byte, error)
+	PowerCyclePort(ctx context.Context, site, mac string, port int) error
+	// Preflight checks that the controller is reachable and accepts the
+	// configured credentials.
+	Preflight(ctx context.Context) error
 }
 
 type bmcService struct {
-	client *lazyClient
+	logger *slog.Logger
+	// client is the controller used for the current request, see forHost.
+	client unifiClient
+	// clients holds the controllers by host, "" being the default one.
+	clients map[string]unifiClient
+	// site is the UniFi site of client, sites those of clients by host.
+	site  string
+	sites map[string]string
+	// strict rejects request bodies and params with unknown fields.
+	strict   bool
+	poeModes poeModeMap
+	// portOnModes overrides the on mode of poeModes by switch port, see
+	// portModes.
+	portOnModes map[int]string
+	ports       portMap
+	resetDwell  time.Duration
+	locks       *deviceLocks
+	devices     *deviceCache
+	switchInfo  *switchInfoCache
+	bootDevices *bootDeviceStore
+	// history records the power changes made through the service, and
+	// stands in for unreachable controllers in getPowerState when
+	// staleFallback is set.
+	history       *powerHistory
+	staleFallback bool
+	watcher       *powerWatcher
+	// criticalPorts are never touched by powerCycleAll, which staggers its
+	// cycles by cycleStagger.
+	criticalPorts []int
+	cycleStagger  time.Duration
+	// powerOnStagger and powerOnReserve pace the ports turned on by
+	// setPortPowerBatch, see powerOnStaggered.
+	powerOnStagger time.Duration
+	powerOnReserve float64
+	// maintenance is shared by all views of the service returned by
+	// forHost.
+	maintenance *maintenanceLock
+	// stats backs AdminStatusHandler and is shared like maintenance.
+	stats   *rpcStats
+	aliases portAliases
+	// defaultPort is the port of RPC requests whose route names none.
+	defaultPort string
+	// verifySets makes power changes read the port back after
+	// verifySettle, see verifyPortState.
+	verifySets   bool
+	verifySettle time.Duration
+	// wol wakes the machines powered on, nil unless enabled.
+	wol *wakeOnLAN
+	// preservePoEMode keeps turning on from overriding PoE modes set by
+	// hand, see setPortPoEMode.
+	preservePoEMode bool
+	// pingChecksSwitch makes ping read the device from the controller.
+	pingChecksSwitch bool
+	// statsParse selects what reading the device stats does about
+	// malformed port table rows.
+	statsParse statsParseMode
+	// webhook receives the power changes, nil when none is configured.
+	webhook *webhook
 }
 
-func (b *bmcService) getPort(ctx context.Context, macAddress string, portIdx string) (deviceId string, port unifi.DevicePortOverrides, err error) {
-	deviceId = ""
+// ErrUnknownHost is returned when a request names a host that has no
+// controller configured and there is no default controller.
+var ErrUnknownHost = errors.New("unknown host")
 
-	p, err := strconv.Atoi(portIdx)
+// forHost returns a view of the service bound to the controller configured
+// for host, falling back to the default controller.
+func (b *bmcService) forHost(host string) (*bmcService, error) {
+	key := strings.ToLower(host)
+	c, ok := b.clients[key]
+	if !ok {
+		key = ""
+		c, ok = b.clients[key]
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: no controller is configured for host %q", ErrUnknownHost, host)
+	}
+
+	view := *b
+	view.client = c
+	if site, ok := b.sites[key]; ok {
+		view.site = site
+	}
+	return &view, nil
+}
+
+func (b *bmcService) ForHost(host string) (BMCService, error) {
+	svc, err := b.forHost(host)
 	if err != nil {
-		err = fmt.Errorf("error getting integer value from port %s: %v", portIdx, err)
-		return
+		return nil, err
+	}
+	return svc, nil
+}
+
+func (b *bmcService) Preflight(ctx context.Context) error {
+	for host, c := range b.clients {
+		if err := c.Preflight(ctx); err != nil {
+			if host == "" {
+				return fmt.Errorf("default controller: %w", err)
+			}
+			return fmt.Errorf("controller for host %q: %w", host, err)
+		}
 	}
+	return nil
+}
+
+// closer is implemented by the controller clients that run in the
+// background once logged in.
+type closer interface {
+	Close()
+}
 
-	dev, err := b.client.GetDeviceByMAC(ctx, "default", macAddress)
+func (b *bmcService) Close() {
+	for _, c := range b.clients {
+		if c, ok := c.(closer); ok {
+			c.Close()
+		}
+	}
+}
+
+// getCachedDevice serves read-only lookups from the device cache. Anything
+// that modifies the device must fetch it fresh from the controller instead.
+func (b *bmcService) getCachedDevice(ctx context.Context, macAddress string) (*unifi.Device, error) {
+	if b.devices != nil {
+		if dev, ok := b.devices.get(macAddress); ok {
+			return dev, nil
+		}
+	}
+
+	dev, err := b.getDevice(ctx, macAddress)
 	if err != nil {
-		err = fmt.Errorf("error getting device by MAC Address %s: %v", macAddress, err)
-		return
+		return nil, err
 	}
 
-	deviceId = dev.ID
+	if b.devices != nil {
+		b.devices.put(macAddress, dev)
+	}
+	return dev, nil
+}
 
-	for _, pd := range dev.PortOverrides {
-		if pd.PortIDX == p {
-			port = pd
-			break
+// getCachedStats is getCachedDevice for the stats of the device, which
+// tell ports without PoE apart.
+func (b *bmcService) getCachedStats(ctx context.Context, macAddress string) (*deviceStats, error) {
+	if b.devices != nil {
+		if stats, ok := b.devices.getStats(macAddress); ok {
+			return stats, nil
 		}
 	}
 
-	return
+	stats, err := b.deviceStats(ctx, macAddress, b.statsParse)
+	if err != nil {
+		return nil, err
+	}
+
+	if b.devices != nil {
+		b.devices.putStats(macAddress, stats)
+	}
+	return stats, nil
 }
 
-func (b *bmcService) setPortPower(ctx context.Context, macAddress string, portIdx string, state string) error {
+// checkMAC rejects anything but a MAC address before it is put into a
+// controller URL, so a request cannot make the controller client call a
+// different API path.
+func checkMAC(mac string) error {
+	if hw, err := net.ParseMAC(mac); err != nil || len(hw) != 6 {
+		return fmt.Errorf("%w %q", ErrInvalidMAC, mac)
+	}
+	return nil
+}
+
+// getDevice fetches the device for macAddress from the controller.
+func (b *bmcService) getDevice(ctx context.Context, macAddress string) (*unifi.Device, error) {
+	if err := checkMAC(macAddress); err != nil {
+		return nil, err
+	}
+	return b.client.GetDeviceByMAC(ctx, b.site, macAddress)
+}
+
+func (b *bmcService) updateDevice(ctx context.Context, dev *unifi.Device) error {
+	if b.devices != nil {
+		defer b.devices.invalidate(dev.MAC)
+	}
+
+	_, err := b.client.UpdateDevice(ctx, b.site, dev)
+	return err
+}
+
+// ErrNotSupported is returned for requests UniFi devices cannot honor.
+var ErrNotSupported = errors.New("not supported")
+
+// ErrControllerUnreachable is returned when the controller cannot be
+// connected to at all, as opposed to rejecting a request.
+var ErrControllerUnreachable = errors.New("controller unreachable")
+
+var errSoftPower = fmt.Errorf("soft power off is %w, use %q for a hard power cut", ErrNotSupported, PowerStateOff)
+
+// ErrInvalidMAC is returned for a device MAC address that does not parse.
+var ErrInvalidMAC = errors.New("invalid MAC address")
+
+// ErrPortNotFound is returned when a port or outlet index does not exist on
+// the device.
+var ErrPortNotFound = errors.New("port not found")
+
+// ErrInvalidPort is returned for a port or outlet index that is not a
+// positive number or configured alias.
+var ErrInvalidPort = errors.New("invalid port")
+
+// ErrInvalidState is returned for a power state that is unknown or cannot be
+// set.
+var ErrInvalidState = errors.New("invalid power state")
+
+// portMap translates the logical port numbers used by clients into the
+// physical switch ports they are wired to.
+type portMap struct {
+	ports map[int]int
+	// strict rejects ports missing from ports instead of passing them
+	// through unchanged.
+	strict bool
+}
+
+// newPortMap validates the logical to physical port mapping of the
+// configuration.
+func newPortMap(ports map[int]int, strict bool) (portMap, error) {
+	seen := map[int]int{}
+	for logical, physical := range ports {
+		if logical < 1 || physical < 1 {
+			return portMap{}, fmt.Errorf("portMap: %d: %d must map positive port numbers", logical, physical)
+		}
+		if other, ok := seen[physical]; ok {
+			return portMap{}, fmt.Errorf("portMap: ports %d and %d both map to port %d", other, logical, physical)
+		}
+		seen[physical] = logical
+	}
+	return portMap{ports: ports, strict: strict}, nil
+}
+
+// physical returns the switch port for logical port p.
+func (m portMap) physical(p int) (int, error) {
+	if physical, ok := m.ports[p]; ok {
+		return physical, nil
+	}
+	if m.strict {
+		return 0, fmt.Errorf("%w: port %d is not in the port map", ErrPortNotFound, p)
+	}
+	return p, nil
+}
+
+// portAliases maps port names to logical port numbers.
+type portAliases map[string]int
+
+// newPortAliases validates the port aliases of the configuration. Aliases
+// that are numbers are rejected, as they would hide the port of that number.
+func newPortAliases(aliases map[string]int) (portAliases, error) {
+	for alias, p := range aliases {
+		if alias == "" {
+			return nil, errors.New("portAliases: alias must not be empty")
+		}
+		if _, err := strconv.Atoi(alias); err == nil {
+			return nil, fmt.Errorf("portAliases: alias %q is a port number", alias)
+		}
+		if p < 1 {
+			return nil, fmt.Errorf("portAliases: %s: %d is not a port number", alias, p)
+		}
+	}
+	return aliases, nil
+}
+
+// resolve replaces a port alias in m by its port number, returning the
+// alias, or "" when m names the port by number.
+func (a portAliases) resolve(m Machine) (Machine, string) {
+	p, ok := a[m.PortIdx]
+	if !ok {
+		return m, ""
+	}
+	alias := m.PortIdx
+	m.PortIdx = strconv.Itoa(p)
+	return m, alias
+}
+
+// portIdx parses the port route variable, a port number or alias, and maps
+// it to the switch port.
+func (b *bmcService) portIdx(portIdx string) (int, error) {
+	if p, ok := b.aliases[portIdx]; ok {
+		return b.ports.physical(p)
+	}
+	p, err := parsePortIdx(portIdx)
+	if err != nil {
+		return 0, err
+	}
+	return b.ports.physical(p)
+}
+
+// parsePortIdx converts the port route variable into a port number.
+func parsePortIdx(portIdx string) (int, error) {
 	p, err := strconv.Atoi(portIdx)
 	if err != nil {
-		return fmt.Errorf("error getting integer value from port %s: %v", portIdx, err)
+		return 0, fmt.Errorf("%w: error getting integer value from port %s: %w", ErrInvalidPort, portIdx, err)
 	}
+	if p < 1 {
+		return 0, fmt.Errorf("%w: port %d must be a positive integer", ErrInvalidPort, p)
+	}
+	return p, nil
+}
 
-	dev, err := b.client.GetDeviceByMAC(ctx, "default", macAddress)
+// noOverride reports that dev has no port override for port p. The
+// overrides only list the ports set up on the controller, so this does not
+// tell whether the device has port p at all; see portError.
+func noOverride(dev *unifi.Device, p int) error {
+	return fmt.Errorf("%w: device %s has no override for port %d", ErrPortNotFound, dev.MAC, p)
+}
+
+// portError explains an error of setPoeMode for port p: a port without an
+// override is out of range when the port table of dev does not list it
+// either.
+func (b *bmcService) portError(ctx context.Context, dev *unifi.Device, p int, err error) error {
+	if !errors.Is(err, ErrPortNotFound) {
+		return err
+	}
+	if stats, statsErr := b.getCachedStats(ctx, dev.MAC); statsErr == nil {
+		if _, rangeErr := findPortStat(stats, p); rangeErr != nil {
+			return rangeErr
+		}
+	}
+	return err
+}
+
+// setPoeMode updates the PoE mode of port p on dev to match state. It
+// reports whether the device needs to be pushed back to the controller.
+func setPoeMode(modes poeModeMap, dev *unifi.Device, p int, state string) (changed bool, err error) {
+	st, err := ParsePowerState(state)
 	if err != nil {
-		return fmt.Errorf("error getting device by MAC Address %s: %v", macAddress, err)
+		return false, err
+	}
+	mode, ok := modes[st]
+	if !ok {
+		return false, fmt.Errorf("%w: power state %q cannot be set", ErrInvalidState, state)
 	}
 
 	for i, pd := range dev.PortOverrides {
 		if pd.PortIDX == p {
-			switch state {
-			case "on":
-				if pd.PoeMode == "auto" {
-					return nil
-				}
-				dev.PortOverrides[i].PoeMode = "auto"
-				break
-			case "off":
-				if pd.PoeMode == "off" {
-					return nil
-				}
-				dev.PortOverrides[i].PoeMode = "off"
-				break
+			if pd.PoeMode == mode {
+				return false, nil
 			}
+			dev.PortOverrides[i].PoeMode = mode
+			return true, nil
 		}
 	}
 
-	_, err = b.client.UpdateDevice(ctx, "default", dev)
+	return false, noOverride(dev, p)
+}
+
+// SetPortPower sets the power state of a single switch port. Nothing is
+// sent to the controller when the port is already in that state.
+func (b *bmcService) SetPortPower(ctx context.Context, macAddress string, portIdx string, state string) error {
+	_, err := b.setPortPower(ctx, macAddress, portIdx, state, false)
+	return err
+}
+
+// setPortPower implements SetPortPower and reports the state of the port
+// before and after the change. With force set the device is pushed back to
+// the controller even when the port is already in state. It fails with
+// ErrLocked while the maintenance lock is held.
+func (b *bmcService) setPortPower(ctx context.Context, macAddress string, portIdx string, state string, force bool) (res PowerSetResult, err error) {
+	if err = b.maintenance.check(); err != nil {
+		return PowerSetResult{}, err
+	}
+	switch strings.ToLower(strings.TrimSpace(state)) {
+	case PowerStateReset:
+		res, err = b.resetPort(ctx, macAddress, portIdx)
+	case PowerStateCycle:
+		res, err = b.cyclePort(ctx, macAddress, portIdx)
+	default:
+		res, err = b.setPortMode(ctx, macAddress, portIdx, state, force)
+	}
+	if err == nil {
+		p, _ := b.portIdx(portIdx)
+		res.WakeSent = b.powerChanged(macAddress, portIdx, p, state, res.Previous, res.Current)
+	}
+	return res, err
+}
+
+// powerChanged runs the steps that follow a successful change of the port
+// portIdx, switch port p, to the state current as asked for by state: it
+// wakes the machine when the port was turned on, then records the change
+// and notifies the webhook. It reports whether a wake packet was sent.
+func (b *bmcService) powerChanged(macAddress, portIdx string, p int, state, previous, current string) (wakeSent bool) {
+	// A toggle that turned the port on wakes the machine like on does.
+	requested := strings.ToLower(strings.TrimSpace(state))
+	if requested == PowerStateOn || (requested == PowerStateToggle && current == PoweredOn.String()) {
+		wakeSent = b.wakePort(macAddress, portIdx)
+	}
+	b.recordPower(macAddress, portIdx, state, current)
+	b.notifyPower(macAddress, p, previous, current)
+	return wakeSent
+}
+
+// setPortMode sets the PoE mode of a port for the on, off or toggle state.
+func (b *bmcService) setPortMode(ctx context.Context, macAddress string, portIdx string, state string, force bool) (PowerSetResult, error) {
+	p, err := b.portIdx(portIdx)
+	if err != nil {
+		return PowerSetResult{}, err
+	}
 
+	unlock, err := b.locks.lock(ctx, macAddress)
 	if err != nil {
-		return fmt.Errorf("error updating device: %v", err)
+		return PowerSetResult{}, err
 	}
+	defer unlock()
 
-	return nil
+	dev, err := b.getDevice(ctx, macAddress)
+	if err != nil {
+		return PowerSetResult{}, fmt.Errorf("error getting device by MAC Address %s: %w", macAddress, err)
+	}
+
+	previous := devicePowerState(dev.State, b.modeState(p, portPoeMode(dev, p)))
+	if strings.EqualFold(strings.TrimSpace(state), PowerStateToggle) {
+		if state, err = toggledState(previous); err != nil {
+			return PowerSetResult{}, err
+		}
+	}
+	changed, err := b.setPortPoEMode(dev, p, state)
+	if err != nil {
+		return PowerSetResult{}, b.portError(ctx, dev, p, err)
+	}
+	res := PowerSetResult{
+		Previous: previous.String(),
+		Current:  b.modeState(p, portPoeMode(dev, p)).String(),
+	}
+	if !changed && !force {
+		return res, nil
+	}
+
+	err = b.updateDevice(ctx, dev)
+
+	if err != nil {
+		return PowerSetResult{}, fmt.Errorf("error updating device: %w", err)
+	}
+	if b.verifySets {
+		st, _ := ParsePowerState(state)
+		if err = b.verifyPortState(ctx, macAddress, p, st); err != nil {
+			return PowerSetResult{}, err
+		}
+	}
+
+	return res, nil
+}
+
+// toggledState returns the state that PowerStateToggle sets a port in
+// state st to. Transitional states count as the state they lead to.
+func toggledState(st PowerGetResult) (string, error) {
+	switch st {
+	case PoweredOn, PoweringOn:
+		return PowerStateOff, nil
+	case PoweredOff, PoweringOff:
+		return PowerStateOn, nil
+	}
+	return "", fmt.Errorf("%w: the PoE mode of the port is neither on nor off, it cannot be toggled", ErrInvalidState)
+}
+
+// portPoeMode returns the PoE mode dev sets for port p, or "" when dev has
+// no override for it.
+func portPoeMode(dev *unifi.Device, p int) string {
+	for _, pd := range dev.PortOverrides {
+		if pd.PortIDX == p {
+			return pd.PoeMode
+		}
+	}
+	return ""
+}
+
+// resetPort turns a port off and, after b.resetDwell, on again, so the
+// device behind it gets a full cold restart. A maintenance lock taken in
+// between does not keep the port off.
+func (b *bmcService) resetPort(ctx context.Context, macAddress string, portIdx string) (PowerSetResult, error) {
+	off, err := b.setPortMode(ctx, macAddress, portIdx, PowerStateOff, false)
+	if err != nil {
+		return PowerSetResult{}, err
+	}
+	select {
+	case <-ctx.Done():
+		return PowerSetResult{}, ctx.Err()
+	case <-time.After(b.resetDwell):
+	}
+	on, err := b.setPortMode(ctx, macAddress, portIdx, PowerStateOn, false)
+	if err != nil {
+		return PowerSetResult{}, err
+	}
+	return PowerSetResult{Previous: off.Previous, Current: on.Current}, nil
+}
+
+// cyclePort has the controller bounce PoE on a port. The bounce leaves the
+// port in the state it was in, which is read first.
+func (b *bmcService) cyclePort(ctx context.Context, macAddress string, portIdx string) (PowerSetResult, error) {
+	p, err := b.portIdx(portIdx)
+	if err != nil {
+		return PowerSetResult{}, err
+	}
+	state, err := b.GetPower(ctx, macAddress, portIdx)
+	if err != nil {
+		return PowerSetResult{}, err
+	}
+	if b.devices != nil {
+		defer b.devices.invalidate(macAddress)
+	}
+	if err = b.client.PowerCyclePort(ctx, b.site, macAddress, p); err != nil {
+		return PowerSetResult{}, fmt.Errorf("error power cycling port %d: %w", p, err)
+	}
+	return PowerSetResult{Previous: state, Current: state}, nil
+}
+
+// setPortPowerBatch applies several port power changes with a single device
+// read and a single device update. Ports are processed in order and each one
+// gets its own result, so one bad entry does not fail the rest. When power
+// on is staggered, the ports turned on follow one at a time after that
+// update, see powerOnStaggered, unless it failed. Like setPortPower, the
+// ports are then verified when verifySets is set, and each changed port is
+// woken, recorded and notified, see powerChanged.
+func (b *bmcService) setPortPowerBatch(ctx context.Context, macAddress string, ports []PortPowerSetParams) ([]PortPowerSetResult, error) {
+	if err := b.maintenance.check(); err != nil {
+		return nil, err
+	}
+	unlock, err := b.locks.lock(ctx, macAddress)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	dev, err := b.getDevice(ctx, macAddress)
+	if err != nil {
+		return nil, fmt.Errorf("error getting device by MAC Address %s: %w", macAddress, err)
+	}
+
+	results := make([]PortPowerSetResult, len(ports))
+	// previous holds the state of each port before its entry is applied.
+	previous := make([]string, len(ports))
+	var pending []int
+	var staged []stagedPort
+	for i, pp := range ports {
+		results[i] = PortPowerSetResult{Port: pp.Port, State: pp.State}
+
+		p, setErr := b.ports.physical(pp.Port)
+		if setErr != nil {
+			results[i].Error = setErr.Error()
+			continue
+		}
+		previous[i] = devicePowerState(dev.State, b.modeState(p, portPoeMode(dev, p))).String()
+		if b.staggersPowerOn() {
+			if st, _ := ParsePowerState(pp.State); st == PoweredOn {
+				staged = append(staged, stagedPort{i: i, port: p})
+				continue
+			}
+		}
+		changed, setErr := b.setPortPoEMode(dev, p, pp.State)
+		if setErr != nil {
+			results[i].Error = b.portError(ctx, dev, p, setErr).Error()
+			continue
+		}
+		if changed {
+			pending = append(pending, i)
+		}
+	}
+
+	if len(pending) > 0 {
+		if err = b.updateDevice(ctx, dev); err != nil {
+			// The staged updates would push the failed changes again.
+			for _, i := range pending {
+				results[i].Error = fmt.Sprintf("error updating device: %v", err)
+			}
+			for _, s := range staged {
+				results[s.i].Error = fmt.Sprintf("error updating device: %v", err)
+			}
+			staged = nil
+		}
+	}
+	if len(staged) > 0 {
+		b.powerOnStaggered(ctx, dev, ports, staged, results)
+	}
+
+	// current holds the state each port was set to.
+	current := make([]PowerGetResult, len(ports))
+	want := map[int]PowerGetResult{}
+	for i, res := range results {
+		if res.Error == "" {
+			p, _ := b.ports.physical(res.Port)
+			current[i] = b.modeState(p, portPoeMode(dev, p))
+			want[p] = current[i]
+		}
+	}
+	var verifyErrs map[int]error
+	if b.verifySets && len(want) > 0 && (len(pending) > 0 || len(staged) > 0) {
+		verifyErrs = b.verifyPortStates(ctx, macAddress, want)
+	}
+
+	for i := range results {
+		res := &results[i]
+		if res.Error != "" {
+			continue
+		}
+		p, _ := b.ports.physical(res.Port)
+		if err := verifyErrs[p]; err != nil {
+			res.Error = err.Error()
+			continue
+		}
+		res.WakeSent = b.powerChanged(macAddress, strconv.Itoa(res.Port), p, res.State, previous[i], current[i].String())
+	}
+	return results, nil
 }
 
 func (b *bmcService) GetPower(ctx context.Context, macAddress string, portIdx string) (state string, err error) {
-	_, port, err := b.getPort(ctx, macAddress, portIdx)
+	ps, stats, err := b.getPortStat(ctx, macAddress, portIdx)
 	if err != nil {
-		fmt.Printf("error setting power on for MAC Address %s, Port Index %s: %v", macAddress, portIdx, err)
+		b.logger.Debug("error getting port", "mac", macAddress, "port", portIdx, "error", err)
 		return
 	}
 
-	mode := port.PoeMode
+	return b.livePortState(stats.State, ps.PortIdx, ps.PortPoE, ps.PoEMode).String(), nil
+}
 
-	if mode == "auto" {
-		state = "on"
-	} else if mode == "off" {
-		state = "off"
+// getPortStat returns the port table row of port portIdx together with the
+// stats of the device, reusing recently read stats.
+func (b *bmcService) getPortStat(ctx context.Context, macAddress string, portIdx string) (portStat, *deviceStats, error) {
+	p, err := b.portIdx(portIdx)
+	if err != nil {
+		return portStat{}, nil, err
 	}
-
-	return
+	if err = checkMAC(macAddress); err != nil {
+		return portStat{}, nil, err
+	}
+	stats, err := b.getCachedStats(ctx, macAddress)
+	if err != nil {
+		return portStat{}, nil, err
+	}
+	ps, err := findPortStat(stats, p)
+	return ps, stats, err
 }
 
 func getMachine(r *http.Request) Machine {
@@ -114,70 +722,488 @@ func getMachine(r *http.Request) Machine {
 	}
 }
 
+// errorStatus maps an error of a service call to the HTTP status reported
+// to the client. Requests the client got wrong, such as an unknown port or
+// power state, are 4xx. Everything else failed on the way to or at the
+// controller and is 5xx, 502 when there is no more specific status.
+func errorStatus(err error) int {
+	var notFound *unifi.NotFoundError
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, ErrControllerUnreachable), errors.Is(err, ErrBusy):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, ErrNotSupported):
+		return http.StatusNotImplemented
+	case errors.Is(err, ErrUnknownHost),
+		errors.Is(err, ErrInvalidMAC),
+		errors.Is(err, ErrInvalidPort),
+		errors.Is(err, ErrInvalidState):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrPortNotFound), errors.As(err, &notFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrLocked):
+		return http.StatusLocked
+	case errors.Is(err, ErrReadOnly):
+		return http.StatusForbidden
+	}
+	return http.StatusBadGateway
+}
+
+// Reasons reported in ResponseError.Reason. They are stable, unlike the
+// messages, so clients can branch on them.
+const (
+	ReasonTimeout               = "timeout"
+	ReasonNotSupported          = "not_supported"
+	ReasonControllerUnreachable = "controller_unreachable"
+	ReasonInvalidRequest        = "invalid_request"
+	ReasonRequestTooLarge       = "request_too_large"
+	ReasonInvalidParams         = "invalid_params"
+	ReasonUnknownMethod         = "unknown_method"
+	ReasonUnknownHost           = "unknown_host"
+	ReasonInvalidMAC            = "invalid_mac"
+	ReasonInvalidPort           = "invalid_port"
+	ReasonDeviceNotFound        = "device_not_found"
+	ReasonPortNotFound          = "port_not_found"
+	ReasonCallFailed            = "call_failed"
+	ReasonInternal              = "internal"
+	ReasonNoRoute               = "no_route"
+	ReasonMethodNotAllowed      = "method_not_allowed"
+	ReasonUnauthorized          = "unauthorized"
+	ReasonUnsupportedMediaType  = "unsupported_media_type"
+	ReasonRateLimited           = "rate_limited"
+	ReasonLocked                = "locked"
+	ReasonBusy                  = "busy"
+	ReasonReadOnly              = "read_only"
+)
+
+// errorReason maps an error of a service call to the machine-readable
+// reason reported to the client, ReasonCallFailed when there is no more
+// specific one.
+func errorReason(err error) string {
+	var notFound *unifi.NotFoundError
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return ReasonTimeout
+	case errors.Is(err, ErrControllerUnreachable):
+		return ReasonControllerUnreachable
+	case errors.Is(err, ErrBusy):
+		return ReasonBusy
+	case errors.Is(err, ErrNotSupported):
+		return ReasonNotSupported
+	case errors.Is(err, ErrUnknownHost):
+		return ReasonUnknownHost
+	case errors.Is(err, ErrInvalidMAC):
+		return ReasonInvalidMAC
+	case errors.Is(err, ErrInvalidPort):
+		return ReasonInvalidPort
+	case errors.Is(err, ErrInvalidState):
+		return ReasonInvalidParams
+	case errors.Is(err, ErrPortNotFound):
+		return ReasonPortNotFound
+	case errors.Is(err, ErrLocked):
+		return ReasonLocked
+	case errors.Is(err, ErrReadOnly):
+		return ReasonReadOnly
+	case errors.As(err, &notFound):
+		return ReasonDeviceNotFound
+	}
+	return ReasonCallFailed
+}
+
+// writeCallError reports a failed service call, with the status and reason
+// derived from err.
+func writeCallError(w http.ResponseWriter, rp ResponsePayload, err error, message string) {
+	writeResponseError(w, rp, &ResponseError{
+		Code:    errorStatus(err),
+		Message: message,
+		Reason:  errorReason(err),
+	})
+}
+
+// failCall logs a failed service call and reports it to the client like
+// writeCallError.
+func failCall(w http.ResponseWriter, rp ResponsePayload, logger *slog.Logger, err error, msg string) {
+	logger.Error(msg)
+	writeCallError(w, rp, err, msg)
+}
+
+func writeError(w http.ResponseWriter, rp ResponsePayload, status int, reason, message string) {
+	writeResponseError(w, rp, &ResponseError{
+		Code:    status,
+		Message: message,
+		Reason:  reason,
+	})
+}
+
+func writeResponseError(w http.ResponseWriter, rp ResponsePayload, e *ResponseError) {
+	rp.Error = e
+	by, _ := json.Marshal(rp)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(e.Code)
+	w.Write(by)
+}
+
+// decodeRequest decodes the JSON request body into req.
+func (b *bmcService) decodeRequest(r *http.Request, req any) error {
+	dec := json.NewDecoder(r.Body)
+	if b.strict {
+		dec.DisallowUnknownFields()
+	}
+	if err := dec.Decode(req); err != nil {
+		return fmt.Errorf("error decoding request: %w", err)
+	}
+	return nil
+}
+
+// requestErrorStatus maps a decodeRequest error to the HTTP status reported
+// to the client.
+func requestErrorStatus(err error) int {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+// writeRequestError reports a request body that could not be decoded.
+func writeRequestError(w http.ResponseWriter, err error) {
+	reason := ReasonInvalidRequest
+	status := requestErrorStatus(err)
+	if status == http.StatusRequestEntityTooLarge {
+		reason = ReasonRequestTooLarge
+	}
+	writeError(w, ResponsePayload{}, status, reason, err.Error())
+}
+
+// rpcLogger annotates the request span with the RPC call and returns the
+// request logger scoped to it.
+func (b *bmcService) rpcLogger(r *http.Request, req RequestPayload, machine Machine) *slog.Logger {
+	trace.SpanFromContext(r.Context()).SetAttributes(
+		attribute.String("rpc.method", string(req.Method)),
+		attribute.String("mac", machine.MacAddress),
+		attribute.String("port", machine.PortIdx),
+	)
+	return b.requestLogger(r).With("method", req.Method, "mac", machine.MacAddress, "port", machine.PortIdx, "host", req.Host)
+}
+
+// beginRPC starts an RPC request, counting it in flight until end is called,
+// and decodes its payload. A payload that does not decode is answered here
+// and ok is false.
+func (b *bmcService) beginRPC(w http.ResponseWriter, r *http.Request) (req RequestPayload, end func(), ok bool) {
+	end = b.stats.begin()
+	if err := b.decodeRequest(r, &req); err != nil {
+		writeRequestError(w, err)
+		return req, end, false
+	}
+	return req, end, true
+}
+
+// endRPC logs a handled RPC request and writes its response.
+func endRPC(w http.ResponseWriter, logger *slog.Logger, rp ResponsePayload) {
+	logger.Info("rpc request handled")
+	by, _ := json.Marshal(rp)
+	w.Write(by)
+}
+
+// requestPort returns the port an RPC request addresses: the port of its
+// setPowerState params, then routePort, then the default port.
+func (b *bmcService) requestPort(routePort string, params any) string {
+	if p, ok := params.(*PowerSetParams); ok && p.Port != 0 {
+		return strconv.Itoa(p.Port)
+	}
+	if routePort != "" {
+		return routePort
+	}
+	return b.defaultPort
+}
+
+// RPCHandler serves the port RPC endpoint. Routes without a {port}
+// variable address the configured default port, and setPowerState params
+// may name the port over both, see requestPort.
 func (b *bmcService) RPCHandler(w http.ResponseWriter, r *http.Request) {
-	req := RequestPayload{}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+	req, end, ok := b.beginRPC(w, r)
+	defer end()
+	if !ok {
 		return
 	}
 
 	machine := getMachine(r)
+	rp := ResponsePayload{ID: req.ID, Host: req.Host}
+	// The params are decoded first as they may name the port.
+	params, ok := b.methodParams(w, rp, b.rpcLogger(r, req, machine), portMethods, req)
+	if !ok {
+		return
+	}
+	machine.PortIdx = b.requestPort(machine.PortIdx, params)
+	machine, alias := b.aliases.resolve(machine)
+	logger := b.rpcLogger(r, req, machine)
+	if spec, _ := portMethods.lookup(req.Method); machine.PortIdx == "" && !spec.noPort {
+		logger.Error("request names no port")
+		writeError(w, rp, http.StatusBadRequest, ReasonInvalidPort, "the request names no port and no default port is configured")
+		return
+	}
+	if alias != "" {
+		logger = logger.With("portAlias", alias)
+		rp.Port, rp.PortAlias = machine.PortIdx, alias
+	}
 
-	rp := ResponsePayload{
-		ID:   req.ID,
-		Host: req.Host,
+	b, hostErr := b.forHost(req.Host)
+	if hostErr != nil {
+		logger.Error("error selecting controller", "error", hostErr)
+		writeError(w, rp, http.StatusBadRequest, ReasonUnknownHost, hostErr.Error())
+		return
 	}
+
 	switch req.Method {
 	case PowerGetMethod:
-		state, err := b.GetPower(r.Context(), machine.MacAddress, machine.PortIdx)
+		state, stale, err := b.powerOrLastKnown(r.Context(), machine)
+		b.stats.observe(machine, state, err)
 		if err != nil {
-			log.Fatalf("error getting power state for MAC Address %s, Port Index %s: %v", machine.MacAddress, machine.PortIdx, err)
-			fmt.Fprintf(w, "error getting power state for MAC Address %s, Port Index %s: %v", machine.MacAddress, machine.PortIdx, err)
-			w.WriteHeader(http.StatusBadRequest)
+			failCall(w, rp, logger, err, fmt.Sprintf("error getting power state for MAC Address %s, Port Index %s: %v", machine.MacAddress, machine.PortIdx, err))
 			return
 		}
-		rp.Result = state
+		rp.Result, rp.Stale = state, stale
 	case PowerSetMethod:
-		p, ok := req.Params.(PowerSetParams)
-		if !ok {
-			log.Fatalf("error asserting params to PowerSetParams")
-			fmt.Fprintf(w, "error asserting params to PowerSetParams")
-			w.WriteHeader(http.StatusBadRequest)
-		}
-		state := p.State
-		err := b.setPortPower(r.Context(), machine.MacAddress, machine.PortIdx, state)
+		p := params.(*PowerSetParams)
+		res, err := b.setPortPower(r.Context(), machine.MacAddress, machine.PortIdx, p.State, p.Force)
+		b.stats.observe(machine, res.Current, err)
+		if err != nil {
+			failCall(w, rp, logger, err, fmt.Sprintf("error setting power on for MAC Address %s, Port Index %s: %v", machine.MacAddress, machine.PortIdx, err))
+			return
+		}
+		rp.Result = res
+	case PowerSetBatchMethod:
+		p := params.(*PowerSetBatchParams)
+		results, err := b.setPortPowerBatch(r.Context(), machine.MacAddress, p.Ports)
+		if err != nil {
+			failCall(w, rp, logger, err, fmt.Sprintf("error setting power for MAC Address %s: %v", machine.MacAddress, err))
+			return
+		}
+		rp.Result = results
+	case StatusMethod:
+		res, err := b.getPortStatus(r.Context(), machine.MacAddress, machine.PortIdx)
+		b.stats.observe(machine, res.State, err)
+		if err != nil {
+			failCall(w, rp, logger, err, fmt.Sprintf("error getting status for MAC Address %s, Port Index %s: %v", machine.MacAddress, machine.PortIdx, err))
+			return
+		}
+		rp.Result = res
+	case PowerCycleAllMethod:
+		p := params.(*PowerCycleAllParams)
+		results, err := b.powerCycleAll(r.Context(), machine.MacAddress, p.Exclude)
 		if err != nil {
-			log.Fatalf("error setting power on for MAC Address %s, Port Index %s: %v", machine.MacAddress, machine.PortIdx, err)
-			fmt.Fprintf(w, "error setting power on for MAC Address %s, Port Index %s: %v", machine.MacAddress, machine.PortIdx, err)
-			w.WriteHeader(http.StatusBadRequest)
+			failCall(w, rp, logger, err, fmt.Sprintf("error power cycling ports of MAC Address %s: %v", machine.MacAddress, err))
 			return
 		}
+		rp.Result = results
+	case PoEStatusMethod:
+		res, err := b.getPoEStatus(r.Context(), machine.MacAddress)
+		if err != nil {
+			failCall(w, rp, logger, err, fmt.Sprintf("error getting PoE status for MAC Address %s: %v", machine.MacAddress, err))
+			return
+		}
+		rp.Result = res
 	case BootDeviceMethod:
-		p, ok := req.Params.(BootDeviceParams)
-		if !ok {
-			log.Fatalf("error asserting params to BootDeviceParams")
-			fmt.Fprintf(w, "error asserting params to BootDeviceParams")
-			w.WriteHeader(http.StatusBadRequest)
+		p := *params.(*BootDeviceParams)
+		if err := p.validate(); err != nil {
+			logger.Error("invalid boot device", "error", err)
+			writeError(w, rp, http.StatusBadRequest, ReasonInvalidParams, err.Error())
+			return
 		}
-		fmt.Fprintf(w, "boot device request for MAC Address %s, Port Index %s, Device %s, Persistent %t, EFIBoot %t", machine.MacAddress, machine.PortIdx, p.Device, p.Persistent, p.EFIBoot)
-
+		if b.maintenance.readOnly {
+			failCall(w, rp, logger, ErrReadOnly, ErrReadOnly.Error())
+			return
+		}
+		if err := b.bootDevices.Store(machine, p); err != nil {
+			msg := fmt.Sprintf("error storing boot device for MAC Address %s, Port Index %s: %v", machine.MacAddress, machine.PortIdx, err)
+			logger.Error(msg)
+			writeError(w, rp, http.StatusInternalServerError, ReasonInternal, msg)
+			return
+		}
+		rp.Result = BootDeviceResult{
+			Acknowledged: true,
+			Device:       p.Device,
+			Persistent:   p.Persistent,
+			EFIBoot:      p.EFIBoot,
+			Message:      "the boot device is recorded but not applied, UniFi devices only control power",
+		}
+	case BootDeviceGetMethod:
+		p, _ := b.bootDevices.Get(machine)
+		rp.Result = p
 	case PingMethod:
-
-		rp.Result = "pong"
+		res, err := b.ping(r.Context(), machine.MacAddress)
+		if err != nil {
+			failCall(w, rp, logger, err, fmt.Sprintf("error checking MAC Address %s: %v", machine.MacAddress, err))
+			return
+		}
+		rp.Result = res
+	case SwitchInfoMethod:
+		res, err := b.GetSwitchInfo(r.Context(), machine.MacAddress)
+		if err != nil {
+			failCall(w, rp, logger, err, fmt.Sprintf("error getting switch info for MAC Address %s: %v", machine.MacAddress, err))
+			return
+		}
+		rp.Result = res
 	default:
-		w.WriteHeader(http.StatusNotFound)
+		logger.Warn("unknown rpc method")
+		writeError(w, rp, http.StatusNotFound, ReasonUnknownMethod, fmt.Sprintf("unknown method %q", req.Method))
+		return
 	}
-	by, _ := json.Marshal(rp)
-	w.Write(by)
+	endRPC(w, logger, rp)
 }
 
-func NewBMCService(cfg config.Config) BMCService {
-	return &bmcService{
-		client: &lazyClient{
-			user:     cfg.Username,
-			pass:     cfg.Password,
-			baseURL:  cfg.APIEndpoint,
-			insecure: true,
-		},
+func NewBMCService(cfg config.Config, logger *slog.Logger) (BMCService, error) {
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	bootDevices, err := newBootDeviceStore(cfg.BootDeviceFile)
+	if err != nil {
+		return nil, err
+	}
+
+	history, err := newPowerHistory(cfg.StateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	poeModes, err := newPoEModeMap(cfg.PoEModes)
+	if err != nil {
+		return nil, err
+	}
+	portOnModes, err := newPortOnModes(cfg.PoEOnModes, poeModes)
+	if err != nil {
+		return nil, err
+	}
+
+	ports, err := newPortMap(cfg.PortMap, cfg.StrictPortMap)
+	if err != nil {
+		return nil, err
+	}
+
+	aliases, err := newPortAliases(cfg.PortAliases)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.DefaultPort != "" {
+		if _, ok := aliases[cfg.DefaultPort]; !ok {
+			if _, err = parsePortIdx(cfg.DefaultPort); err != nil {
+				return nil, fmt.Errorf("defaultPort: %w", err)
+			}
+		}
+	}
+	var wol *wakeOnLAN
+	if cfg.EnableWoL {
+		if wol, err = newWakeOnLAN(cfg.WoLMACs, cfg.WoLAddress); err != nil {
+			return nil, err
+		}
+	}
+	statsParse, err := parseStatsParseMode(cfg.StatsParseMode, parseStrict)
+	if err != nil {
+		return nil, fmt.Errorf("statsParseMode: %w", err)
+	}
+	var hook *webhook
+	if cfg.WebhookURL != "" {
+		if hook, err = newWebhook(cfg.WebhookURL, cfg.WebhookTimeout, cfg.WebhookRetries, logger); err != nil {
+			return nil, err
+		}
 	}
+
+	var rootCAs *x509.CertPool
+	if cfg.CABundle != "" {
+		if rootCAs, err = loadCABundle(cfg.CABundle); err != nil {
+			return nil, err
+		}
+	}
+	var fingerprint []byte
+	if cfg.CertFingerprint != "" {
+		if fingerprint, err = parseCertFingerprint(cfg.CertFingerprint); err != nil {
+			return nil, err
+		}
+	}
+	insecure := cfg.CABundle == ""
+	if cfg.Insecure != nil {
+		insecure = *cfg.Insecure
+	}
+
+	limiter := newCallLimiter(cfg.MaxConcurrentCalls, cfg.MaxCallWait)
+	clients := map[string]unifiClient{}
+	newClient := func(user, pass, endpoint string) unifiClient {
+		var c unifiClient = &lazyClient{
+			logger:            logger,
+			user:              user,
+			pass:              pass,
+			baseURL:           endpoint,
+			insecure:          insecure,
+			rootCAs:           rootCAs,
+			certFingerprint:   fingerprint,
+			maxRetries:        cfg.MaxRetries,
+			callTimeout:       cfg.CallTimeout,
+			keepAliveInterval: cfg.KeepAliveInterval,
+			limiter:           limiter,
+		}
+		if cfg.DryRun {
+			c = &dryRunClient{unifiClient: c, logger: logger}
+		}
+		return c
+	}
+	site := cfg.Site
+	if site == "" {
+		site = config.Default().Site
+	}
+	sites := map[string]string{}
+	if cfg.APIEndpoint != "" {
+		clients[""] = newClient(cfg.Username, cfg.Password, cfg.APIEndpoint)
+		sites[""] = site
+	}
+	for _, c := range cfg.Controllers {
+		h := strings.ToLower(c.Host)
+		clients[h] = newClient(c.Username, c.Password, c.APIEndpoint)
+		sites[h] = site
+		if c.Site != "" {
+			sites[h] = c.Site
+		}
+	}
+
+	watchInterval := cfg.WatchInterval
+	if watchInterval <= 0 {
+		watchInterval = config.Default().WatchInterval
+	}
+
+	return &bmcService{
+		logger:        logger,
+		client:        clients[""],
+		clients:       clients,
+		site:          site,
+		sites:         sites,
+		strict:        cfg.StrictRequests,
+		poeModes:      poeModes,
+		portOnModes:   portOnModes,
+		ports:         ports,
+		resetDwell:    cfg.ResetDwell,
+		criticalPorts: cfg.CriticalPorts,
+		cycleStagger:  cfg.CycleStagger,
+		locks:         newDeviceLocks(),
+		devices:       newDeviceCache(cfg.PoECacheTTL),
+		switchInfo:    newSwitchInfoCache(),
+		bootDevices:   bootDevices,
+		history:       history,
+		staleFallback: cfg.StaleFallback,
+		watcher:       newPowerWatcher(watchInterval, logger),
+		maintenance:   &maintenanceLock{readOnly: cfg.ReadOnly},
+		stats:         newRPCStats(),
+		aliases:       aliases,
+
+		pingChecksSwitch: cfg.PingChecksSwitch,
+		powerOnStagger:   cfg.PowerOnStagger,
+		powerOnReserve:   cfg.PowerOnReserveWatts,
+		statsParse:       statsParse,
+		webhook:          hook,
+		defaultPort:      cfg.DefaultPort,
+		verifySets:       cfg.VerifySets,
+		verifySettle:     cfg.VerifySettle,
+		wol:              wol,
+		preservePoEMode:  cfg.PreservePoEMode,
+	}, nil
 }
@@ -0,0 +1,900 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/paultyng/go-unifi/unifi"
+
+	"github.com/ubiquiti-community/unifi-rpc/pkg/config"
+)
+
+type fakeClient struct {
+	device    *unifi.Device
+	stats     *deviceStats
+	err       error
+	block     bool
+	updated   *unifi.Device
+	updateErr error
+	// updates and cycled record every UpdateDevice and PowerCyclePort
+	// call in order.
+	updates []*unifi.Device
+	cycled  []int
+}
+
+func (f *fakeClient) GetDeviceByMAC(ctx context.Context, _, _ string) (*unifi.Device, error) {
+	if f.block {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	if f.err != nil {
+		return nil, f.err
+	}
+	return copyDevice(f.device), nil
+}
+
+func (f *fakeClient) GetDeviceStats(ctx context.Context, _, _ string) (*deviceStats, error) {
+	if f.block {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	if f.err != nil {
+		return nil, f.err
+	}
+	if f.stats == nil {
+		return statsOf(f.device), nil
+	}
+	return copyStats(f.stats), nil
+}
+
+// statsOf returns the stats the controller reports for d when every port
+// has PoE.
+func statsOf(d *unifi.Device) *deviceStats {
+	stats := &deviceStats{MAC: d.MAC, State: d.State}
+	for _, p := range d.PortOverrides {
+		stats.PortTable = append(stats.PortTable, portStat{PortIdx: p.PortIDX, PortPoE: true, PoEMode: p.PoeMode})
+	}
+	return stats
+}
+
+func (f *fakeClient) UpdateDevice(_ context.Context, _ string, d *unifi.Device) (*unifi.Device, error) {
+	if f.updateErr != nil {
+		return nil, f.updateErr
+	}
+	f.updated = d
+	f.device = copyDevice(d)
+	f.updates = append(f.updates, copyDevice(d))
+	return d, nil
+}
+
+func (f *fakeClient) GetRawDeviceStats(ctx context.Context, site, mac string) ([]byte, error) {
+	stats, err := f.GetDeviceStats(ctx, site, mac)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(map[string]any{"data": []*deviceStats{stats}})
+}
+
+func (f *fakeClient) PowerCyclePort(_ context.Context, _, _ string, port int) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.cycled = append(f.cycled, port)
+	return nil
+}
+
+func (f *fakeClient) Preflight(context.Context) error {
+	return f.err
+}
+
+// newTestService builds a bmcService around client. A nil cache disables
+// device caching.
+func newTestService(client unifiClient, cache *deviceCache) *bmcService {
+	if cache == nil {
+		cache = newDeviceCache(0)
+	}
+	bootDevices, _ := newBootDeviceStore("")
+	history, _ := newPowerHistory("")
+	return &bmcService{
+		logger:      slog.New(slog.NewTextHandler(io.Discard, nil)),
+		client:      client,
+		clients:     map[string]unifiClient{"": client},
+		site:        "default",
+		poeModes:    defaultPoEModes,
+		locks:       newDeviceLocks(),
+		devices:     cache,
+		bootDevices: bootDevices,
+		history:     history,
+		maintenance: &maintenanceLock{},
+	}
+}
+
+func newTestDevice(modes ...string) *unifi.Device {
+	d := &unifi.Device{ID: "device-id", MAC: "aa:bb:cc:dd:ee:ff"}
+	for i, m := range modes {
+		d.PortOverrides = append(d.PortOverrides, unifi.DevicePortOverrides{PortIDX: i + 1, PoeMode: m})
+	}
+	return d
+}
+
+func serveRPC(ctx context.Context, t *testing.T, svc *bmcService, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := mux.NewRouter()
+	r.HandleFunc("/device/{mac}/port/{port}/rpc", svc.RPCHandler).Methods("POST")
+
+	req := httptest.NewRequest(http.MethodPost, "/device/aa:bb:cc:dd:ee:ff/port/1/rpc", strings.NewReader(body)).WithContext(ctx)
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	return rec
+}
+
+// responseReason decodes the reason of the error answer in rec.
+func responseReason(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	var rp ResponsePayload
+	if err := json.Unmarshal(rec.Body.Bytes(), &rp); err != nil {
+		t.Fatalf("error decoding %s: %v", rec.Body, err)
+	}
+	if rp.Error == nil {
+		t.Fatalf("response %s has no error", rec.Body)
+	}
+	return rp.Error.Reason
+}
+
+func TestRPCHandler_ErrorReasons(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		body string
+		// noDefault configures only a controller for rack1.
+		noDefault  bool
+		wantStatus int
+		wantReason string
+	}{
+		{name: "invalid JSON", body: `{"id":1,`, wantStatus: http.StatusBadRequest, wantReason: ReasonInvalidRequest},
+		{name: "invalid params", body: `{"id":1,"method":"setPowerState","params":{"state":1}}`, wantStatus: http.StatusBadRequest, wantReason: ReasonInvalidParams},
+		{name: "unknown method", body: `{"id":1,"method":"setVirtualMedia"}`, wantStatus: http.StatusNotFound, wantReason: ReasonUnknownMethod},
+		{name: "unknown host", body: `{"id":1,"method":"getPowerState","host":"rack9"}`, noDefault: true, wantStatus: http.StatusBadRequest, wantReason: ReasonUnknownHost},
+		{name: "device not found", err: &unifi.NotFoundError{}, body: `{"id":1,"method":"getPowerState"}`, wantStatus: http.StatusNotFound, wantReason: ReasonDeviceNotFound},
+		{name: "timeout", err: context.DeadlineExceeded, body: `{"id":1,"method":"getPowerState"}`, wantStatus: http.StatusGatewayTimeout, wantReason: ReasonTimeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := newTestService(&fakeClient{device: newTestDevice("auto"), err: tt.err}, nil)
+			if tt.noDefault {
+				svc.clients = map[string]unifiClient{"rack1": svc.client}
+			}
+			rec := serveRPC(context.Background(), t, svc, tt.body)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := responseReason(t, rec); got != tt.wantReason {
+				t.Errorf("reason = %q, want %q", got, tt.wantReason)
+			}
+		})
+	}
+}
+
+// TestRPCHandler_ErrorStatus pins the status of each kind of failed call:
+// 4xx for requests the client got wrong, 5xx for controller failures.
+func TestRPCHandler_ErrorStatus(t *testing.T) {
+	tests := []struct {
+		name       string
+		port       string
+		body       string
+		updateErr  error
+		wantStatus int
+		wantReason string
+	}{
+		{name: "unknown state", port: "1", body: `{"id":1,"method":"setPowerState","params":{"state":"sideways"}}`, wantStatus: http.StatusBadRequest, wantReason: ReasonInvalidParams},
+		{name: "soft state", port: "1", body: `{"id":1,"method":"setPowerState","params":{"state":"soft"}}`, wantStatus: http.StatusNotImplemented, wantReason: ReasonNotSupported},
+		{name: "invalid port", port: "uplink", body: `{"id":1,"method":"getPowerState"}`, wantStatus: http.StatusBadRequest, wantReason: ReasonInvalidPort},
+		{name: "port out of range", port: "9", body: `{"id":1,"method":"getPowerState"}`, wantStatus: http.StatusNotFound, wantReason: ReasonPortNotFound},
+		{name: "update rejected", port: "1", body: `{"id":1,"method":"setPowerState","params":{"state":"off"}}`, updateErr: errors.New("api.err.Invalid"), wantStatus: http.StatusBadGateway, wantReason: ReasonCallFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := newTestService(&fakeClient{device: newTestDevice("auto"), updateErr: tt.updateErr}, nil)
+			r := mux.NewRouter()
+			r.HandleFunc("/device/{mac}/port/{port}/rpc", svc.RPCHandler).Methods("POST")
+			req := httptest.NewRequest(http.MethodPost, "/device/aa:bb:cc:dd:ee:ff/port/"+tt.port+"/rpc", strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if got := responseReason(t, rec); got != tt.wantReason {
+				t.Errorf("reason = %q, want %q", got, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestRPCHandler_Timeout(t *testing.T) {
+	svc := newTestService(&fakeClient{block: true}, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	rec := serveRPC(ctx, t, svc, `{"id":1,"method":"getPowerState"}`)
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusGatewayTimeout)
+	}
+}
+
+func TestRPCHandler_PowerGet(t *testing.T) {
+	svc := newTestService(&fakeClient{device: newTestDevice("auto")}, nil)
+
+	rec := serveRPC(context.Background(), t, svc, `{"id":1,"method":"getPowerState"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if want := `"result":"on"`; !strings.Contains(rec.Body.String(), want) {
+		t.Errorf("body = %s, want it to contain %s", rec.Body.String(), want)
+	}
+}
+
+func TestRPCHandler_PowerSetReportsPrevious(t *testing.T) {
+	fc := &fakeClient{device: newTestDevice("auto")}
+	svc := newTestService(fc, nil)
+
+	rec := serveRPC(context.Background(), t, svc, `{"id":1,"method":"setPowerState","params":{"state":"off"}}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	if want := `"result":{"previous":"on","current":"off"}`; !strings.Contains(rec.Body.String(), want) {
+		t.Errorf("body = %s, want it to contain %s", rec.Body.String(), want)
+	}
+
+	rec = serveRPC(context.Background(), t, svc, `{"id":2,"method":"setPowerState","params":{"state":"off"}}`)
+	if want := `"result":{"previous":"off","current":"off"}`; !strings.Contains(rec.Body.String(), want) {
+		t.Errorf("repeated body = %s, want it to contain %s", rec.Body.String(), want)
+	}
+	if len(fc.updates) != 1 {
+		t.Errorf("device was updated %d times, want 1", len(fc.updates))
+	}
+}
+
+func TestRPCHandler_PowerSetToggle(t *testing.T) {
+	tests := []struct {
+		name         string
+		mode         string
+		provisioning bool
+		wantCode     int
+		wantResult   string
+		wantMode     string
+	}{
+		{name: "on to off", mode: "auto", wantCode: http.StatusOK, wantResult: `{"previous":"on","current":"off"}`, wantMode: "off"},
+		{name: "off to on", mode: "off", wantCode: http.StatusOK, wantResult: `{"previous":"off","current":"on"}`, wantMode: "auto"},
+		{name: "powering on to off", mode: "auto", provisioning: true, wantCode: http.StatusOK, wantResult: `{"previous":"powering on","current":"off"}`, wantMode: "off"},
+		{name: "mode set by hand", mode: "pasv24", wantCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dev := newTestDevice(tt.mode)
+			if tt.provisioning {
+				dev.State = unifi.DeviceStateProvisioning
+			}
+			fc := &fakeClient{device: dev}
+			svc := newTestService(fc, nil)
+
+			rec := serveRPC(context.Background(), t, svc, `{"id":1,"method":"setPowerState","params":{"state":"toggle"}}`)
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantCode, rec.Body)
+			}
+			if tt.wantCode != http.StatusOK {
+				if len(fc.updates) != 0 {
+					t.Errorf("device was updated %d times, want 0", len(fc.updates))
+				}
+				return
+			}
+			if want := `"result":` + tt.wantResult; !strings.Contains(rec.Body.String(), want) {
+				t.Errorf("body = %s, want it to contain %s", rec.Body, want)
+			}
+			if len(fc.updates) != 1 || fc.updates[0].PortOverrides[0].PoeMode != tt.wantMode {
+				t.Errorf("updates = %+v, want a single update to PoE mode %q", fc.updates, tt.wantMode)
+			}
+		})
+	}
+}
+
+func TestRPCHandler_PowerGetProvisioning(t *testing.T) {
+	dev := newTestDevice("off")
+	dev.State = unifi.DeviceStateProvisioning
+	svc := newTestService(&fakeClient{device: dev}, nil)
+
+	rec := serveRPC(context.Background(), t, svc, `{"id":1,"method":"getPowerState"}`)
+	if want := `"result":"powering off"`; !strings.Contains(rec.Body.String(), want) {
+		t.Errorf("body = %s, want it to contain %s", rec.Body.String(), want)
+	}
+}
+
+func TestRPCHandler_BootDevice(t *testing.T) {
+	svc := newTestService(&fakeClient{device: newTestDevice("auto")}, nil)
+	store := svc.bootDevices
+
+	rec := serveRPC(context.Background(), t, svc, `{"id":1,"method":"setBootDevice","params":{"device":"pxe","efiBoot":true}}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("set status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	var set struct {
+		Result BootDeviceResult `json:"result"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &set); err != nil {
+		t.Fatal(err)
+	}
+	if r := set.Result; !r.Acknowledged || r.Supported || r.Device != "pxe" || !r.EFIBoot || r.Message == "" {
+		t.Errorf("set result = %+v, want an acknowledged but unsupported pxe boot", r)
+	}
+
+	rec = serveRPC(context.Background(), t, svc, `{"id":2,"method":"getBootDevice"}`)
+	if want := `"result":{"device":"pxe","persistent":false,"efiBoot":true}`; !strings.Contains(rec.Body.String(), want) {
+		t.Errorf("get body = %s, want it to contain %s", rec.Body.String(), want)
+	}
+
+	rec = serveRPC(context.Background(), t, svc, `{"id":3,"method":"setBootDevice","params":{"device":"floppy"}}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown device: status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if got, _ := store.Get(Machine{MacAddress: "aa:bb:cc:dd:ee:ff", PortIdx: "1"}); got.Device != "pxe" {
+		t.Errorf("unknown device replaced the stored boot device with %q", got.Device)
+	}
+
+	serveRPC(context.Background(), t, svc, `{"id":4,"method":"setBootDevice","params":{"device":""}}`)
+	if _, ok := store.Get(Machine{MacAddress: "aa:bb:cc:dd:ee:ff", PortIdx: "1"}); ok {
+		t.Error("empty device did not clear the stored boot device")
+	}
+}
+
+func TestSetPortPowerBatch_PartialFailure(t *testing.T) {
+	fc := &fakeClient{device: newTestDevice("auto", "auto", "off")}
+	svc := newTestService(fc, nil)
+
+	results, err := svc.setPortPowerBatch(context.Background(), "aa:bb:cc:dd:ee:ff", []PortPowerSetParams{
+		{Port: 1, State: "off"},
+		{Port: 9, State: "off"},
+		{Port: 3, State: "on"},
+		{Port: 2, State: "bogus"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantErr := []bool{false, true, false, true}
+	for i, r := range results {
+		if (r.Error != "") != wantErr[i] {
+			t.Errorf("results[%d] = %+v, want error %v", i, r, wantErr[i])
+		}
+	}
+
+	if fc.updated == nil {
+		t.Fatal("device was not updated")
+	}
+	if got := fc.updated.PortOverrides[0].PoeMode; got != "off" {
+		t.Errorf("port 1 mode = %q, want off", got)
+	}
+	if got := fc.updated.PortOverrides[1].PoeMode; got != "auto" {
+		t.Errorf("port 2 mode = %q, want auto", got)
+	}
+	if got := fc.updated.PortOverrides[2].PoeMode; got != "auto" {
+		t.Errorf("port 3 mode = %q, want auto", got)
+	}
+}
+
+func TestSetPortPowerBatch_UpdateFailure(t *testing.T) {
+	fc := &fakeClient{device: newTestDevice("auto", "off"), updateErr: errors.New("controller unavailable")}
+	svc := newTestService(fc, nil)
+
+	results, err := svc.setPortPowerBatch(context.Background(), "aa:bb:cc:dd:ee:ff", []PortPowerSetParams{
+		{Port: 1, State: "on"},
+		{Port: 2, State: "on"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Error != "" {
+		t.Errorf("unchanged port reported error %q", results[0].Error)
+	}
+	if results[1].Error == "" {
+		t.Error("changed port did not report the update failure")
+	}
+}
+
+func TestGetPower_PortOutOfRange(t *testing.T) {
+	modes := make([]string, 24)
+	for i := range modes {
+		modes[i] = "auto"
+	}
+	svc := newTestService(&fakeClient{device: newTestDevice(modes...)}, nil)
+
+	_, err := svc.GetPower(context.Background(), "aa:bb:cc:dd:ee:ff", "99")
+	if !errors.Is(err, ErrPortNotFound) {
+		t.Fatalf("GetPower() error = %v, want ErrPortNotFound", err)
+	}
+	if want := "port 99 is out of range, device aa:bb:cc:dd:ee:ff has 24 ports"; !strings.Contains(err.Error(), want) {
+		t.Errorf("GetPower() error = %q, want it to contain %q", err, want)
+	}
+
+	rec := serveRPC(context.Background(), t, svc, `{"id":1,"method":"getPowerState"}`)
+	if rec.Code != http.StatusOK {
+		t.Errorf("in range port status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if _, err = svc.GetPower(context.Background(), "aa:bb:cc:dd:ee:ff", "0"); err == nil {
+		t.Error("GetPower() accepted port 0")
+	}
+}
+
+func TestPortOutOfRange_SparseOverrides(t *testing.T) {
+	// The controller only lists overrides for the ports set up on it, so the
+	// port table of the 24 port switch decides which ports are out of range.
+	modes := make([]string, 24)
+	for i := range modes {
+		modes[i] = "auto"
+	}
+	fc := &fakeClient{device: newTestDevice("auto", "auto"), stats: statsOf(newTestDevice(modes...))}
+	svc := newTestService(fc, nil)
+	ctx := context.Background()
+
+	if _, err := svc.GetPower(ctx, "aa:bb:cc:dd:ee:ff", "5"); err != nil {
+		t.Errorf("GetPower() port without override error = %v", err)
+	}
+
+	err := svc.SetPortPower(ctx, "aa:bb:cc:dd:ee:ff", "5", "off")
+	if !errors.Is(err, ErrPortNotFound) {
+		t.Fatalf("SetPortPower() error = %v, want ErrPortNotFound", err)
+	}
+	if want := "device aa:bb:cc:dd:ee:ff has no override for port 5"; !strings.Contains(err.Error(), want) {
+		t.Errorf("SetPortPower() error = %q, want it to contain %q", err, want)
+	}
+
+	err = svc.SetPortPower(ctx, "aa:bb:cc:dd:ee:ff", "99", "off")
+	if want := "port 99 is out of range, device aa:bb:cc:dd:ee:ff has 24 ports"; err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("SetPortPower() error = %v, want it to contain %q", err, want)
+	}
+
+	results, err := svc.setPortPowerBatch(ctx, "aa:bb:cc:dd:ee:ff", []PortPowerSetParams{
+		{Port: 5, State: "off"},
+		{Port: 99, State: "off"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "no override for port 5"; !strings.Contains(results[0].Error, want) {
+		t.Errorf("batch port 5 error = %q, want it to contain %q", results[0].Error, want)
+	}
+	if want := "port 99 is out of range"; !strings.Contains(results[1].Error, want) {
+		t.Errorf("batch port 99 error = %q, want it to contain %q", results[1].Error, want)
+	}
+}
+
+func TestSetPortPower_RejectsInjection(t *testing.T) {
+	reached := errors.New("controller reached")
+	svc := newTestService(&fakeClient{err: reached}, nil)
+
+	tests := []struct {
+		name string
+		mac  string
+		port string
+	}{
+		{name: "port with command", mac: "aa:bb:cc:dd:ee:ff", port: "1; reboot"},
+		{name: "port with space", mac: "aa:bb:cc:dd:ee:ff", port: " 1"},
+		{name: "mac with path", mac: "aa:bb:cc:dd:ee:ff/../../rest/device", port: "1"},
+		{name: "mac with query", mac: "aa:bb:cc:dd:ee:ff?site=other", port: "1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := svc.SetPortPower(context.Background(), tt.mac, tt.port, PowerStateOff)
+			if err == nil || errors.Is(err, reached) {
+				t.Errorf("SetPortPower(%q, %q) error = %v, want it rejected before the controller", tt.mac, tt.port, err)
+			}
+		})
+	}
+
+	if _, err := svc.GetAllPoEStatus(context.Background(), "aa:bb:cc:dd:ee:ff/x"); !errors.Is(err, ErrInvalidMAC) {
+		t.Errorf("GetAllPoEStatus() error = %v, want ErrInvalidMAC", err)
+	}
+}
+
+func TestSetPortPower_ResetAndCycle(t *testing.T) {
+	fc := &fakeClient{device: newTestDevice("auto", "auto")}
+	svc := newTestService(fc, nil)
+
+	if err := svc.SetPortPower(context.Background(), "aa:bb:cc:dd:ee:ff", "2", PowerStateReset); err != nil {
+		t.Fatalf("SetPortPower(reset) error = %v", err)
+	}
+	var modes []string
+	for _, d := range fc.updates {
+		modes = append(modes, d.PortOverrides[1].PoeMode)
+	}
+	if want := []string{"off", "auto"}; !reflect.DeepEqual(modes, want) {
+		t.Errorf("reset sent PoE modes %v, want %v", modes, want)
+	}
+	if len(fc.cycled) != 0 {
+		t.Errorf("reset power cycled ports %v", fc.cycled)
+	}
+
+	fc.updates = nil
+	if err := svc.SetPortPower(context.Background(), "aa:bb:cc:dd:ee:ff", "2", "Cycle"); err != nil {
+		t.Fatalf("SetPortPower(cycle) error = %v", err)
+	}
+	if len(fc.updates) != 0 || !reflect.DeepEqual(fc.cycled, []int{2}) {
+		t.Errorf("cycle sent %d updates and cycled %v, want a single cycle of port 2", len(fc.updates), fc.cycled)
+	}
+}
+
+func TestRPCHandler_SoftPowerNotSupported(t *testing.T) {
+	fc := &fakeClient{device: newTestDevice("auto")}
+	svc := newTestService(fc, nil)
+
+	rec := serveRPC(context.Background(), t, svc, `{"id":1,"method":"setPowerState","params":{"state":"soft"}}`)
+	if rec.Code != http.StatusNotImplemented {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusNotImplemented)
+	}
+	if !strings.Contains(rec.Body.String(), `use \"off\" for a hard power cut`) {
+		t.Errorf("body = %s, want a hint to use off", rec.Body.String())
+	}
+	if fc.updated != nil {
+		t.Error("soft power off changed the port")
+	}
+}
+
+func TestRPCHandler_RoutesByHost(t *testing.T) {
+	svc := newTestService(&fakeClient{device: newTestDevice("auto")}, nil)
+	svc.clients["rack2"] = &fakeClient{device: newTestDevice("off")}
+
+	tests := []struct {
+		host string
+		want string
+	}{
+		{host: "", want: `"result":"on"`},
+		{host: "rack1", want: `"result":"on"`},
+		{host: "Rack2", want: `"result":"off"`},
+	}
+	for _, tt := range tests {
+		rec := serveRPC(context.Background(), t, svc, `{"id":1,"host":"`+tt.host+`","method":"getPowerState"}`)
+		if !strings.Contains(rec.Body.String(), tt.want) {
+			t.Errorf("host %q: body = %s, want it to contain %s", tt.host, rec.Body.String(), tt.want)
+		}
+	}
+}
+
+func TestRPCHandler_UnknownHost(t *testing.T) {
+	svc := newTestService(nil, nil)
+	svc.clients = map[string]unifiClient{"rack1": &fakeClient{device: newTestDevice("auto")}}
+
+	rec := serveRPC(context.Background(), t, svc, `{"id":1,"host":"rack9","method":"getPowerState"}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if want := `rack9`; !strings.Contains(rec.Body.String(), want) {
+		t.Errorf("body = %s, want it to contain %s", rec.Body.String(), want)
+	}
+}
+
+func TestRPCHandler_StrictRejectsUnknownFields(t *testing.T) {
+	body := `{"id":1,"method":"setPowerState","params":{"sate":"off"}}`
+
+	svc := newTestService(&fakeClient{device: newTestDevice("auto")}, nil)
+	svc.strict = true
+	if rec := serveRPC(context.Background(), t, svc, body); rec.Code != http.StatusBadRequest {
+		t.Errorf("strict: status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	rec := serveRPC(context.Background(), t, svc, `{"id":1,"method":"getPowerState","extra":true}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("strict, unknown request field: status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestRPCHandler_BodyTooLarge(t *testing.T) {
+	svc := newTestService(&fakeClient{device: newTestDevice("auto")}, nil)
+
+	req := httptest.NewRequest(http.MethodPost, "/device/aa:bb:cc:dd:ee:ff/port/1/rpc", strings.NewReader(`{"id":1,"method":"getPowerState"}`))
+	rec := httptest.NewRecorder()
+	req.Body = http.MaxBytesReader(rec, req.Body, 8)
+	req = mux.SetURLVars(req, map[string]string{"mac": "aa:bb:cc:dd:ee:ff", "port": "1"})
+	svc.RPCHandler(rec, req)
+
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusRequestEntityTooLarge)
+	}
+	if got := responseReason(t, rec); got != ReasonRequestTooLarge {
+		t.Errorf("reason = %q, want %q", got, ReasonRequestTooLarge)
+	}
+}
+
+func TestPreflight(t *testing.T) {
+	svc := newTestService(&fakeClient{}, nil)
+	if err := svc.Preflight(context.Background()); err != nil {
+		t.Errorf("Preflight() error = %v", err)
+	}
+
+	svc.clients["rack2"] = &fakeClient{err: errors.New("connection refused")}
+	err := svc.Preflight(context.Background())
+	if err == nil || !strings.Contains(err.Error(), `"rack2"`) {
+		t.Errorf("Preflight() error = %v, want it to name the failing host", err)
+	}
+}
+
+func TestRPCHandler_PowerSetIdempotent(t *testing.T) {
+	fc := &fakeClient{device: newTestDevice("off")}
+	svc := newTestService(fc, nil)
+
+	rec := serveRPC(context.Background(), t, svc, `{"id":1,"method":"setPowerState","params":{"state":"off"}}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if fc.updated != nil {
+		t.Error("setting the current state updated the device")
+	}
+
+	serveRPC(context.Background(), t, svc, `{"id":1,"method":"setPowerState","params":{"state":"off","force":true}}`)
+	if fc.updated == nil {
+		t.Error("forced set did not update the device")
+	}
+}
+
+func TestRPCHandler_UnreachableVsRejected(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantReason string
+	}{
+		{name: "unreachable", err: fmt.Errorf("%w: dial tcp: connection refused", ErrControllerUnreachable), wantStatus: http.StatusServiceUnavailable, wantReason: ReasonControllerUnreachable},
+		{name: "rejected", err: errors.New("api.err.NoPermission"), wantStatus: http.StatusBadGateway, wantReason: ReasonCallFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := newTestService(&fakeClient{err: tt.err}, nil)
+			rec := serveRPC(context.Background(), t, svc, `{"id":1,"method":"getPowerState"}`)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			var rp ResponsePayload
+			if err := json.Unmarshal(rec.Body.Bytes(), &rp); err != nil {
+				t.Fatal(err)
+			}
+			if rp.Error == nil || rp.Error.Reason != tt.wantReason {
+				t.Errorf("error = %+v, want reason %q", rp.Error, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestPortMap(t *testing.T) {
+	tests := []struct {
+		name    string
+		strict  bool
+		port    string
+		want    string
+		wantErr bool
+	}{
+		{name: "mapped", port: "1", want: "on"},
+		{name: "pass-through", port: "2", want: "off"},
+		{name: "strict rejects unmapped", strict: true, port: "2", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := newTestService(&fakeClient{device: newTestDevice("off", "off", "auto")}, nil)
+			ports, err := newPortMap(map[int]int{1: 3}, tt.strict)
+			if err != nil {
+				t.Fatal(err)
+			}
+			svc.ports = ports
+
+			got, err := svc.GetPower(context.Background(), "aa:bb:cc:dd:ee:ff", tt.port)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetPower() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("GetPower() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := newPortMap(map[int]int{1: 3, 2: 3}, false); err == nil {
+		t.Error("newPortMap() accepted two ports mapped to the same switch port")
+	}
+}
+
+func TestPortAliases(t *testing.T) {
+	svc := newTestService(&fakeClient{device: newTestDevice("off", "off", "auto")}, nil)
+	ports, err := newPortMap(map[int]int{1: 3}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc.ports = ports
+	svc.aliases, err = newPortAliases(map[string]int{"node-03": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := mux.NewRouter()
+	r.HandleFunc("/device/{mac}/port/{port}/rpc", svc.RPCHandler).Methods("POST")
+	req := httptest.NewRequest(http.MethodPost, "/device/aa:bb:cc:dd:ee:ff/port/node-03/rpc", strings.NewReader(`{"id":1,"method":"getPowerState"}`))
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	var rp ResponsePayload
+	if err := json.Unmarshal(rec.Body.Bytes(), &rp); err != nil {
+		t.Fatal(err)
+	}
+	if rp.Result != "on" || rp.Port != "1" || rp.PortAlias != "node-03" {
+		t.Errorf("response = %+v, want port 1 named node-03 to be on", rp)
+	}
+
+	for _, aliases := range []map[string]int{{"": 1}, {"7": 1}, {"node-00": 0}} {
+		if _, err := newPortAliases(aliases); err == nil {
+			t.Errorf("newPortAliases(%v) accepted an invalid alias", aliases)
+		}
+	}
+}
+
+// siteClient records the site of every device read and update.
+type siteClient struct {
+	fakeClient
+	sites []string
+}
+
+func (c *siteClient) GetDeviceByMAC(ctx context.Context, site, mac string) (*unifi.Device, error) {
+	c.sites = append(c.sites, site)
+	return c.fakeClient.GetDeviceByMAC(ctx, site, mac)
+}
+
+func (c *siteClient) UpdateDevice(ctx context.Context, site string, d *unifi.Device) (*unifi.Device, error) {
+	c.sites = append(c.sites, site)
+	return c.fakeClient.UpdateDevice(ctx, site, d)
+}
+
+func TestRPCHandler_UsesSiteOfHost(t *testing.T) {
+	lab := &siteClient{fakeClient: fakeClient{device: newTestDevice("auto")}}
+	svc := newTestService(&fakeClient{device: newTestDevice("auto")}, nil)
+	svc.clients["lab"] = lab
+	svc.sites = map[string]string{"": "default", "lab": "x7k2qz9d"}
+
+	rec := serveRPC(context.Background(), t, svc, `{"id":1,"host":"lab","method":"setPowerState","params":{"state":"off"}}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	if want := []string{"x7k2qz9d", "x7k2qz9d"}; !reflect.DeepEqual(lab.sites, want) {
+		t.Errorf("controller calls used sites %v, want %v", lab.sites, want)
+	}
+}
+
+func TestNewBMCService_Sites(t *testing.T) {
+	cfg := config.Default()
+	cfg.APIEndpoint = "https://unifi.example"
+	cfg.Site = "main"
+	cfg.Controllers = []config.Controller{
+		{Host: "Lab", APIEndpoint: "https://lab.example", Site: "x7k2qz9d"},
+		{Host: "rack2", APIEndpoint: "https://rack2.example"},
+	}
+	svc, err := NewBMCService(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := svc.(*bmcService)
+	want := map[string]string{"": "main", "lab": "x7k2qz9d", "rack2": "main"}
+	if b.site != "main" || !reflect.DeepEqual(b.sites, want) {
+		t.Errorf("site = %q, sites = %v, want main and %v", b.site, b.sites, want)
+	}
+}
+
+func TestRPCHandler_DefaultPort(t *testing.T) {
+	tests := []struct {
+		name        string
+		path        string
+		defaultPort string
+		wantCode    int
+		wantResult  string
+	}{
+		{name: "port overrides default", path: "/device/aa:bb:cc:dd:ee:ff/port/2/rpc", defaultPort: "1", wantCode: http.StatusOK, wantResult: "off"},
+		{name: "default port", path: "/device/aa:bb:cc:dd:ee:ff/rpc", defaultPort: "1", wantCode: http.StatusOK, wantResult: "on"},
+		{name: "default alias", path: "/device/aa:bb:cc:dd:ee:ff/rpc", defaultPort: "node-02", wantCode: http.StatusOK, wantResult: "off"},
+		{name: "no port", path: "/device/aa:bb:cc:dd:ee:ff/rpc", wantCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := newTestService(&fakeClient{device: newTestDevice("auto", "off")}, nil)
+			svc.defaultPort = tt.defaultPort
+			svc.aliases = portAliases{"node-02": 2}
+
+			r := mux.NewRouter()
+			r.HandleFunc("/device/{mac}/port/{port}/rpc", svc.RPCHandler).Methods("POST")
+			r.HandleFunc("/device/{mac}/rpc", svc.RPCHandler).Methods("POST")
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(`{"id":1,"method":"getPowerState"}`)))
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantCode, rec.Body)
+			}
+			if rec.Code != http.StatusOK {
+				if got := responseReason(t, rec); got != ReasonInvalidPort {
+					t.Errorf("reason = %q, want %q", got, ReasonInvalidPort)
+				}
+				return
+			}
+			var rp ResponsePayload
+			if err := json.Unmarshal(rec.Body.Bytes(), &rp); err != nil {
+				t.Fatal(err)
+			}
+			if rp.Result != tt.wantResult {
+				t.Errorf("result = %v, want %s", rp.Result, tt.wantResult)
+			}
+		})
+	}
+
+	for _, port := range []string{"node-07", "0"} {
+		cfg := config.Default()
+		cfg.DefaultPort = port
+		if _, err := NewBMCService(cfg, nil); !errors.Is(err, ErrInvalidPort) {
+			t.Errorf("NewBMCService() with default port %q error = %v, want %v", port, err, ErrInvalidPort)
+		}
+	}
+}
+
+func TestRPCHandler_PowerSetPortPrecedence(t *testing.T) {
+	tests := []struct {
+		name        string
+		path        string
+		defaultPort string
+		bodyPort    int
+		wantPort    int
+	}{
+		{name: "body over route and default", path: "/device/aa:bb:cc:dd:ee:ff/port/2/rpc", defaultPort: "1", bodyPort: 3, wantPort: 3},
+		{name: "body over route", path: "/device/aa:bb:cc:dd:ee:ff/port/2/rpc", bodyPort: 3, wantPort: 3},
+		{name: "body over default", path: "/device/aa:bb:cc:dd:ee:ff/rpc", defaultPort: "1", bodyPort: 3, wantPort: 3},
+		{name: "body only", path: "/device/aa:bb:cc:dd:ee:ff/rpc", bodyPort: 3, wantPort: 3},
+		{name: "route over default", path: "/device/aa:bb:cc:dd:ee:ff/port/2/rpc", defaultPort: "1", wantPort: 2},
+		{name: "route only", path: "/device/aa:bb:cc:dd:ee:ff/port/2/rpc", wantPort: 2},
+		{name: "default only", path: "/device/aa:bb:cc:dd:ee:ff/rpc", defaultPort: "node-01", wantPort: 1},
+		{name: "no port", path: "/device/aa:bb:cc:dd:ee:ff/rpc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := &fakeClient{device: newTestDevice("auto", "auto", "auto")}
+			svc := newTestService(fc, nil)
+			svc.defaultPort = tt.defaultPort
+			svc.aliases = portAliases{"node-01": 1}
+
+			r := mux.NewRouter()
+			r.HandleFunc("/device/{mac}/port/{port}/rpc", svc.RPCHandler).Methods("POST")
+			r.HandleFunc("/device/{mac}/rpc", svc.RPCHandler).Methods("POST")
+			body := fmt.Sprintf(`{"id":1,"method":"setPowerState","params":{"state":"off","port":%d}}`, tt.bodyPort)
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(body)))
+
+			if tt.wantPort == 0 {
+				if rec.Code != http.StatusBadRequest || responseReason(t, rec) != ReasonInvalidPort {
+					t.Errorf("status = %d, want %d for no port: %s", rec.Code, http.StatusBadRequest, rec.Body)
+				}
+				return
+			}
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+			}
+			if len(fc.updates) != 1 {
+				t.Fatalf("%d updates, want 1", len(fc.updates))
+			}
+			for _, o := range fc.updates[0].PortOverrides {
+				if want := o.PortIDX == tt.wantPort; (o.PoeMode == "off") != want {
+					t.Errorf("port %d has PoE mode %q, want only port %d off", o.PortIDX, o.PoeMode, tt.wantPort)
+				}
+			}
+		})
+	}
+}
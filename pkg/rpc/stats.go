@@ -0,0 +1,295 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/paultyng/go-unifi/unifi"
+)
+
+// go-unifi only models the configurable part of a device, so the live port
+// table is read from the stat endpoint directly, sharing the session of the
+// logged in client.
+
+// deviceStats is the subset of a stat/device entry used by this package.
+type deviceStats struct {
+	MAC           string            `json:"mac"`
+	Name          string            `json:"name"`
+	Model         string            `json:"model"`
+	Version       string            `json:"version"`
+	State         unifi.DeviceState `json:"state"`
+	TotalMaxPower flexFloat         `json:"total_max_power"`
+	PortTable     []portStat        `json:"port_table"`
+	// SkippedRows describes the port_table rows that did not decode and
+	// are missing from PortTable.
+	SkippedRows []string `json:"-"`
+}
+
+// UnmarshalJSON decodes the port table row by row, so that one malformed
+// row does not hide the others. What to do about skipped rows is left to
+// the statsParseMode of the caller.
+func (s *deviceStats) UnmarshalJSON(b []byte) error {
+	type plain deviceStats
+	var raw struct {
+		*plain
+		PortTable []json.RawMessage `json:"port_table"`
+	}
+	raw.plain = (*plain)(s)
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+
+	s.PortTable = make([]portStat, 0, len(raw.PortTable))
+	s.SkippedRows = nil
+	for i, row := range raw.PortTable {
+		var p portStat
+		if err := json.Unmarshal(row, &p); err != nil {
+			s.SkippedRows = append(s.SkippedRows, fmt.Sprintf("%s: %v", rowName(row, i), err))
+			continue
+		}
+		s.PortTable = append(s.PortTable, p)
+	}
+	return nil
+}
+
+// rowName names the port_table row at index i by its port when that much
+// of it decodes.
+func rowName(row json.RawMessage, i int) string {
+	var idx struct {
+		PortIdx int `json:"port_idx"`
+	}
+	if json.Unmarshal(row, &idx) == nil && idx.PortIdx > 0 {
+		return fmt.Sprintf("port %d", idx.PortIdx)
+	}
+	return fmt.Sprintf("port_table row %d", i)
+}
+
+// statsParseMode selects what reading the device stats does about port
+// table rows that do not decode.
+type statsParseMode int
+
+const (
+	// parseStrict fails the read.
+	parseStrict statsParseMode = iota
+	// parseLenient returns the rows that decoded with a warning for each
+	// skipped one.
+	parseLenient
+)
+
+// parseStatsParseMode parses the statsParseMode config value or parse
+// query parameter, the empty string selecting def.
+func parseStatsParseMode(s string, def statsParseMode) (statsParseMode, error) {
+	switch strings.ToLower(s) {
+	case "":
+		return def, nil
+	case "strict":
+		return parseStrict, nil
+	case "lenient":
+		return parseLenient, nil
+	}
+	return 0, fmt.Errorf("unknown stats parse mode %q, want strict or lenient", s)
+}
+
+type portStat struct {
+	PortIdx    int        `json:"port_idx"`
+	Name       string     `json:"name"`
+	Up         bool       `json:"up"`
+	Speed      int        `json:"speed"`
+	PortPoE    bool       `json:"port_poe"`
+	PoEEnable  bool       `json:"poe_enable"`
+	PoEMode    string     `json:"poe_mode"`
+	PoEGood    bool       `json:"poe_good"`
+	PoEClass   flexString `json:"poe_class"`
+	PoEPower   flexFloat  `json:"poe_power"`
+	PoEVoltage flexFloat  `json:"poe_voltage"`
+	PoECurrent flexFloat  `json:"poe_current"`
+}
+
+// flexFloat accepts both JSON numbers and the quoted decimals the
+// controller uses for most PoE readings. Ports without PoE report their
+// readings as "N/A" or blank, which decode to zero.
+type flexFloat float64
+
+func (f *flexFloat) UnmarshalJSON(b []byte) error {
+	s := strings.TrimSpace(strings.Trim(string(b), `"`))
+	if s == "" || s == "null" || strings.EqualFold(s, "N/A") {
+		*f = 0
+		return nil
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return fmt.Errorf("invalid number %s: %w", b, err)
+	}
+	*f = flexFloat(v)
+	return nil
+}
+
+// flexString accepts both JSON strings and numbers, since some firmware
+// reports the PoE class of 802.3bt ports as a bare number.
+type flexString string
+
+func (f *flexString) UnmarshalJSON(b []byte) error {
+	if len(b) > 0 && b[0] == '"' {
+		var s string
+		if err := json.Unmarshal(b, &s); err != nil {
+			return err
+		}
+		*f = flexString(s)
+		return nil
+	}
+	if string(b) == "null" {
+		*f = ""
+		return nil
+	}
+	*f = flexString(b)
+	return nil
+}
+
+func decodeDeviceStats(r io.Reader) (*deviceStats, error) {
+	var body struct {
+		Data []deviceStats `json:"data"`
+	}
+	if err := json.NewDecoder(r).Decode(&body); err != nil {
+		return nil, fmt.Errorf("unable to decode device stats: %w", err)
+	}
+	if len(body.Data) == 0 {
+		return nil, &unifi.NotFoundError{}
+	}
+	return &body.Data[0], nil
+}
+
+// detectAPIPath mirrors the check go-unifi makes during login: UniFi OS
+// consoles answer / with a 200 and proxy the network application, older
+// controllers redirect.
+func detectAPIPath(ctx context.Context, hc *http.Client, baseURL string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL, http.NoBody)
+	if err != nil {
+		return "", err
+	}
+
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+		Transport: hc.Transport,
+		Timeout:   hc.Timeout,
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode == http.StatusOK {
+		return "/proxy/network/api", nil
+	}
+	return "/api", nil
+}
+
+func (c *lazyClient) GetDeviceStats(ctx context.Context, site, mac string) (*deviceStats, error) {
+	if err := c.init(ctx); err != nil {
+		return nil, err
+	}
+	ctx, span := startCall(ctx, "GetDeviceStats")
+	start := time.Now()
+	var stats *deviceStats
+	err := c.call(ctx, func() error {
+		raw, err := c.getRawDeviceStats(ctx, site, mac)
+		if err != nil {
+			return err
+		}
+		stats, err = decodeDeviceStats(bytes.NewReader(raw))
+		return err
+	})
+	err = markUnreachable(c.redact(err))
+	c.logCall(span, "GetDeviceStats", start, err, "site", site, "mac", mac)
+	return stats, err
+}
+
+// GetRawDeviceStats returns the stat/device response for mac as sent by the
+// controller, before decoding.
+func (c *lazyClient) GetRawDeviceStats(ctx context.Context, site, mac string) ([]byte, error) {
+	if err := c.init(ctx); err != nil {
+		return nil, err
+	}
+	ctx, span := startCall(ctx, "GetRawDeviceStats")
+	start := time.Now()
+	var raw []byte
+	err := c.call(ctx, func() (err error) {
+		raw, err = c.getRawDeviceStats(ctx, site, mac)
+		return err
+	})
+	err = markUnreachable(c.redact(err))
+	c.logCall(span, "GetRawDeviceStats", start, err, "site", site, "mac", mac)
+	return raw, err
+}
+
+func (c *lazyClient) getRawDeviceStats(ctx context.Context, site, mac string) ([]byte, error) {
+	u := strings.TrimSuffix(c.baseURL, "/") + c.apiPath + "/s/" + url.PathEscape(site) + "/stat/device/" + url.PathEscape(mac)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, http.NoBody)
+	if err != nil {
+		return nil, err
+	}
+	if csrf := c.inner.CSRFToken(); csrf != "" {
+		req.Header.Set("X-CSRF-Token", csrf)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to perform request: GET %s %w", u, err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		raw, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read response: GET %s %w", u, err)
+		}
+		return raw, nil
+	case http.StatusNotFound:
+		return nil, &unifi.NotFoundError{}
+	default:
+		return nil, responseError(resp, http.MethodGet+" "+u)
+	}
+}
+
+// maxErrorBody bounds how much of a failed response is quoted in an error.
+const maxErrorBody = 512
+
+// responseError describes the failed controller response to req, given as
+// method and URL. It quotes the controller message, or failing that the
+// start of the body, since that usually names the actual problem. A 401 is
+// marked with errSessionExpired.
+func responseError(resp *http.Response, req string) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+
+	msg := strings.TrimSpace(string(body))
+	var errBody struct {
+		Meta struct {
+			Msg string `json:"msg"`
+		} `json:"meta"`
+	}
+	if json.Unmarshal(body, &errBody) == nil && errBody.Meta.Msg != "" {
+		msg = errBody.Meta.Msg
+	}
+
+	err := fmt.Errorf("unexpected status %s for %s", resp.Status, req)
+	if msg != "" {
+		err = fmt.Errorf("%w: %s", err, msg)
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		return fmt.Errorf("%w: %w", errSessionExpired, err)
+	}
+	return err
+}
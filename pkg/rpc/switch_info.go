@@ -0,0 +1,81 @@
+package rpc
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// switchInfoTTL is how long a SwitchInfo is reused. The model never
+// changes and the firmware only with an upgrade, which is rare enough to
+// be picked up late.
+const switchInfoTTL = 10 * time.Minute
+
+// SwitchInfo is the result of the getSwitchInfo RPC method.
+type SwitchInfo struct {
+	MAC     string `json:"mac"`
+	Name    string `json:"name,omitempty"`
+	Model   string `json:"model"`
+	Version string `json:"version"`
+}
+
+// switchInfoCache keeps the SwitchInfo of every device for switchInfoTTL,
+// shared by all views of the service returned by forHost.
+type switchInfoCache struct {
+	now func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]switchInfoEntry
+}
+
+type switchInfoEntry struct {
+	info    SwitchInfo
+	fetched time.Time
+}
+
+func newSwitchInfoCache() *switchInfoCache {
+	return &switchInfoCache{now: time.Now, entries: map[string]switchInfoEntry{}}
+}
+
+func (c *switchInfoCache) get(mac string) (SwitchInfo, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[strings.ToLower(mac)]
+	if !ok || c.now().Sub(e.fetched) >= switchInfoTTL {
+		return SwitchInfo{}, false
+	}
+	return e.info, true
+}
+
+func (c *switchInfoCache) put(mac string, info SwitchInfo) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[strings.ToLower(mac)] = switchInfoEntry{info: info, fetched: c.now()}
+}
+
+// GetSwitchInfo returns the model and firmware version of the device, as
+// reported by the stat endpoint of the controller.
+func (b *bmcService) GetSwitchInfo(ctx context.Context, macAddress string) (SwitchInfo, error) {
+	if err := checkMAC(macAddress); err != nil {
+		return SwitchInfo{}, err
+	}
+	if b.switchInfo != nil {
+		if info, ok := b.switchInfo.get(macAddress); ok {
+			return info, nil
+		}
+	}
+
+	// The port table is not used, so malformed rows do not matter.
+	stats, err := b.deviceStats(ctx, macAddress, parseLenient)
+	if err != nil {
+		return SwitchInfo{}, err
+	}
+	info := SwitchInfo{MAC: stats.MAC, Name: stats.Name, Model: stats.Model, Version: stats.Version}
+	if b.switchInfo != nil {
+		b.switchInfo.put(macAddress, info)
+	}
+	return info, nil
+}
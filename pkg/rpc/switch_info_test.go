@@ -0,0 +1,75 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// statsCountingClient counts the GetDeviceStats calls.
+type statsCountingClient struct {
+	fakeClient
+	reads int
+}
+
+func (c *statsCountingClient) GetDeviceStats(ctx context.Context, site, mac string) (*deviceStats, error) {
+	c.reads++
+	return c.fakeClient.GetDeviceStats(ctx, site, mac)
+}
+
+func TestGetSwitchInfo(t *testing.T) {
+	sc := &statsCountingClient{fakeClient: fakeClient{stats: loadDeviceStats(t, "stat_device_usw.json")}}
+	svc := newTestService(sc, nil)
+	svc.switchInfo = newSwitchInfoCache()
+	now := time.Now()
+	svc.switchInfo.now = func() time.Time { return now }
+
+	want := SwitchInfo{MAC: "aa:bb:cc:dd:ee:ff", Name: "rack-switch", Model: "USL16LPB", Version: "7.0.50.15613"}
+	for i := 0; i < 2; i++ {
+		info, err := svc.GetSwitchInfo(context.Background(), "AA:BB:CC:DD:EE:FF")
+		if err != nil {
+			t.Fatalf("GetSwitchInfo() error = %v", err)
+		}
+		if info != want {
+			t.Errorf("GetSwitchInfo() = %+v, want %+v", info, want)
+		}
+	}
+	if sc.reads != 1 {
+		t.Errorf("%d stats reads, want the second served from the cache", sc.reads)
+	}
+
+	now = now.Add(switchInfoTTL)
+	if _, err := svc.GetSwitchInfo(context.Background(), "aa:bb:cc:dd:ee:ff"); err != nil {
+		t.Fatal(err)
+	}
+	if sc.reads != 2 {
+		t.Errorf("%d stats reads, want the expired entry read again", sc.reads)
+	}
+}
+
+func TestRPCHandler_SwitchInfoWithoutPort(t *testing.T) {
+	svc := newTestService(&fakeClient{stats: loadDeviceStats(t, "stat_device_usw.json")}, nil)
+
+	r := mux.NewRouter()
+	r.HandleFunc("/device/{mac}/rpc", svc.RPCHandler).Methods("POST")
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/device/aa:bb:cc:dd:ee:ff/rpc", strings.NewReader(`{"id":1,"method":"getSwitchInfo"}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	var rp struct {
+		Result SwitchInfo `json:"result"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &rp); err != nil {
+		t.Fatal(err)
+	}
+	if rp.Result.Model != "USL16LPB" || rp.Result.Version != "7.0.50.15613" {
+		t.Errorf("result = %+v, want the model and version of the fixture", rp.Result)
+	}
+}
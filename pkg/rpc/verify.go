@@ -0,0 +1,53 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrNotApplied is returned when verifySets is enabled and the switch does
+// not report the power state that was set, which some firmware does while
+// the controller accepts the change.
+var ErrNotApplied = errors.New("power change not applied")
+
+// verifyPortState waits b.verifySettle and then checks that the switch
+// reports port p of the device in the power state st. The stats are read
+// from the controller, bypassing the device cache.
+func (b *bmcService) verifyPortState(ctx context.Context, macAddress string, p int, st PowerGetResult) error {
+	return b.verifyPortStates(ctx, macAddress, map[int]PowerGetResult{p: st})[p]
+}
+
+// verifyPortStates is verifyPortState for the ports in want, which waits
+// and reads the stats once for all of them. It returns the error of each
+// port that is not in the state it is mapped to.
+func (b *bmcService) verifyPortStates(ctx context.Context, macAddress string, want map[int]PowerGetResult) map[int]error {
+	errs := map[int]error{}
+	if err := sleepCtx(ctx, b.verifySettle); err != nil {
+		for p := range want {
+			errs[p] = err
+		}
+		return errs
+	}
+	stats, err := b.deviceStats(ctx, macAddress, parseLenient)
+	if err != nil {
+		for p := range want {
+			errs[p] = fmt.Errorf("error verifying the power state of port %d: %w", p, err)
+		}
+		return errs
+	}
+	for p, st := range want {
+		errs[p] = fmt.Errorf("%w: device %s does not report port %d", ErrNotApplied, macAddress, p)
+		for _, ps := range stats.PortTable {
+			if ps.PortIdx != p {
+				continue
+			}
+			delete(errs, p)
+			if got := b.livePortState(stats.State, p, ps.PortPoE, ps.PoEMode); got != st {
+				errs[p] = fmt.Errorf("%w: port %d is %s after %v, want %s", ErrNotApplied, p, got, b.verifySettle, st)
+			}
+			break
+		}
+	}
+	return errs
+}
@@ -0,0 +1,71 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSetPortPower_VerifySets(t *testing.T) {
+	// The stats report port 1 on and port 3 off whatever is set, like a
+	// switch ignoring the change to port 3.
+	fc := &fakeClient{device: newTestDevice("off", "auto", "off"), stats: loadDeviceStats(t, "stat_device_usw.json")}
+	svc := newTestService(fc, nil)
+	svc.verifySets = true
+	svc.verifySettle = 20 * time.Millisecond
+
+	start := time.Now()
+	if _, err := svc.setPortPower(context.Background(), "aa:bb:cc:dd:ee:ff", "1", "on", false); err != nil {
+		t.Fatalf("setPortPower() of an applied change error = %v", err)
+	}
+	if d := time.Since(start); d < svc.verifySettle {
+		t.Errorf("setPortPower() returned after %v, want to wait %v before the read back", d, svc.verifySettle)
+	}
+
+	_, err := svc.setPortPower(context.Background(), "aa:bb:cc:dd:ee:ff", "3", "on", false)
+	if !errors.Is(err, ErrNotApplied) {
+		t.Fatalf("setPortPower() of an ignored change error = %v, want %v", err, ErrNotApplied)
+	}
+	if got := errorStatus(err); got != http.StatusBadGateway {
+		t.Errorf("errorStatus() = %d, want %d", got, http.StatusBadGateway)
+	}
+	if len(fc.updates) != 2 {
+		t.Errorf("device updated %d times, want 2", len(fc.updates))
+	}
+}
+
+func TestSetPortPower_VerifySkipsUnchanged(t *testing.T) {
+	fc := &fakeClient{device: newTestDevice("off", "auto", "off"), stats: loadDeviceStats(t, "stat_device_usw.json")}
+	svc := newTestService(fc, nil)
+	svc.verifySets = true
+	svc.verifySettle = time.Hour
+
+	// Port 2 is already on, so nothing is sent and nothing is read back.
+	if _, err := svc.setPortPower(context.Background(), "aa:bb:cc:dd:ee:ff", "2", "on", false); err != nil {
+		t.Fatalf("setPortPower() error = %v", err)
+	}
+}
+
+func TestSetPortPowerBatch_VerifySets(t *testing.T) {
+	fc := &fakeClient{device: newTestDevice("off", "auto", "off"), stats: loadDeviceStats(t, "stat_device_usw.json")}
+	svc := newTestService(fc, nil)
+	svc.verifySets = true
+	svc.verifySettle = time.Millisecond
+
+	results, err := svc.setPortPowerBatch(context.Background(), "aa:bb:cc:dd:ee:ff", []PortPowerSetParams{
+		{Port: 1, State: "on"},
+		{Port: 3, State: "on"},
+	})
+	if err != nil {
+		t.Fatalf("setPortPowerBatch() error = %v", err)
+	}
+	if results[0].Error != "" {
+		t.Errorf("port 1 error = %q, want the change verified", results[0].Error)
+	}
+	if !strings.Contains(results[1].Error, ErrNotApplied.Error()) {
+		t.Errorf("port 3 error = %q, want %q", results[1].Error, ErrNotApplied)
+	}
+}
@@ -0,0 +1,221 @@
+package rpc
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// PowerStateChange is pushed to WatchHandler subscribers when the power
+// state of a port changes. OldState is empty in the first message for a
+// port.
+type PowerStateChange struct {
+	Port     int     `json:"port"`
+	OldState string  `json:"oldState"`
+	NewState string  `json:"newState"`
+	Watts    float64 `json:"watts"`
+}
+
+// watchBuffer is how many undelivered polls a subscriber may fall behind
+// before changes are dropped for it.
+const watchBuffer = 16
+
+// watchWriteTimeout bounds a single WebSocket write to a subscriber.
+const watchWriteTimeout = 10 * time.Second
+
+// powerWatcher polls devices for WatchHandler. Subscribers to the same
+// device share one poll loop, which stops with the last subscriber so idle
+// dashboards cause no controller load.
+type powerWatcher struct {
+	interval time.Duration
+	logger   *slog.Logger
+
+	mu    sync.Mutex
+	loops map[string]*watchLoop
+}
+
+// watchLoop is the poll loop of a single device.
+type watchLoop struct {
+	cancel context.CancelFunc
+	subs   map[chan []PowerStateChange]struct{}
+	// last is the result of the latest poll, replayed to new subscribers.
+	last map[int]PortPowerStatus
+}
+
+func newPowerWatcher(interval time.Duration, logger *slog.Logger) *powerWatcher {
+	return &powerWatcher{
+		interval: interval,
+		logger:   logger,
+		loops:    map[string]*watchLoop{},
+	}
+}
+
+// subscribe registers a subscriber for the ports of mac on the controller
+// of svc, starting the poll loop if it is the first one. The returned func
+// unsubscribes and must be called exactly once.
+func (w *powerWatcher) subscribe(svc *bmcService, host, mac string) (<-chan []PowerStateChange, func()) {
+	key := strings.ToLower(host) + "/" + strings.ToLower(mac)
+	ch := make(chan []PowerStateChange, watchBuffer)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	l, ok := w.loops[key]
+	if !ok {
+		ctx, cancel := context.WithCancel(context.Background())
+		l = &watchLoop{cancel: cancel, subs: map[chan []PowerStateChange]struct{}{}}
+		w.loops[key] = l
+		go w.run(ctx, l, svc, mac)
+	} else if len(l.last) > 0 {
+		ch <- portChanges(nil, l.last)
+	}
+	l.subs[ch] = struct{}{}
+
+	return ch, func() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+
+		delete(l.subs, ch)
+		if len(l.subs) == 0 {
+			l.cancel()
+			delete(w.loops, key)
+		}
+	}
+}
+
+func (w *powerWatcher) run(ctx context.Context, l *watchLoop, svc *bmcService, mac string) {
+	t := time.NewTicker(w.interval)
+	defer t.Stop()
+
+	for {
+		w.poll(ctx, l, svc, mac)
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// poll reads the ports of mac once and sends what changed since the last
+// poll to every subscriber of l.
+func (w *powerWatcher) poll(ctx context.Context, l *watchLoop, svc *bmcService, mac string) {
+	dev, ports, err := svc.livePorts(ctx, mac)
+	if err != nil {
+		if ctx.Err() == nil {
+			w.logger.Error("error polling PoE status", "mac", mac, "error", err)
+		}
+		return
+	}
+
+	cur := make(map[int]PortPowerStatus, len(ports))
+	for _, p := range ports {
+		cur[p.Port] = PortPowerStatus{
+			Port:  p.Port,
+			State: svc.livePortState(dev, p.Port, p.PoE, p.Mode).String(),
+			Watts: p.PowerWatts,
+		}
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	changes := portChanges(l.last, cur)
+	l.last = cur
+	if len(changes) == 0 {
+		return
+	}
+	now := time.Now().UTC()
+	for _, c := range changes {
+		// The first poll of a loop only learns the current states.
+		if c.OldState != "" {
+			svc.webhook.notify(PowerChangeEvent{MAC: mac, Port: c.Port, From: c.OldState, To: c.NewState, Timestamp: now, Source: EventSourceWatch})
+		}
+	}
+	for ch := range l.subs {
+		select {
+		case ch <- changes:
+		default:
+			w.logger.Warn("dropping power state changes for a slow subscriber", "mac", mac)
+		}
+	}
+}
+
+// portChanges lists the ports of cur whose state differs from prev, ordered
+// by port.
+func portChanges(prev, cur map[int]PortPowerStatus) []PowerStateChange {
+	var changes []PowerStateChange
+	for port, st := range cur {
+		old := prev[port].State
+		if old == st.State {
+			continue
+		}
+		changes = append(changes, PowerStateChange{Port: port, OldState: old, NewState: st.State, Watts: st.Watts})
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Port < changes[j].Port })
+	return changes
+}
+
+var upgrader = websocket.Upgrader{}
+
+// WatchHandler upgrades the request to a WebSocket and streams the power
+// state changes of the device named by the mac query parameter as JSON
+// arrays of PowerStateChange. The first message carries the state of every
+// port. The host query parameter is handled like in PowerTotalHandler.
+func (b *bmcService) WatchHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	mac := q.Get("mac")
+	logger := b.requestLogger(r).With("mac", mac)
+
+	if mac == "" {
+		writeError(w, ResponsePayload{}, http.StatusBadRequest, ReasonInvalidRequest, "mac query parameter is required")
+		return
+	}
+	svc, err := b.forHost(q.Get("host"))
+	if err != nil {
+		logger.Error("error selecting controller", "error", err)
+		writeError(w, ResponsePayload{}, http.StatusBadRequest, ReasonUnknownHost, err.Error())
+		return
+	}
+
+	// Upgrade answers the client itself when it fails.
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		logger.Error("error upgrading to websocket", "error", err)
+		return
+	}
+	defer conn.Close()
+
+	changes, unsubscribe := b.watcher.subscribe(svc, q.Get("host"), mac)
+	defer unsubscribe()
+
+	// Clients send nothing, reading only notices when they go away.
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, readErr := conn.ReadMessage(); readErr != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case c := <-changes:
+			_ = conn.SetWriteDeadline(time.Now().Add(watchWriteTimeout))
+			if err = conn.WriteJSON(c); err != nil {
+				logger.Info("websocket subscriber went away", "error", err)
+				return
+			}
+		}
+	}
+}
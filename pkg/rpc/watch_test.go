@@ -0,0 +1,106 @@
+package rpc
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestWatchHandler(t *testing.T) {
+	const mac = "aa:bb:cc:dd:ee:ff"
+	fc := NewFakeController()
+	fc.AddSwitch(mac, 2)
+	svc := NewFakeBMCService(fc, nil).(*bmcService)
+
+	srv := httptest.NewServer(http.HandlerFunc(svc.WatchHandler))
+	defer srv.Close()
+	u := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws?mac=" + mac
+
+	dial := func() *websocket.Conn {
+		t.Helper()
+		conn, _, err := websocket.DefaultDialer.Dial(u, nil)
+		if err != nil {
+			t.Fatalf("Dial() error = %v", err)
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		return conn
+	}
+	read := func(conn *websocket.Conn) []PowerStateChange {
+		t.Helper()
+		var changes []PowerStateChange
+		if err := conn.ReadJSON(&changes); err != nil {
+			t.Fatalf("ReadJSON() error = %v", err)
+		}
+		return changes
+	}
+
+	first, second := dial(), dial()
+	want := []PowerStateChange{
+		{Port: 1, NewState: "on"},
+		{Port: 2, NewState: "on"},
+	}
+	for _, conn := range []*websocket.Conn{first, second} {
+		if got := read(conn); len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+			t.Errorf("initial message = %+v, want %+v", got, want)
+		}
+	}
+
+	if err := fc.SetPortState(mac, 2, PoweredOff); err != nil {
+		t.Fatal(err)
+	}
+	wantChange := PowerStateChange{Port: 2, OldState: "on", NewState: "off"}
+	for _, conn := range []*websocket.Conn{first, second} {
+		if got := read(conn); len(got) != 1 || got[0] != wantChange {
+			t.Errorf("change = %+v, want %+v", got, wantChange)
+		}
+	}
+
+	if err := fc.SetProvisioning(mac, true); err != nil {
+		t.Fatal(err)
+	}
+	wantProvisioning := []PowerStateChange{
+		{Port: 1, OldState: "on", NewState: string(PoweringOn)},
+		{Port: 2, OldState: "off", NewState: string(PoweringOff)},
+	}
+	for _, conn := range []*websocket.Conn{first, second} {
+		if got := read(conn); len(got) != 2 || got[0] != wantProvisioning[0] || got[1] != wantProvisioning[1] {
+			t.Errorf("provisioning changes = %+v, want %+v", got, wantProvisioning)
+		}
+	}
+
+	svc.watcher.mu.Lock()
+	loops := len(svc.watcher.loops)
+	svc.watcher.mu.Unlock()
+	if loops != 1 {
+		t.Errorf("subscribers share %d poll loops, want 1", loops)
+	}
+
+	first.Close()
+	second.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		svc.watcher.mu.Lock()
+		loops = len(svc.watcher.loops)
+		svc.watcher.mu.Unlock()
+		if loops == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("poll loop still running after the last subscriber left")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWatchHandler_missingMAC(t *testing.T) {
+	svc := NewFakeBMCService(NewFakeController(), nil)
+	rec := httptest.NewRecorder()
+	svc.WatchHandler(rec, httptest.NewRequest(http.MethodGet, "/ws", http.NoBody))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
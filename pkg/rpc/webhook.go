@@ -0,0 +1,152 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// webhookQueue is how many events may wait for delivery before new ones
+// are dropped.
+const webhookQueue = 64
+
+// Sources of a PowerChangeEvent.
+const (
+	// EventSourceRPC marks a change made through the service.
+	EventSourceRPC = "rpc"
+	// EventSourceWatch marks a change noticed by the WatchHandler poll
+	// loop, which also sees changes made through the service.
+	EventSourceWatch = "watch"
+)
+
+// PowerChangeEvent is posted to the configured webhook when the power state
+// of a port changes. Ports are numbered as on the switch. From is empty
+// when the state before the change is not known.
+type PowerChangeEvent struct {
+	MAC       string    `json:"mac"`
+	Port      int       `json:"port"`
+	From      string    `json:"from,omitempty"`
+	To        string    `json:"to"`
+	Timestamp time.Time `json:"timestamp"`
+	Source    string    `json:"source"`
+}
+
+// webhook delivers PowerChangeEvents to a URL from a single goroutine, in
+// order, so that callers never wait for the receiver. Delivery is best
+// effort: events are dropped when the queue is full or every attempt
+// failed.
+type webhook struct {
+	url     string
+	client  *http.Client
+	retries int
+	logger  *slog.Logger
+	events  chan PowerChangeEvent
+}
+
+// newWebhook validates rawURL and starts delivering to it. Each attempt is
+// bounded by timeout, and failed ones are retried up to retries times.
+func newWebhook(rawURL string, timeout time.Duration, retries int, logger *slog.Logger) (*webhook, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("webhookURL: %w", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("webhookURL: %s is not an http or https URL", u.Redacted())
+	}
+	h := &webhook{
+		url:     rawURL,
+		client:  &http.Client{Timeout: timeout},
+		retries: retries,
+		logger:  logger.With("webhook", u.Host),
+		events:  make(chan PowerChangeEvent, webhookQueue),
+	}
+	go h.run()
+	return h, nil
+}
+
+// notify queues e for delivery. It is a no-op on a nil webhook, which is
+// what the service holds when none is configured.
+func (h *webhook) notify(e PowerChangeEvent) {
+	if h == nil {
+		return
+	}
+	select {
+	case h.events <- e:
+	default:
+		h.logger.Warn("dropping power change event, the webhook is falling behind", "mac", e.MAC, "port", e.Port)
+	}
+}
+
+// notifyPower queues the event for a power change of the switch port p
+// made through the service.
+func (b *bmcService) notifyPower(macAddress string, p int, from, to string) {
+	b.webhook.notify(PowerChangeEvent{
+		MAC:       macAddress,
+		Port:      p,
+		From:      from,
+		To:        to,
+		Timestamp: time.Now().UTC(),
+		Source:    EventSourceRPC,
+	})
+}
+
+func (h *webhook) run() {
+	for e := range h.events {
+		if err := h.deliver(e); err != nil {
+			h.logger.Error("error delivering power change event", "mac", e.MAC, "port", e.Port, "error", err)
+		}
+	}
+}
+
+// deliver posts e, retrying failed attempts with the same backoff as the
+// controller calls. Receivers that answer with a 4xx other than 429 are
+// not retried.
+func (h *webhook) deliver(e PowerChangeEvent) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	delay := retryBaseDelay
+	for attempt := 0; ; attempt++ {
+		var retry bool
+		if retry, err = h.post(body); err == nil || !retry || attempt >= h.retries {
+			return err
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// post makes a single delivery attempt and reports whether a failed one is
+// worth retrying.
+func (h *webhook) post(body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, h.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := h.client.Do(req)
+	if err != nil {
+		// The URL may carry a token, so it is left out of the error.
+		var ue *url.Error
+		if errors.As(err, &ue) {
+			err = ue.Err
+		}
+		return true, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode/100 == 2 {
+		return false, nil
+	}
+	err = fmt.Errorf("unexpected status %s", resp.Status)
+	return resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests, err
+}
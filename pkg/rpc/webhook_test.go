@@ -0,0 +1,156 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// eventSink is a webhook receiver that fails the first failures requests
+// with a 503 and passes the events of the others on.
+func eventSink(t *testing.T, failures int32) (string, <-chan PowerChangeEvent) {
+	t.Helper()
+	events := make(chan PowerChangeEvent, 16)
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) <= failures {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var e PowerChangeEvent
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+			t.Errorf("decoding event: %v", err)
+		}
+		events <- e
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL + "/hook", events
+}
+
+func nextEvent(t *testing.T, events <-chan PowerChangeEvent) PowerChangeEvent {
+	t.Helper()
+	select {
+	case e := <-events:
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event was delivered")
+		return PowerChangeEvent{}
+	}
+}
+
+func TestWebhook_SetPortPower(t *testing.T) {
+	u, events := eventSink(t, 1)
+	fc := &fakeClient{device: newTestDevice("auto", "auto")}
+	svc := newTestService(fc, nil)
+	hook, err := newWebhook(u, time.Second, 2, svc.logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc.webhook = hook
+
+	if _, err = svc.setPortPower(context.Background(), "aa:bb:cc:dd:ee:ff", "2", "off", false); err != nil {
+		t.Fatalf("setPortPower() error = %v", err)
+	}
+	// The first delivery attempt fails and is retried.
+	e := nextEvent(t, events)
+	if e.MAC != "aa:bb:cc:dd:ee:ff" || e.Port != 2 || e.From != PowerStateOn || e.To != PowerStateOff || e.Source != EventSourceRPC {
+		t.Errorf("event = %+v, want port 2 from on to off", e)
+	}
+	if time.Since(e.Timestamp) > time.Minute {
+		t.Errorf("event timestamp = %v, want the time of the change", e.Timestamp)
+	}
+}
+
+func TestWebhook_SetPortPowerBatch(t *testing.T) {
+	u, events := eventSink(t, 0)
+	fc := &fakeClient{device: newTestDevice("auto", "off")}
+	svc := newTestService(fc, nil)
+	hook, err := newWebhook(u, time.Second, 0, svc.logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc.webhook = hook
+
+	// The events carry the states the ports were set to, not the
+	// spelling of the request.
+	if _, err = svc.setPortPowerBatch(context.Background(), "aa:bb:cc:dd:ee:ff", []PortPowerSetParams{
+		{Port: 1, State: "Off"},
+		{Port: 2, State: " ON "},
+	}); err != nil {
+		t.Fatalf("setPortPowerBatch() error = %v", err)
+	}
+	got := map[int]PowerChangeEvent{}
+	for i := 0; i < 2; i++ {
+		e := nextEvent(t, events)
+		got[e.Port] = e
+	}
+	if e := got[1]; e.From != PowerStateOn || e.To != PowerStateOff {
+		t.Errorf("port 1 event = %+v, want from on to off", e)
+	}
+	if e := got[2]; e.From != PowerStateOff || e.To != PowerStateOn {
+		t.Errorf("port 2 event = %+v, want from off to on", e)
+	}
+}
+
+func TestWebhook_WatchChanges(t *testing.T) {
+	const mac = "aa:bb:cc:dd:ee:ff"
+	u, events := eventSink(t, 0)
+	fc := NewFakeController()
+	fc.AddSwitch(mac, 2)
+	svc := NewFakeBMCService(fc, nil).(*bmcService)
+	hook, err := newWebhook(u, time.Second, 0, svc.logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc.webhook = hook
+
+	w := newPowerWatcher(time.Hour, svc.logger)
+	l := &watchLoop{subs: map[chan []PowerStateChange]struct{}{}}
+	w.poll(context.Background(), l, svc, mac)
+	if err = fc.SetPortState(mac, 1, PoweredOff); err != nil {
+		t.Fatal(err)
+	}
+	w.poll(context.Background(), l, svc, mac)
+
+	// The first poll only learns the states and fires nothing.
+	e := nextEvent(t, events)
+	if e.Port != 1 || e.From != PowerStateOn || e.To != PowerStateOff || e.Source != EventSourceWatch {
+		t.Errorf("event = %+v, want port 1 from on to off", e)
+	}
+	select {
+	case e = <-events:
+		t.Errorf("unexpected event %+v", e)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestWebhook_DoesNotRetryClientErrors(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	h := &webhook{url: srv.URL, client: srv.Client(), retries: 3, logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	if err := h.deliver(PowerChangeEvent{Port: 1}); err == nil {
+		t.Error("deliver() to a receiver answering 400 did not fail")
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("receiver was called %d times, want 1", n)
+	}
+}
+
+func TestNewWebhook_InvalidURL(t *testing.T) {
+	for _, u := range []string{"ftp://example.com/hook", "example.com/hook", "http://"} {
+		if _, err := newWebhook(u, time.Second, 0, slog.Default()); err == nil {
+			t.Errorf("newWebhook(%q) did not fail", u)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package rpc
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// defaultWoLAddress is where magic packets are sent unless configured,
+// the limited broadcast address on the discard port.
+const defaultWoLAddress = "255.255.255.255:9"
+
+// wakeOnLAN sends Wake-on-LAN magic packets to the machines behind ports,
+// for machines that are halted while their port is powered.
+type wakeOnLAN struct {
+	addr string
+	// macs are the MAC addresses of the machines, by logical port.
+	macs map[int]net.HardwareAddr
+}
+
+// newWakeOnLAN validates the MAC addresses configured for the ports. The
+// packets go to addr, defaultWoLAddress when empty.
+func newWakeOnLAN(macs map[int]string, addr string) (*wakeOnLAN, error) {
+	if addr == "" {
+		addr = defaultWoLAddress
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return nil, fmt.Errorf("wolAddress: %w", err)
+	}
+	w := &wakeOnLAN{addr: addr, macs: make(map[int]net.HardwareAddr, len(macs))}
+	for p, s := range macs {
+		if p < 1 {
+			return nil, fmt.Errorf("wolMACs: %d is not a port number", p)
+		}
+		mac, err := net.ParseMAC(s)
+		if err != nil || len(mac) != 6 {
+			return nil, fmt.Errorf("wolMACs: port %d: %q is not a MAC address", p, s)
+		}
+		w.macs[p] = mac
+	}
+	return w, nil
+}
+
+// magicPacket returns the Wake-on-LAN packet for mac: six 0xff bytes
+// followed by the address repeated 16 times.
+func magicPacket(mac net.HardwareAddr) []byte {
+	return append(bytes.Repeat([]byte{0xff}, 6), bytes.Repeat(mac, 16)...)
+}
+
+// wake sends a magic packet to the machine on the logical port p. It
+// reports false without sending anything when no MAC is configured for
+// the port, or on a nil wakeOnLAN.
+func (w *wakeOnLAN) wake(p int) (bool, error) {
+	if w == nil {
+		return false, nil
+	}
+	mac, ok := w.macs[p]
+	if !ok {
+		return false, nil
+	}
+	conn, err := net.Dial("udp", w.addr)
+	if err != nil {
+		return false, fmt.Errorf("error sending magic packet to %s: %w", mac, err)
+	}
+	defer conn.Close()
+	if _, err = conn.Write(magicPacket(mac)); err != nil {
+		return false, fmt.Errorf("error sending magic packet to %s: %w", mac, err)
+	}
+	return true, nil
+}
+
+// wakePort sends a best effort magic packet to the machine on portIdx, a
+// port number or alias as in the request, after it was powered on. The
+// port has already been confirmed on, so a failure is only logged.
+func (b *bmcService) wakePort(macAddress, portIdx string) bool {
+	if b.wol == nil {
+		return false
+	}
+	p, ok := b.aliases[portIdx]
+	if !ok {
+		var err error
+		if p, err = strconv.Atoi(portIdx); err != nil {
+			return false
+		}
+	}
+	sent, err := b.wol.wake(p)
+	if err != nil {
+		b.logger.Warn("error waking the machine", "mac", macAddress, "port", portIdx, "error", err)
+	}
+	return sent
+}
@@ -0,0 +1,105 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSetPortPower_WakeOnLAN(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	svc := newTestService(&fakeClient{device: newTestDevice("auto", "off")}, nil)
+	if svc.wol, err = newWakeOnLAN(map[int]string{1: "52:54:00:12:34:56"}, conn.LocalAddr().String()); err != nil {
+		t.Fatal(err)
+	}
+	svc.aliases = portAliases{"node-01": 1}
+
+	// Port 1 is already on, which is when a halted machine needs waking.
+	res, err := svc.setPortPower(context.Background(), "aa:bb:cc:dd:ee:ff", "node-01", "on", false)
+	if err != nil {
+		t.Fatalf("setPortPower() error = %v", err)
+	}
+	if !res.WakeSent {
+		t.Error("setPortPower() did not report the magic packet")
+	}
+
+	buf := make([]byte, 256)
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("reading the magic packet: %v", err)
+	}
+	want := []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	for i := 0; i < 16; i++ {
+		want = append(want, 0x52, 0x54, 0x00, 0x12, 0x34, 0x56)
+	}
+	if !bytes.Equal(buf[:n], want) {
+		t.Errorf("packet = % x, want % x", buf[:n], want)
+	}
+
+	// Port 2 has no MAC and turning port 1 off wakes nothing.
+	for _, set := range []struct{ port, state string }{{"2", "on"}, {"1", "off"}} {
+		if res, err = svc.setPortPower(context.Background(), "aa:bb:cc:dd:ee:ff", set.port, set.state, false); err != nil {
+			t.Fatalf("setPortPower(%s, %s) error = %v", set.port, set.state, err)
+		}
+		if res.WakeSent {
+			t.Errorf("setPortPower(%s, %s) sent a magic packet", set.port, set.state)
+		}
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if n, _, err = conn.ReadFrom(buf); err == nil {
+		t.Errorf("unexpected packet % x", buf[:n])
+	}
+}
+
+func TestSetPortPowerBatch_WakeOnLAN(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	svc := newTestService(&fakeClient{device: newTestDevice("off", "auto")}, nil)
+	if svc.wol, err = newWakeOnLAN(map[int]string{1: "52:54:00:12:34:56", 2: "52:54:00:12:34:57"}, conn.LocalAddr().String()); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := svc.setPortPowerBatch(context.Background(), "aa:bb:cc:dd:ee:ff", []PortPowerSetParams{
+		{Port: 1, State: "ON"},
+		{Port: 2, State: "off"},
+	})
+	if err != nil {
+		t.Fatalf("setPortPowerBatch() error = %v", err)
+	}
+	if !results[0].WakeSent || results[1].WakeSent {
+		t.Errorf("results = %+v, want only port 1 woken", results)
+	}
+
+	buf := make([]byte, 256)
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("reading the magic packet: %v", err)
+	}
+	if !bytes.HasSuffix(buf[:n], []byte{0x52, 0x54, 0x00, 0x12, 0x34, 0x56}) {
+		t.Errorf("packet = % x, want the one of port 1", buf[:n])
+	}
+}
+
+func TestNewWakeOnLAN_Invalid(t *testing.T) {
+	for _, macs := range []map[int]string{{1: "not-a-mac"}, {0: "52:54:00:12:34:56"}, {1: "00:00:00:00:fe:80:00:00:00:00:00:00:02:00:5e:10:00:00:00:01"}} {
+		if _, err := newWakeOnLAN(macs, ""); err == nil {
+			t.Errorf("newWakeOnLAN(%v) accepted an invalid MAC", macs)
+		}
+	}
+	if _, err := newWakeOnLAN(nil, "255.255.255.255"); err == nil {
+		t.Error("newWakeOnLAN() accepted an address without a port")
+	}
+}
@@ -0,0 +1,120 @@
+// Package rpcclient calls the port RPC endpoint of the server, building the
+// RequestPayload of each call and decoding its ResponsePayload.
+package rpcclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+
+	"github.com/ubiquiti-community/unifi-rpc/pkg/rpc"
+)
+
+// maxResponseBytes bounds the responses read, which are small JSON bodies.
+const maxResponseBytes = 1 << 20
+
+// Client calls the RPC methods of the ports of a single device. The zero
+// value is not usable, use New.
+type Client struct {
+	baseURL string
+	mac     string
+	// Host selects the controller of the device on servers configured
+	// with several, see RequestPayload.Host.
+	Host string
+	// Token is sent as the bearer token of every call when set.
+	Token string
+	// HTTPClient makes the calls, http.DefaultClient when nil.
+	HTTPClient *http.Client
+
+	nextID atomic.Int64
+}
+
+// New returns a Client for the device mac of the server at baseURL, for
+// example http://localhost:5000, including the --base-path of the server.
+func New(baseURL, mac string) *Client {
+	return &Client{baseURL: strings.TrimSuffix(baseURL, "/"), mac: mac}
+}
+
+// PowerGet returns the power state of port, a port number or alias.
+func (c *Client) PowerGet(ctx context.Context, port string) (string, error) {
+	var state string
+	err := c.call(ctx, port, rpc.PowerGetMethod, nil, &state)
+	return state, err
+}
+
+// PowerSet sets the power state of port to one of the rpc.PowerState
+// constants.
+func (c *Client) PowerSet(ctx context.Context, port, state string) (rpc.PowerSetResult, error) {
+	var res rpc.PowerSetResult
+	err := c.call(ctx, port, rpc.PowerSetMethod, rpc.PowerSetParams{State: state}, &res)
+	return res, err
+}
+
+// PowerCycle power cycles port.
+func (c *Client) PowerCycle(ctx context.Context, port string) (rpc.PowerSetResult, error) {
+	return c.PowerSet(ctx, port, rpc.PowerStateCycle)
+}
+
+// GetSwitchInfo returns the model and firmware version of the device.
+func (c *Client) GetSwitchInfo(ctx context.Context) (rpc.SwitchInfo, error) {
+	var info rpc.SwitchInfo
+	err := c.call(ctx, "", rpc.SwitchInfoMethod, nil, &info)
+	return info, err
+}
+
+// Ping checks that the server is up, and with --ping-checks-switch that
+// the device answers the controller.
+func (c *Client) Ping(ctx context.Context) error {
+	return c.call(ctx, "", rpc.PingMethod, nil, nil)
+}
+
+// call makes a single RPC call and decodes its result into result, unless
+// nil. Calls the server answers with an error return its *rpc.ResponseError.
+func (c *Client) call(ctx context.Context, port string, method rpc.Method, params, result any) error {
+	body, err := json.Marshal(rpc.RequestPayload{ID: c.nextID.Add(1), Host: c.Host, Method: method, Params: params})
+	if err != nil {
+		return err
+	}
+
+	// An empty port addresses the default port of the server.
+	path := "/device/" + url.PathEscape(c.mac)
+	if port != "" {
+		path += "/port/" + url.PathEscape(port)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+path+"/rpc", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return fmt.Errorf("error calling %s: %w", method, err)
+	}
+	defer resp.Body.Close()
+
+	rp := rpc.ResponsePayload{Result: result}
+	if err = json.NewDecoder(io.LimitReader(resp.Body, maxResponseBytes)).Decode(&rp); err != nil {
+		return fmt.Errorf("error decoding %s response with status %s: %w", method, resp.Status, err)
+	}
+	if rp.Error != nil {
+		return rp.Error
+	}
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s answered with status %s", method, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package rpcclient_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/ubiquiti-community/unifi-rpc/pkg/rpc"
+	"github.com/ubiquiti-community/unifi-rpc/pkg/rpcclient"
+)
+
+const mac = "aa:bb:cc:dd:ee:ff"
+
+// newServer serves the RPC routes of a fake service for a switch with 8
+// ports, all on, and only answers requests carrying token.
+func newServer(t *testing.T, token string) (*httptest.Server, *rpc.FakeController) {
+	t.Helper()
+	fc := rpc.NewFakeController()
+	fc.AddSwitch(mac, 8)
+	svc := rpc.NewFakeBMCService(fc, nil)
+
+	r := mux.NewRouter()
+	r.HandleFunc("/device/{mac}/port/{port}/rpc", svc.RPCHandler).Methods("POST")
+	r.HandleFunc("/device/{mac}/rpc", svc.RPCHandler).Methods("POST")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "Bearer "+token {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		r.ServeHTTP(w, req)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, fc
+}
+
+func TestClient_Power(t *testing.T) {
+	srv, fc := newServer(t, "secret")
+	c := rpcclient.New(srv.URL+"/", mac)
+	c.Token = "secret"
+	ctx := context.Background()
+
+	if err := c.Ping(ctx); err != nil {
+		t.Fatalf("Ping() error = %v", err)
+	}
+	if info, err := c.GetSwitchInfo(ctx); err != nil || info.MAC != mac {
+		t.Fatalf("GetSwitchInfo() = %+v, %v, want the switch", info, err)
+	}
+	if st, err := c.PowerGet(ctx, "2"); err != nil || st != rpc.PowerStateOn {
+		t.Fatalf("PowerGet() = %q, %v, want %q", st, err, rpc.PowerStateOn)
+	}
+
+	res, err := c.PowerSet(ctx, "2", rpc.PowerStateOff)
+	if err != nil {
+		t.Fatalf("PowerSet() error = %v", err)
+	}
+	if want := (rpc.PowerSetResult{Previous: rpc.PowerStateOn, Current: rpc.PowerStateOff}); res != want {
+		t.Errorf("PowerSet() = %+v, want %+v", res, want)
+	}
+	if st, _ := fc.PortState(mac, 2); st != rpc.PoweredOff {
+		t.Errorf("port 2 is %q, want %q", st, rpc.PoweredOff)
+	}
+
+	if _, err = c.PowerCycle(ctx, "3"); err != nil {
+		t.Errorf("PowerCycle() error = %v", err)
+	}
+}
+
+func TestClient_Errors(t *testing.T) {
+	srv, _ := newServer(t, "secret")
+	c := rpcclient.New(srv.URL, mac)
+	c.Token = "secret"
+
+	_, err := c.PowerGet(context.Background(), "99")
+	var re *rpc.ResponseError
+	if !errors.As(err, &re) {
+		t.Fatalf("PowerGet() error = %v, want a *rpc.ResponseError", err)
+	}
+	if re.Code != http.StatusNotFound || re.Reason != rpc.ReasonPortNotFound {
+		t.Errorf("error = %+v, want a 404 %s", re, rpc.ReasonPortNotFound)
+	}
+
+	// Errors not answered by the RPC handler carry no ResponsePayload.
+	c.Token = "wrong"
+	if err = c.Ping(context.Background()); err == nil || errors.As(err, &re) {
+		t.Errorf("Ping() with a wrong token error = %v, want a decoding error", err)
+	}
+}